@@ -0,0 +1,53 @@
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+//地址的格式：base58(版本号1字节 + 公钥哈希20字节 + 校验码4字节)
+//版本号就是钱包的密钥类型，地址是否使用已知的密钥类型由wallet包判断
+
+const (
+	pubKeyHashLen = 20
+	checksumLen   = 4
+	addressLen    = 1 + pubKeyHashLen + checksumLen
+)
+
+var ErrInvalidAddress = errors.New("无效的地址")
+
+//两次sha256，取前4个字节
+func CheckSum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:checksumLen]
+}
+
+func Encode(version byte, pubKeyHash []byte) string {
+	payload := append([]byte{version}, pubKeyHash...)
+	payload = append(payload, CheckSum(payload)...)
+
+	return EncodeBase58(payload)
+}
+
+//解码地址并检查长度和校验码，返回版本号和公钥哈希
+func Decode(addr string) (byte, []byte, error) {
+	data, err := DecodeBase58(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(data) != addressLen {
+		return 0, nil, fmt.Errorf("%w: %s 长度不正确", ErrInvalidAddress, addr)
+	}
+
+	payload := data[:len(data)-checksumLen]
+	if !bytes.Equal(CheckSum(payload), data[len(data)-checksumLen:]) {
+		return 0, nil, fmt.Errorf("%w: %s 校验码不正确", ErrInvalidAddress, addr)
+	}
+
+	return payload[0], payload[1:], nil
+}
@@ -0,0 +1,65 @@
+package address
+
+import (
+	"fmt"
+	"math/big"
+)
+
+//比特币使用的base58字母表，去掉了容易混淆的0、O、I、l
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var decodeMap [256]int8
+
+func init() {
+	for i := range decodeMap {
+		decodeMap[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		decodeMap[alphabet[i]] = int8(i)
+	}
+}
+
+var bigRadix = big.NewInt(58)
+
+//把字节流编码为base58字符串，开头的每个0字节编码为一个'1'
+func EncodeBase58(data []byte) string {
+	x := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, bigRadix, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func DecodeBase58(s string) ([]byte, error) {
+	x := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		digit := decodeMap[s[i]]
+		if digit < 0 {
+			return nil, fmt.Errorf("%w: 非法字符 %q", ErrInvalidAddress, s[i])
+		}
+		x.Mul(x, bigRadix)
+		x.Add(x, big.NewInt(int64(digit)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
@@ -1,4 +1,4 @@
-package main
+package block
 
 import (
 	//"bytes"
@@ -6,11 +6,11 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/gob"
-	"log"
-	"time"
-)
+	"fmt"
 
-const genesisInfo = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/tx"
+)
 
 type Block struct {
 	Version       uint64 //区块版本号
@@ -20,17 +20,24 @@ type Block struct {
 	Difficulity   uint64 //挖矿的难度值，v2的时候使用
 	Nonce         uint64 //随机数，挖矿找的就是它
 	//Data          []byte //数据，目前使用字节 流，v4开始使用交易代替
-	Transactions []*Transaction
+	Transactions []*tx.Transaction
 	Hash          []byte //当前区块哈希，区块中本不存在的字段，为了方便我们添加进来
+	Pruned        bool   //区块体已经被裁剪，只剩区块头，不参与编码
 }
 
-//模拟梅克尔根
+//计算梅克尔根，版本1开始使用梅克尔树，见merkle.go
 func (block *Block) HashTransactions() {
+	if block.Version >= blockVersionMerkle {
+		block.MerkleRoot = merkleRoot(block.txHashes())
+		return
+	}
+
+	//版本0模拟梅克尔根
 	//我们的交易的id就是交易的哈希值，所以我们可以将交易id拼接起来，整体做 一次哈希运算，作为MerkleRoot
 	var hashes []byte
 
-	for _,tx := range block.Transactions {
-		txid := tx.TXid
+	for _,t := range block.Transactions {
+		txid := t.TXid
 		hashes = append(hashes,txid...)
 	}
 
@@ -38,53 +45,63 @@ func (block *Block) HashTransactions() {
 	block.MerkleRoot = hash[:]
 }
 
-func NewBlock(txs []*Transaction, prevBlockHash []byte) *Block {
+//创建还没有挖矿的区块，Hash和Nonce由pow包挖矿时填写
+func New(txs []*tx.Transaction, prevBlockHash []byte, version uint64, timeStamp uint64, bits uint64) *Block {
 	block := Block{
-		Version:       00,
+		Version:       version,
 		PrevBlockHash: prevBlockHash,
 		MerkleRoot:    []byte{},
-		TimeStamp:     uint64(time.Now().Unix()),
-		Difficulity:   Bits, //v2再调整
-		Nonce:         10, //同Difficulity
-		//Data:          []byte(data),
-		Transactions:txs,
+		TimeStamp:     timeStamp,
+		Difficulity:   bits,
+		Nonce:         10, //挖矿时重新查找
+		Transactions:  txs,
 		Hash:          []byte{}, //先填充为空
 	}
-	//block.SetHash()
 
 	block.HashTransactions()
 
-	pow := NewProofOfWork(&block)
-	hash,nonce := pow.Run()
-	block.Hash = hash
-	block.Nonce = nonce
-
 	return &block
 }
 
-// 序列化，将区块转换成字节流
+//只有区块头的区块
+func (block *Block) Header() *Block {
+	header := *block
+	header.Transactions = nil
+	return &header
+}
+
+// 序列化，将区块转换成字节流，使用serialize.go中的规范编码
 func (block *Block) Serialize() []byte {
-	var buffer bytes.Buffer
+	var e wire.Encoder
+	block.encode(&e)
 
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(block)
-	if err != nil {
-		log.Panic(err)
+	return e.Bytes()
+}
+
+func Deserialize(data []byte) (*Block, error) {
+	var block Block
+
+	d := wire.NewDecoder(data)
+	block.decode(d)
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBlock, err)
 	}
 
-	return buffer.Bytes()
+	return &block, nil
 }
 
-func Deserialize(data []byte) *Block {
+
+//旧版本使用gob序列化区块，只在migrateDB迁移数据库时使用
+func DeserializeGob(data []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return &block
+	return &block, nil
 }
 
 /*func (block *Block) SetHash() {
@@ -0,0 +1,5 @@
+package block
+
+import "errors"
+
+var ErrCorruptBlock = errors.New("区块数据损坏")
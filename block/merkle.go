@@ -0,0 +1,121 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+//梅克尔树：叶子是交易id，每两个节点拼接后做哈希得到父节点，奇数个节点时复制最后一个
+//版本0的区块使用的是"所有交易id拼接后做一次哈希"，无法给出单个交易的证明，SPV只能拿到全部交易id
+
+//从这个版本开始，区块的MerkleRoot是真正的梅克尔树根
+const blockVersionMerkle = 1
+
+func hashMerkleNodes(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return hash[:]
+}
+
+//计算上一层节点
+func nextMerkleLevel(level [][]byte) [][]byte {
+	if len(level)%2 == 1 {
+		level = append(level, level[len(level)-1])
+	}
+
+	next := make([][]byte, 0, len(level)/2)
+	for i := 0; i < len(level); i += 2 {
+		next = append(next, hashMerkleNodes(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, sha256.Size)
+	}
+
+	level := hashes
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0]
+}
+
+//第index个叶子的梅克尔路径：从叶子到根，每一层的兄弟节点
+func merkleBranch(hashes [][]byte, index int) [][]byte {
+	var branch [][]byte
+
+	level := hashes
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		branch = append(branch, level[sibling])
+
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+
+	return branch
+}
+
+//由叶子和路径计算梅克尔根，index的每一位决定这一层的叶子在左边还是右边
+func merkleRootFromBranch(leaf []byte, index int, branch [][]byte) []byte {
+	hash := leaf
+	for _, sibling := range branch {
+		if index&1 == 0 {
+			hash = hashMerkleNodes(hash, sibling)
+		} else {
+			hash = hashMerkleNodes(sibling, hash)
+		}
+		index >>= 1
+	}
+	return hash
+}
+
+//交易在区块中的梅克尔证明
+//版本0的区块Branch是区块中的全部交易id
+type MerkleProof struct {
+	Index  int      `json:"index"`
+	Branch [][]byte `json:"branch"`
+}
+
+func (block *Block) txHashes() [][]byte {
+	hashes := make([][]byte, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, tx.TXid)
+	}
+	return hashes
+}
+
+func (block *Block) MerkleProof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(block.Transactions) {
+		return nil, errors.New("交易下标越界")
+	}
+
+	hashes := block.txHashes()
+	if block.Version < blockVersionMerkle {
+		return &MerkleProof{Index: index, Branch: hashes}, nil
+	}
+
+	return &MerkleProof{Index: index, Branch: merkleBranch(hashes, index)}, nil
+}
+
+//校验txid在区块头header对应的区块中
+func (p *MerkleProof) Verify(header *Block, txid []byte) bool {
+	if header.Version < blockVersionMerkle {
+		if p.Index < 0 || p.Index >= len(p.Branch) || !bytes.Equal(p.Branch[p.Index], txid) {
+			return false
+		}
+		hash := sha256.Sum256(bytes.Join(p.Branch, nil))
+		return bytes.Equal(hash[:], header.MerkleRoot)
+	}
+
+	//路径的长度决定了树的高度，index不能超出这个范围
+	if p.Index < 0 || len(p.Branch) >= 31 || p.Index >= 1<<uint(len(p.Branch)) {
+		return false
+	}
+	return bytes.Equal(merkleRootFromBranch(txid, p.Index, p.Branch), header.MerkleRoot)
+}
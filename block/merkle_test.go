@@ -0,0 +1,99 @@
+package block
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for _, version := range []uint64{0, blockVersionMerkle} {
+		//奇数个节点时复制最后一个，覆盖每一层奇偶的情况
+		for n := 1; n <= 9; n++ {
+			b := newTestBlock(version, n)
+			for i := 0; i < n; i++ {
+				t.Run(fmt.Sprintf("version %d %d txs index %d", version, n, i), func(t *testing.T) {
+					proof, err := b.MerkleProof(i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !proof.Verify(b.Header(), b.Transactions[i].TXid) {
+						t.Fatal("valid proof rejected")
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestMerkleProofRejects(t *testing.T) {
+	b := newTestBlock(blockVersionMerkle, 5)
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name   string
+		index  int
+		tamper func(p *MerkleProof, header *Block) []byte
+	}{
+		{"wrong txid", 2, func(p *MerkleProof, header *Block) []byte { return other[:] }},
+		{"other tx", 2, func(p *MerkleProof, header *Block) []byte { return b.Transactions[3].TXid }},
+		{"wrong index", 2, func(p *MerkleProof, header *Block) []byte {
+			p.Index = 3
+			return b.Transactions[2].TXid
+		}},
+		{"index out of range", 2, func(p *MerkleProof, header *Block) []byte {
+			p.Index += 1 << uint(len(p.Branch))
+			return b.Transactions[2].TXid
+		}},
+		{"negative index", 0, func(p *MerkleProof, header *Block) []byte {
+			p.Index = -1
+			return b.Transactions[0].TXid
+		}},
+		{"tampered branch", 4, func(p *MerkleProof, header *Block) []byte {
+			p.Branch[0] = other[:]
+			return b.Transactions[4].TXid
+		}},
+		{"truncated branch", 1, func(p *MerkleProof, header *Block) []byte {
+			p.Branch = p.Branch[:len(p.Branch)-1]
+			return b.Transactions[1].TXid
+		}},
+		{"wrong root", 1, func(p *MerkleProof, header *Block) []byte {
+			header.MerkleRoot = other[:]
+			return b.Transactions[1].TXid
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof, err := b.MerkleProof(tt.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := b.Header()
+			txid := tt.tamper(proof, header)
+			if proof.Verify(header, txid) {
+				t.Fatal("tampered proof verified")
+			}
+		})
+	}
+
+	for _, index := range []int{-1, 5} {
+		if _, err := b.MerkleProof(index); err == nil {
+			t.Fatalf("MerkleProof(%d) succeeded, want error", index)
+		}
+	}
+}
+
+//版本0的证明是全部交易id，改动任何一个都和梅克尔根不一致
+func TestMerkleProofVersion0Rejects(t *testing.T) {
+	b := newTestBlock(0, 3)
+	proof, err := b.MerkleProof(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof.Branch[2] = []byte("other")
+	if proof.Verify(b.Header(), b.Transactions[1].TXid) {
+		t.Fatal("tampered version 0 proof verified")
+	}
+}
@@ -0,0 +1,91 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/tx"
+)
+
+//区块的规范编码，编码规则见wire包
+
+//区块记录的魔数，用来区分旧的gob记录
+var blockMagic = []byte("btcb")
+
+//当前区块的编码版本
+const blockEncodingVersion = 1
+
+func (block *Block) encode(e *wire.Encoder) {
+	e.PutRaw(blockMagic)
+	e.PutUvarint(blockEncodingVersion)
+
+	e.PutUint64(block.Version)
+	e.PutBytes(block.PrevBlockHash)
+	e.PutBytes(block.MerkleRoot)
+	e.PutUint64(block.TimeStamp)
+	e.PutUint64(block.Difficulity)
+	e.PutUint64(block.Nonce)
+
+	//迁移过来的旧交易的id不是编码的哈希，所以区块中单独保存每个交易的id
+	e.PutUvarint(uint64(len(block.Transactions)))
+	for _, t := range block.Transactions {
+		e.PutBytes(t.TXid)
+		t.Encode(e)
+	}
+
+	e.PutBytes(block.Hash)
+}
+
+func (block *Block) decode(d *wire.Decoder) {
+	if magic := d.GetRaw(len(blockMagic)); !bytes.Equal(magic, blockMagic) {
+		d.Fail(errors.New("不是规范编码的区块，旧的gob数据库请先执行migrateDB"))
+		return
+	}
+
+	if version := d.GetUvarint(); d.Err() == nil && version != blockEncodingVersion {
+		d.Fail(fmt.Errorf("不支持的区块编码版本: %d", version))
+		return
+	}
+
+	block.Version = d.GetUint64()
+	block.PrevBlockHash = d.GetBytes()
+	block.MerkleRoot = d.GetBytes()
+	block.TimeStamp = d.GetUint64()
+	block.Difficulity = d.GetUint64()
+	block.Nonce = d.GetUint64()
+
+	//每个交易至少占5个字节
+	if n := d.GetCount(); n > 0 {
+		block.Transactions = make([]*tx.Transaction, 0, n/5)
+		for i := 0; i < n && d.Err() == nil; i++ {
+			t := tx.Transaction{TXid: d.GetBytes()}
+			t.Decode(d)
+			block.Transactions = append(block.Transactions, &t)
+		}
+	}
+
+	block.Hash = d.GetBytes()
+}
+
+//是否是规范编码的区块记录
+func IsCanonical(data []byte) bool {
+	return bytes.HasPrefix(data, blockMagic)
+}
+
+//解码只有区块头的区块，区块头中不能有交易
+func DecodeHeader(data []byte) (*Block, error) {
+	var header Block
+
+	d := wire.NewDecoder(data)
+	header.decode(d)
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	if len(header.Transactions) != 0 {
+		return nil, errors.New("区块头中不应该有交易")
+	}
+
+	return &header, nil
+}
@@ -0,0 +1,90 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ELOATS/btc/tx"
+)
+
+func newTestBlock(version uint64, n int) *Block {
+	var txs []*tx.Transaction
+	for i := 0; i < n; i++ {
+		t := &tx.Transaction{Version: tx.TxVersion, TXOutputs: []tx.TXOutput{{Value: float64(i)}}}
+		t.SetTXID()
+		txs = append(txs, t)
+	}
+	return New(txs, []byte("prev"), version, 1000, 0)
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		block *Block
+	}{
+		{"no transactions", New(nil, nil, blockVersionMerkle, 1, 2)},
+		{"one transaction", newTestBlock(blockVersionMerkle, 1)},
+		{"several transactions", newTestBlock(blockVersionMerkle, 4)},
+		{"version 0", newTestBlock(0, 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.block.Hash = []byte("hash")
+			data := tt.block.Serialize()
+			if !IsCanonical(data) {
+				t.Fatal("IsCanonical = false")
+			}
+
+			decoded, err := Deserialize(data)
+			if err != nil {
+				t.Fatalf("Deserialize: %v", err)
+			}
+			if !bytes.Equal(decoded.Serialize(), data) {
+				t.Fatal("re-encoding changed the bytes")
+			}
+			if !reflect.DeepEqual(decoded.Header(), tt.block.Header()) {
+				t.Fatalf("decoded header = %+v, want %+v", decoded.Header(), tt.block.Header())
+			}
+			if len(decoded.Transactions) != len(tt.block.Transactions) {
+				t.Fatalf("decoded %d transactions, want %d", len(decoded.Transactions), len(tt.block.Transactions))
+			}
+		})
+	}
+}
+
+func TestDeserializeRejects(t *testing.T) {
+	data := newTestBlock(blockVersionMerkle, 2).Serialize()
+
+	badVersion := append([]byte{}, data...)
+	badVersion[len(blockMagic)] = blockEncodingVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"gob record", []byte{0x0f, 0xff, 0x81}},
+		{"unknown encoding version", badVersion},
+		{"truncated", data[:len(data)-1]},
+		{"trailing bytes", append(append([]byte{}, data...), 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Deserialize(tt.data); !errors.Is(err, ErrCorruptBlock) {
+				t.Fatalf("Deserialize = %v, want ErrCorruptBlock", err)
+			}
+		})
+	}
+
+	//区块头中不能有交易
+	if _, err := DecodeHeader(data); err == nil {
+		t.Fatal("DecodeHeader accepted a block with transactions")
+	}
+	if _, err := DecodeHeader(newTestBlock(blockVersionMerkle, 2).Header().Serialize()); err != nil {
+		t.Fatalf("DecodeHeader: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//地址索引(可选)：公钥哈希 -> 和这个地址有关的交易列表，按高度排列
+//开启之后在区块连接和断开时更新，查询余额和交易历史不需要遍历UTXO集合和区块
+//用reindexAddresses开启或重建，开启时已经被裁剪的区块不在索引中
+
+const (
+	addrIndexBucketName = "addrIndexBucket" //公钥哈希 -> 交易记录列表
+	addrIndexKey        = "addrIndexFrom"   //链状态中的记录，索引从哪个高度开始，没有这条记录表示没有开启
+)
+
+//地址索引中的一条记录
+type addrIndexRecord struct {
+	TXID    []byte
+	Height  uint64
+	TxIndex int     //交易在区块中的序号
+	Funded  []int64 //给地址转账的output的索引
+	Spent   bool    //交易的input花费了地址的output
+}
+
+func encodeAddrRecords(records []addrIndexRecord) []byte {
+	var e wire.Encoder
+	e.PutUvarint(uint64(len(records)))
+	for _, r := range records {
+		e.PutBytes(r.TXID)
+		e.PutUvarint(r.Height)
+		e.PutUvarint(uint64(r.TxIndex))
+		e.PutUvarint(uint64(len(r.Funded)))
+		for _, index := range r.Funded {
+			e.PutUvarint(uint64(index))
+		}
+		if r.Spent {
+			e.PutUvarint(1)
+		} else {
+			e.PutUvarint(0)
+		}
+	}
+	return e.Bytes()
+}
+
+func decodeAddrRecords(data []byte) ([]addrIndexRecord, error) {
+	d := wire.NewDecoder(data)
+	n := d.GetCount()
+
+	records := make([]addrIndexRecord, 0, n)
+	for i := 0; i < n; i++ {
+		var r addrIndexRecord
+		r.TXID = d.GetBytes()
+		r.Height = d.GetUvarint()
+		r.TxIndex = int(d.GetUvarint())
+		funded := d.GetCount()
+		for j := 0; j < funded; j++ {
+			r.Funded = append(r.Funded, int64(d.GetUvarint()))
+		}
+		r.Spent = d.GetUvarint() != 0
+		records = append(records, r)
+	}
+
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+//地址索引从哪个高度开始，没有开启时返回false
+func addrIndexFrom(btx store.Tx) (uint64, bool) {
+	state := btx.Bucket([]byte(chainStateBucketName))
+	if state == nil || state.Get([]byte(addrIndexKey)) == nil {
+		return 0, false
+	}
+	return getStateUint(state, addrIndexKey), true
+}
+
+//区块中每个地址的交易记录，按交易的顺序
+func blockAddrRecords(b *block.Block, height uint64) map[string][]addrIndexRecord {
+	records := make(map[string][]addrIndexRecord)
+
+	for i, t := range b.Transactions {
+		touched := make(map[string]*addrIndexRecord)
+		var order []string
+
+		record := func(pubKeyHash []byte) *addrIndexRecord {
+			key := string(pubKeyHash)
+			if r, ok := touched[key]; ok {
+				return r
+			}
+			r := &addrIndexRecord{TXID: t.TXid, Height: height, TxIndex: i}
+			touched[key] = r
+			order = append(order, key)
+			return r
+		}
+
+		if !t.IsCoinbase() {
+			for _, input := range t.TXInputs {
+				record(wallet.HashPubKey(input.PubKey)).Spent = true
+			}
+		}
+		for j, output := range t.TXOutputs {
+			if output.IsDataOutput() {
+				continue
+			}
+			r := record(output.PubKeyHash)
+			r.Funded = append(r.Funded, int64(j))
+		}
+
+		for _, key := range order {
+			records[key] = append(records[key], *touched[key])
+		}
+	}
+
+	return records
+}
+
+//连接区块时把区块中的交易加入地址索引，没有开启索引时什么都不做
+func connectAddrIndex(btx store.Tx, b *block.Block, height uint64) error {
+	if _, ok := addrIndexFrom(btx); !ok {
+		return nil
+	}
+	index := btx.Bucket([]byte(addrIndexBucketName))
+
+	for key, added := range blockAddrRecords(b, height) {
+		var records []addrIndexRecord
+		if data := index.Get([]byte(key)); data != nil {
+			var err error
+			records, err = decodeAddrRecords(data)
+			if err != nil {
+				return fmt.Errorf("地址索引 %x: %w", key, err)
+			}
+		}
+
+		if err := index.Put([]byte(key), encodeAddrRecords(append(records, added...))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//断开区块时删除区块中的交易，它们一定在每个地址的记录列表的末尾
+func disconnectAddrIndex(btx store.Tx, b *block.Block, height uint64) error {
+	if _, ok := addrIndexFrom(btx); !ok {
+		return nil
+	}
+	index := btx.Bucket([]byte(addrIndexBucketName))
+
+	for key := range blockAddrRecords(b, height) {
+		data := index.Get([]byte(key))
+		if data == nil {
+			continue
+		}
+		records, err := decodeAddrRecords(data)
+		if err != nil {
+			return fmt.Errorf("地址索引 %x: %w", key, err)
+		}
+
+		n := len(records)
+		for n > 0 && records[n-1].Height >= height {
+			n--
+		}
+
+		if n == 0 {
+			err = index.Delete([]byte(key))
+		} else {
+			err = index.Put([]byte(key), encodeAddrRecords(records[:n]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//清空地址索引，从高度from开始重新记录
+func resetAddrIndex(btx store.Tx, from uint64) error {
+	if btx.Bucket([]byte(addrIndexBucketName)) != nil {
+		if err := btx.DeleteBucket([]byte(addrIndexBucketName)); err != nil {
+			return err
+		}
+	}
+	if _, err := btx.CreateBucket([]byte(addrIndexBucketName)); err != nil {
+		return err
+	}
+	return btx.Bucket([]byte(chainStateBucketName)).Put([]byte(addrIndexKey), uintToByte(from))
+}
+
+//开启地址索引，或者从头重建，返回索引的区块个数和开始的高度
+//被裁剪的区块没有区块体，索引从第一个没有被裁剪的高度开始
+func (bc *BlockChain) ReindexAddresses() (int, uint64, error) {
+	count := 0
+	var from uint64
+
+	err := bc.db.Update(func(btx store.Tx) error {
+		if err := chainStateBuckets(btx); err != nil {
+			return err
+		}
+
+		tipHeight, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+
+		from = getStateUint(btx.Bucket([]byte(chainStateBucketName)), prunedHeightKey)
+		if err := resetAddrIndex(btx, from); err != nil {
+			return err
+		}
+
+		for height := from; height <= tipHeight; height++ {
+			data, err := blockDataAtHeight(btx, height)
+			if err != nil {
+				return err
+			}
+			b, err := block.Deserialize(data)
+			if err != nil {
+				return fmt.Errorf("高度 %d: %w", height, err)
+			}
+			if err := connectAddrIndex(btx, b, height); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	chainLog.Info("地址索引重建完成", "blocks", count, "from", from)
+	return count, from, nil
+}
+
+//关闭地址索引并删除索引数据
+func (bc *BlockChain) DropAddressIndex() error {
+	return bc.db.Update(func(btx store.Tx) error {
+		if btx.Bucket([]byte(addrIndexBucketName)) != nil {
+			if err := btx.DeleteBucket([]byte(addrIndexBucketName)); err != nil {
+				return err
+			}
+		}
+		if state := btx.Bucket([]byte(chainStateBucketName)); state != nil {
+			return state.Delete([]byte(addrIndexKey))
+		}
+		return nil
+	})
+}
+
+//地址索引是否开启，以及开始的高度
+func (bc *BlockChain) AddressIndex() (uint64, bool) {
+	var from uint64
+	var ok bool
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		from, ok = addrIndexFrom(btx)
+		return nil
+	})
+
+	return from, ok
+}
+
+//地址索引中pubKeyHash的所有记录
+func addressRecords(btx store.Tx, pubKeyHash []byte) ([]addrIndexRecord, error) {
+	data := btx.Bucket([]byte(addrIndexBucketName)).Get(pubKeyHash)
+	if data == nil {
+		return nil, nil
+	}
+	records, err := decodeAddrRecords(data)
+	if err != nil {
+		return nil, fmt.Errorf("地址索引 %x: %w", pubKeyHash, err)
+	}
+	return records, nil
+}
+
+//用地址索引找到pubKeyHash的UTXO，索引不完整(开启时已经有区块被裁剪)时返回false
+func indexedUtxoes(btx store.Tx, pubKeyHash []byte) ([]UTXOInfo, bool, error) {
+	if from, ok := addrIndexFrom(btx); !ok || from > 0 {
+		return nil, false, nil
+	}
+
+	records, err := addressRecords(btx, pubKeyHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	utxos := btx.Bucket([]byte(utxoBucketName))
+	var infoes []UTXOInfo
+
+	for _, r := range records {
+		for _, index := range r.Funded {
+			data := utxos.Get(filter.OutpointBytes(r.TXID, index))
+			if data == nil {
+				continue
+			}
+			entry, err := deserializeUTXOEntry(data)
+			if err != nil {
+				return nil, false, err
+			}
+			if !bytes.Equal(entry.Output.PubKeyHash, pubKeyHash) {
+				chainLog.Warn("地址索引与UTXO集合不一致", logging.Hex("txid", r.TXID), "index", index)
+				continue
+			}
+			infoes = append(infoes, UTXOInfo{r.TXID, index, entry.Output})
+		}
+	}
+
+	return infoes, true, nil
+}
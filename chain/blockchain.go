@@ -0,0 +1,645 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ELOATS/btc/address"
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+var (
+	chainLog = logging.Component(logging.Chain)
+	txLog    = logging.Component(logging.Tx)
+)
+
+type BlockChain struct {
+	db   store.Store
+	tail []byte //最后一个区块的哈希
+
+	deployments *deploymentCache
+}
+
+const genesisInfo = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+
+const BlockChainName = "blockChain.db"
+const levelDBName = "blockChain.leveldb"
+const blockBucketName = "blockBucket"
+const lastHashKey = "lastHashKey"
+const dbOpenTimeout = 3 * time.Second
+
+//区块链使用的存储后端，store.Bolt或store.LevelDB
+var Backend = store.Bolt
+
+//当前存储后端的数据库路径，LevelDB的数据库是一个目录
+func DBPath() string {
+	if Backend == store.LevelDB {
+		return levelDBName
+	}
+	return BlockChainName
+}
+
+func CreateBlockChain(miner string) (*BlockChain, error) {
+	path := DBPath()
+
+	if IsFileExist(path) {
+		return nil, fmt.Errorf("%w: %s", ErrChainExists, path)
+	}
+	if !wallet.IsValidAddress(miner) {
+		return nil, fmt.Errorf("%w: %s", address.ErrInvalidAddress, miner)
+	}
+
+	//1. 获得数据库的句柄，打开数据库，填写数据
+	db, err := store.Open(Backend, path, dbOpenTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := CreateBlockChainInStore(db, miner)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+//在已经打开的数据库中创建区块链，写入创始块，测试时可以传入store.NewMemory()
+func CreateBlockChainInStore(db store.Store, miner string) (*BlockChain, error) {
+	if !wallet.IsValidAddress(miner) {
+		return nil, fmt.Errorf("%w: %s", address.ErrInvalidAddress, miner)
+	}
+
+	//创始块中只有一个挖矿交易，只有Coinbase
+	coinbase := tx.NewCoinbaseTx(miner, genesisInfo)
+	genesisBlock := NewBlock([]*tx.Transaction{coinbase}, []byte{})
+
+	return createGenesis(db, genesisBlock)
+}
+
+//在空的数据库中写入创始块
+func createGenesis(db store.Store, genesisBlock *block.Block) (*BlockChain, error) {
+	//判断是否有bucket,如果没有，创建bucket
+	err := db.Update(func(btx store.Tx) error {
+
+		_, err := btx.CreateBucket([]byte(blockBucketName))
+		if errors.Is(err, store.ErrBucketExists) {
+			return ErrChainExists
+		}
+		if err != nil {
+			return err
+		}
+
+		//写入区块和lastHashKey这条数据
+		return connectBlock(btx, genesisBlock)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chainLog.Info("区块链创建成功", logging.Hex("genesis", genesisBlock.Hash))
+
+	//返回bc实例
+	return &BlockChain{db, genesisBlock.Hash, newDeploymentCache()}, nil
+}
+
+//返回区块链实例
+func NewBlockChain() (*BlockChain, error) {
+	path := DBPath()
+
+	if !IsFileExist(path) {
+		return nil, fmt.Errorf("%w: %s", ErrChainNotFound, path)
+	}
+
+	//1. 获得数据库的句柄，打开数据库，填写数据
+	//节点运行时一直持有数据库的文件锁，等待一段时间后放弃
+	db, err := store.Open(Backend, path, dbOpenTimeout)
+	if errors.Is(err, store.ErrLocked) {
+		return nil, fmt.Errorf("%w: %s", ErrChainLocked, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := NewBlockChainFromStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+//从已经打开的数据库中读取区块链，必要时重建链状态
+func NewBlockChainFromStore(db store.Store) (*BlockChain, error) {
+	var tail []byte
+
+	err := db.View(func(tx store.Tx) error {
+		var err error
+		tail, err = getTip(tx)
+		return err
+	})
+
+	if err == nil {
+		err = ensureChainState(db, tail)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//返回bc实例
+	return &BlockChain{db, tail, newDeploymentCache()}, nil
+}
+
+//关闭数据库，释放文件锁
+func (bc *BlockChain) Close() error {
+	return bc.db.Close()
+}
+
+//最后一个区块的哈希
+func (bc *BlockChain) Tip() []byte {
+	return bc.tail
+}
+
+func NewBlock(txs []*tx.Transaction, prevBlockHash []byte) *block.Block {
+	return newBlockWithTime(txs, prevBlockHash, BlockVersion, uint64(time.Now().Unix()))
+}
+
+//使用指定的版本和时间戳创建区块并挖矿，时间戳必须大于链尾的MTP
+func newBlockWithTime(txs []*tx.Transaction, prevBlockHash []byte, version uint64, timeStamp uint64) *block.Block {
+	b := block.New(txs, prevBlockHash, version, timeStamp, pow.Bits)
+
+	hash, nonce := pow.NewProofOfWork(b).Run()
+	b.Hash = hash
+	b.Nonce = nonce
+
+	return b
+}
+
+//把交易打包进新的区块，返回挖出的区块
+//无效的交易和没有到锁定时间的交易会被过滤掉，不会导致失败
+func (bc *BlockChain) AddBlock(txs []*tx.Transaction) (*block.Block, error) {
+	//矿工得到交易时，第一时间对交易进行验证
+	//矿工如果不验证，即使挖矿成功，广播区块后，其他的验证矿工，仍然会检验每一笔交易
+
+	validTXs := []*tx.Transaction{}
+
+	//没有到锁定时间的交易不能打包
+	height, mtp := bc.Height()+1, bc.MedianTimePast()
+
+	for _,tx := range txs {
+		if !tx.IsFinal(height, mtp) {
+			txLog.Info("交易还没有到锁定时间，不打包", logging.Hex("txid", tx.TXid), "lockTime", tx.LockTime)
+			continue
+		}
+		if err := bc.VerifyTransaction(tx); err != nil {
+			txLog.Warn("发现无效的交易，不打包", logging.Hex("txid", tx.TXid), "err", err)
+			continue
+		}
+		txLog.Debug("交易有效", logging.Hex("txid", tx.TXid))
+		validTXs = append(validTXs,tx)
+	}
+
+	block := newBlockWithTime(validTXs, bc.tail, bc.nextBlockVersion(), bc.nextBlockTime())
+
+	//单个交易有效不代表整个区块有效(交易之间的双花、挖矿交易的金额等)，和提交的区块一样完整校验
+	if err := bc.SubmitBlock(block); err != nil {
+		return nil, fmt.Errorf("区块 %x 写入失败: %w", block.Hash, err)
+	}
+
+	chainLog.Info("区块已写入", logging.Hex("hash", block.Hash), "txs", len(block.Transactions))
+
+	return block, nil
+}
+
+//把区块写入数据库并作为新的链尾，区块必须已经校验过
+//1. 写入区块和lastHashKey
+//2. 打包进区块的交易，以及和它们冲突的交易，从交易池中删除
+//3. 计算区块过滤器
+//4. 更新区块索引和UTXO集合，开启了地址索引时更新地址索引
+//5. 开启了裁剪模式时删除较早的区块体
+func connectBlock(btx store.Tx, block *block.Block) error {
+	if err := putBlock(btx, block); err != nil {
+		return err
+	}
+	if err := putTip(btx, block.Hash); err != nil {
+		return err
+	}
+
+	if err := removeBlockFromPool(btx, block); err != nil {
+		return err
+	}
+
+	if err := connectBlockFilter(btx, block); err != nil {
+		return err
+	}
+
+	height, err := connectChainState(btx, block)
+	if err != nil {
+		return err
+	}
+
+	if err := connectAddrIndex(btx, block, height); err != nil {
+		return err
+	}
+
+	_, err = pruneBlocks(btx, height)
+	return err
+}
+
+//把旧的gob格式区块迁移为规范编码，返回迁移的区块数
+//旧交易的id保持原来的gob哈希不变，否则后面引用它的input就找不到了，这些交易的Version为0
+func (bc *BlockChain) MigrateFromGob() (int, error) {
+	count := 0
+
+	err := bc.db.Update(func(tx store.Tx) error {
+		b := tx.Bucket([]byte(blockBucketName))
+		if b == nil {
+			return fmt.Errorf("bucket %s 不存在", blockBucketName)
+		}
+
+		//ForEach的过程中不能修改bucket，先把需要迁移的记录找出来
+		legacy := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			if string(k) == lastHashKey || block.IsCanonical(v) {
+				return nil
+			}
+			legacy[string(k)] = v
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, data := range legacy {
+			blk, err := block.DeserializeGob(data)
+			if err != nil {
+				return fmt.Errorf("%w: 区块 %x: %v", block.ErrCorruptBlock, key, err)
+			}
+
+			err = b.Put([]byte(key), blk.Serialize())
+			if err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// 定义一个区块链年的迭代器，包括db,current
+type BlockChainIterator struct {
+	db      store.Store
+	current []byte //当前所指向区块的哈希值
+}
+
+// 当前所指向区块的哈希值
+func (bc *BlockChain) NewIterator() *BlockChainIterator {
+	return &BlockChainIterator{bc.db, bc.tail}
+}
+
+//返回当前区块并前移到前一个区块，调用者在创世块(PrevBlockHash为空)之后停止
+func (it *BlockChainIterator) Next() (*block.Block, error) {
+	var b *block.Block
+
+	err := it.db.View(func(tx store.Tx) error {
+		var err error
+		b, err = getBlock(tx, it.current)
+		if errors.Is(err, ErrPrunedBlock) {
+			//区块体已经被裁剪，从区块索引中读取区块头
+			_, b, err = blockIndex(tx, it.current)
+			if err != nil {
+				return err
+			}
+			b.Pruned = true
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	it.current = b.PrevBlockHash
+	return b, nil
+}
+
+
+//我们想把FindMyUtxoes和FindNeedUTXO进行整合
+//1. FindMyUtxoes: 找到所有utxo（只要output就可以了）
+//2. FindNeedUTXO: 找到需要的utxo（要output的定位）
+
+//我们可以定义一个结构，同时包含output已经定位信息
+type UTXOInfo struct {
+	TXID   []byte   //交易id
+	Index  int64    //output的索引值
+	Output tx.TXOutput //output本身
+}
+
+//实现思路：遍历UTXO集合，找到属于pubKeyHash的所有output
+//UTXO集合在区块连接时更新，不需要再遍历账本统计被消耗过的output
+func (bc *BlockChain) FindMyUtxoes(pubKeyHash []byte) ([]UTXOInfo, error) {
+	var UTXOInfoes []UTXOInfo
+
+	err := bc.db.View(func(tx store.Tx) error {
+		//开启了地址索引时只需要查找索引中记录的output
+		infoes, ok, err := indexedUtxoes(tx, pubKeyHash)
+		if ok || err != nil {
+			UTXOInfoes = infoes
+			return err
+		}
+
+		b := tx.Bucket([]byte(utxoBucketName))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			entry, err := deserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+
+			if bytes.Equal(pubKeyHash, entry.Output.PubKeyHash) {
+				txid, index := splitOutpoint(k)
+				UTXOInfoes = append(UTXOInfoes, UTXOInfo{txid, index, entry.Output})
+			}
+			return nil
+		})
+	})
+
+	return UTXOInfoes, err
+}
+
+//地址在链上的余额
+func (bc *BlockChain) GetBalance(addr string) (float64, error) {
+	// 这个过程，不要打开钱包，因为有可能查看余额的人不是地址本人
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	utxoinfoes, err := bc.FindMyUtxoes(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
+
+	var total = 0.0
+	//所有的output都在utxoinfoes内部
+	//获取余额时，遍历utxoinfoes获取output即可
+	for _, utxoinfo := range utxoinfoes {
+		total += utxoinfo.Output.Value
+	}
+
+	return total, nil
+}
+
+
+//找到可以用来创建新交易的utxo
+//链上的utxo中，去掉已经被交易池中的交易花费的
+//unconfirmed为true时，再加上交易池中属于我的、还没有被花费的output
+func (bc *BlockChain) FindSpendableUtxoes(pubKeyHash []byte, unconfirmed bool) ([]UTXOInfo, error) {
+	pool := bc.PoolEntries()
+	spends := poolSpends(pool)
+
+	mine, err := bc.FindMyUtxoes(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var utxoinfoes []UTXOInfo
+
+	for _, utxoinfo := range mine {
+		if _, spent := spends[outpointKey(utxoinfo.TXID, utxoinfo.Index)]; !spent {
+			utxoinfoes = append(utxoinfoes, utxoinfo)
+		}
+	}
+
+	if !unconfirmed {
+		return utxoinfoes, nil
+	}
+
+	for _, entry := range pool {
+		for i, output := range entry.Tx.TXOutputs {
+			if output.IsDataOutput() || !bytes.Equal(pubKeyHash, output.PubKeyHash) {
+				continue
+			}
+			if _, spent := spends[outpointKey(entry.Tx.TXid, int64(i))]; spent {
+				continue
+			}
+			utxoinfoes = append(utxoinfoes, UTXOInfo{entry.Tx.TXid, int64(i), output})
+		}
+	}
+
+	return utxoinfoes, nil
+}
+
+func (bc *BlockChain) FindNeedUtxoes(pubKeyHash []byte, amount float64, unconfirmed bool) (map[string][]int64, float64, error) {
+
+	needUtxoes := make(map[string][]int64) //标示能用的utxo
+	var resValue float64                   //返回的金额
+
+	//复用FindMyUtxo方法，这个方法已经包含了所有信息，同时避开交易池中已经花费的utxo
+	utxoinfoes, err := bc.FindSpendableUtxoes(pubKeyHash, unconfirmed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, utxoinfo := range utxoinfoes {
+		key := string(utxoinfo.TXID)
+
+		needUtxoes[key] = append(needUtxoes[key], int64(utxoinfo.Index))
+		resValue += utxoinfo.Output.Value
+
+		if resValue >= amount {
+			break
+		}
+	}
+
+	return needUtxoes, resValue, nil
+}
+
+func (bc *BlockChain) SignTransaction(t *tx.Transaction,signer wallet.Signer) error {
+	return bc.SignTransactionWithHashType(t, signer, tx.SigHashAll)
+}
+
+//使用指定的签名类型签名，众筹时各参与方使用SIGHASH_ALL|SIGHASH_ANYONECANPAY签名自己的input
+//所有人签名完成后需要重新调用SetTXID
+func (bc *BlockChain) SignTransactionWithHashType(tx *tx.Transaction,signer wallet.Signer,hashType tx.SigHashType) error {
+	//input引用的output从UTXO集合和交易池中查找
+	prevTXs := bc.findPrevTXs(tx)
+
+	return tx.SignWithHashType(signer,prevTXs,hashType)
+}
+
+//矿工校验流程：
+//1. 找到交易input所引用的output(UTXO集合或交易池)
+//2. 对交易进行校验
+
+//校验失败时返回的错误包装了ErrInvalidTransaction
+func (bc *BlockChain) VerifyTransaction(t *tx.Transaction) error {
+
+	//校验的时候，如果是挖矿交易，只检查数据output
+	if t.IsCoinbase() {
+		if err := t.CheckDataOutputs(); err != nil {
+			return fmt.Errorf("%w: 挖矿交易 %x: %v", tx.ErrInvalidTransaction, t.TXid, err)
+		}
+		return nil
+	}
+
+	//已经被花费的output不在UTXO集合中，校验会失败
+	prevTXs := bc.findPrevTXs(t)
+
+	return t.Verify(prevTXs)
+}
+
+//先在区块链中查找交易，找不到时再到交易池中查找(比如CPFP时子交易引用的父交易还没有打包)
+func (bc *BlockChain) FindTransaction(txid []byte) (*tx.Transaction, error) {
+	tx, err := bc.FindTransactionInChain(txid)
+	if !errors.Is(err, ErrTxNotFound) {
+		return tx, err
+	}
+
+	if entry, ok := bc.PoolEntries()[string(txid)]; ok {
+		return entry.Tx, nil
+	}
+
+	return nil, err
+}
+
+//找不到时返回ErrTxNotFound，被裁剪的区块不会被搜索
+func (bc *BlockChain) FindTransactionInChain(txid []byte) (*tx.Transaction, error) {
+	//遍历区块链的交易
+	//通过对比id来识别
+
+	it := bc.NewIterator()
+
+	for {
+		block, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		//更早的区块都已经被裁剪了
+		if block.Pruned {
+			break
+		}
+
+		for _,tx := range block.Transactions {
+			//如果找到相同id交易，直接返回交易即可
+			if bytes.Equal(tx.TXid,txid) {
+				return tx, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %x", ErrTxNotFound, txid)
+}
+
+
+//数据output的查找结果
+type DataInfo struct {
+	BlockHash []byte //所在区块的哈希
+	TXID      []byte //所在交易的id
+	Index     int64  //output的索引
+	Data      []byte
+}
+
+//遍历账本，找到所有以prefix开头的数据output，被裁剪的区块不会被搜索
+func (bc *BlockChain) FindData(prefix []byte) ([]DataInfo, error) {
+	var infoes []DataInfo
+
+	it := bc.NewIterator()
+
+	for {
+		block, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block.Pruned {
+			break
+		}
+
+		for _, tx := range block.Transactions {
+			for i, output := range tx.TXOutputs {
+				if output.IsDataOutput() && bytes.HasPrefix(output.Data, prefix) {
+					infoes = append(infoes, DataInfo{block.Hash, tx.TXid, int64(i), output.Data})
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return infoes, nil
+}
+
+//区块定位器中第一个在主链上的区块，定位器是对方主链上的区块哈希，从新到旧
+//都不在主链上时返回nil，也就是从创世块开始
+func (bc *BlockChain) FindFork(locator [][]byte) []byte {
+	var fork []byte
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		heights := btx.Bucket([]byte(heightBucketName))
+		for _, hash := range locator {
+			height, _, err := blockIndex(btx, hash)
+			if err == nil && bytes.Equal(heights.Get(uintToByte(height)), hash) {
+				fork = append([]byte{}, hash...)
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return fork
+}
+
+//从链尾向前遍历，返回after之后的区块(按高度从低到高)，最多max个
+//after为空时从创世块开始
+func (bc *BlockChain) BlocksAfter(after []byte, max int) ([]*block.Block, error) {
+	var blocks []*block.Block
+
+	it := bc.NewIterator()
+	for {
+		block, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(after) > 0 && bytes.Equal(block.Hash, after) {
+			break
+		}
+
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			if len(after) > 0 {
+				return nil, fmt.Errorf("%w: %x", ErrUnknownBlock, after)
+			}
+			break
+		}
+	}
+
+	//倒序，只保留最早的max个
+	result := make([]*block.Block, 0, min(len(blocks), max))
+	for i := len(blocks) - 1; i >= 0 && len(result) < max; i-- {
+		result = append(result, blocks[i])
+	}
+
+	return result, nil
+}
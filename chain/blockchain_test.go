@@ -0,0 +1,166 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//内存数据库中的区块链，挖矿奖励都付给key
+type testChain struct {
+	*BlockChain
+	key  *wallet.WalletKeyPair
+	addr string
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+
+	key, err := wallet.NewWalletKeyPair(wallet.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := CreateBlockChainInStore(store.OpenMemory(), key.GetAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.Close() })
+
+	return &testChain{bc, key, key.GetAddress()}
+}
+
+//挖好但还没有提交的区块，txs中第一个交易必须是挖矿交易
+func (c *testChain) newBlock(txs ...*tx.Transaction) *block.Block {
+	return newBlockWithTime(txs, c.tail, c.nextBlockVersion(), c.nextBlockTime())
+}
+
+//挖一个区块并写入区块链，挖矿奖励付给key
+func (c *testChain) mine(t *testing.T, txs ...*tx.Transaction) *block.Block {
+	t.Helper()
+
+	var fees float64
+	for _, t := range txs {
+		if entry, ok := c.PoolEntries()[string(t.TXid)]; ok {
+			fees += entry.Fee
+		}
+	}
+
+	coinbase := tx.NewCoinbaseTxWithFees(c.addr, "", fees)
+	b := c.newBlock(append([]*tx.Transaction{coinbase}, txs...)...)
+	if err := c.SubmitBlock(b); err != nil {
+		t.Fatalf("SubmitBlock: %v", err)
+	}
+	return b
+}
+
+//花费key拥有的output，outputs的金额由调用者决定，差额就是手续费
+func (c *testChain) spend(t *testing.T, sequence uint32, inputs []tx.TXInput, outputs ...tx.TXOutput) *tx.Transaction {
+	t.Helper()
+
+	newTx := &tx.Transaction{Version: tx.TxVersion, TXOutputs: outputs}
+	for _, input := range inputs {
+		input.PubKey = c.key.PubKey()
+		input.Sequence = sequence
+		newTx.TXInputs = append(newTx.TXInputs, input)
+	}
+	if err := c.SignTransaction(newTx, c.key); err != nil {
+		t.Fatalf("SignTransaction: %v", err)
+	}
+	newTx.SetTXID()
+	return newTx
+}
+
+func outpoint(t *tx.Transaction, index int64) tx.TXInput {
+	return tx.TXInput{TXID: t.TXid, Index: index}
+}
+
+func (c *testChain) pay(value float64) tx.TXOutput {
+	return tx.NewTXOutput(value, c.addr)
+}
+
+//修改区块头之后重新挖矿
+func remine(b *block.Block) *block.Block {
+	b.Hash, b.Nonce = pow.NewProofOfWork(b).Run()
+	return b
+}
+
+func coinbaseWithValue(addr string, value float64) *tx.Transaction {
+	coinbase := tx.NewCoinbaseTx(addr, "")
+	coinbase.TXOutputs[0].Value = value
+	coinbase.SetTXID()
+	return coinbase
+}
+
+func TestConnectBlocks(t *testing.T) {
+	c := newTestChain(t)
+
+	var mined []*block.Block
+	for i := 0; i < 3; i++ {
+		mined = append(mined, c.mine(t))
+	}
+	if c.Height() != 3 {
+		t.Fatalf("Height = %d, want 3", c.Height())
+	}
+
+	//链尾和区块都可以通过类型化的接口读回来
+	err := c.db.View(func(btx store.Tx) error {
+		tip, err := getTip(btx)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(tip, mined[2].Hash) {
+			t.Fatalf("tip = %x, want %x", tip, mined[2].Hash)
+		}
+		b, err := getBlock(btx, mined[1].Hash)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b.Serialize(), mined[1].Serialize()) {
+			t.Fatal("stored block differs")
+		}
+		if _, err := getBlock(btx, []byte("missing")); !errors.Is(err, ErrUnknownBlock) {
+			t.Fatalf("getBlock missing = %v, want ErrUnknownBlock", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//迭代器从链尾走到创世块
+	it := c.NewIterator()
+	for i := 2; ; i-- {
+		b, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b.PrevBlockHash) == 0 {
+			if i != -1 {
+				t.Fatalf("reached genesis after %d blocks", 2-i)
+			}
+			break
+		}
+		if !bytes.Equal(b.Hash, mined[i].Hash) {
+			t.Fatalf("block %d = %x, want %x", i, b.Hash, mined[i].Hash)
+		}
+	}
+
+	//重新打开数据库得到相同的链尾
+	reopened, err := NewBlockChainFromStore(c.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reopened.Tip(), c.Tip()) {
+		t.Fatalf("reopened tip = %x, want %x", reopened.Tip(), c.Tip())
+	}
+
+	if report := c.VerifyChain(4); report.Failure != nil {
+		t.Fatalf("VerifyChain: %+v", report.Failure)
+	}
+}
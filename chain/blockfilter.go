@@ -0,0 +1,117 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/store"
+)
+
+//区块过滤器：区块连接到链上时，对区块中所有output的公钥哈希和被花费的output构造GCS过滤器
+//轻钱包下载过滤器在本地匹配自己的地址，全节点不知道钱包关心哪些地址
+//过滤器头组成一条链：header = sha256(sha256(filter) || 前一个区块的过滤器头)，轻钱包可以从多个节点比较过滤器头
+
+const (
+	blockFilterBucketName  = "blockFilterBucket"  //区块哈希 -> 过滤器
+	filterHeaderBucketName = "filterHeaderBucket" //区块哈希 -> 过滤器头
+)
+
+//区块连接到链上时计算过滤器和过滤器头
+//前一个区块还没有过滤器时(旧的数据库)，先补齐之前的区块
+func connectBlockFilter(btx store.Tx, block *block.Block) error {
+	filters, headers, err := filterBuckets(btx)
+	if err != nil {
+		return err
+	}
+
+	//创世块的前一个过滤器头是全0
+	prevHeader := make([]byte, sha256.Size)
+	if len(block.PrevBlockHash) > 0 {
+		if err := indexMissingFilters(btx, block.PrevBlockHash); err != nil {
+			return err
+		}
+		prevHeader = headers.Get(block.PrevBlockHash)
+	}
+
+	filterData := filter.NewBlockFilter(block).Bytes()
+	if err := filters.Put(block.Hash, filterData); err != nil {
+		return err
+	}
+	return headers.Put(block.Hash, filter.NextHeader(filterData, prevHeader))
+}
+
+//从hash向前找到最后一个有过滤器的区块，再按顺序补齐后面的过滤器
+func indexMissingFilters(btx store.Tx, hash []byte) error {
+	blocks := btx.Bucket([]byte(blockBucketName))
+	headers := btx.Bucket([]byte(filterHeaderBucketName))
+
+	var missing []*block.Block
+	for len(hash) > 0 && headers.Get(hash) == nil {
+		data := blocks.Get(hash)
+		if data == nil {
+			if _, _, err := blockIndex(btx, hash); err == nil {
+				return fmt.Errorf("%w，无法计算过滤器: %x", ErrPrunedBlock, hash)
+			}
+			return fmt.Errorf("%w: %x", ErrUnknownBlock, hash)
+		}
+
+		blk, err := block.Deserialize(data)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, blk)
+
+		hash = blk.PrevBlockHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := connectBlockFilter(btx, missing[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//读取区块的过滤器和过滤器头，旧的数据库中缺少的过滤器会先补齐
+func (bc *BlockChain) GetBlockFilter(blockHash []byte) (filter []byte, header []byte, prevHeader []byte, err error) {
+	err = bc.db.Update(func(btx store.Tx) error {
+		//区块体可能已经被裁剪，从区块索引中读取区块头
+		_, blockHeader, err := blockIndex(btx, blockHash)
+		if err != nil {
+			return err
+		}
+
+		if _, _, err := filterBuckets(btx); err != nil {
+			return err
+		}
+		if err := indexMissingFilters(btx, blockHash); err != nil {
+			return err
+		}
+
+		filter = append([]byte{}, btx.Bucket([]byte(blockFilterBucketName)).Get(blockHash)...)
+		header = append([]byte{}, btx.Bucket([]byte(filterHeaderBucketName)).Get(blockHash)...)
+
+		prevHeader = make([]byte, sha256.Size)
+		if prev := blockHeader.PrevBlockHash; len(prev) > 0 {
+			prevHeader = append([]byte{}, btx.Bucket([]byte(filterHeaderBucketName)).Get(prev)...)
+		}
+		return nil
+	})
+
+	return filter, header, prevHeader, err
+}
+
+func filterBuckets(btx store.Tx) (store.Bucket, store.Bucket, error) {
+	filters, err := btx.CreateBucketIfNotExists([]byte(blockFilterBucketName))
+	if err != nil {
+		return nil, nil, err
+	}
+	headers, err := btx.CreateBucketIfNotExists([]byte(filterHeaderBucketName))
+	if err != nil {
+		return nil, nil, err
+	}
+	return filters, headers, nil
+}
@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+)
+
+//区块和链尾的类型化读写，链的代码通过这些函数访问blockBucket，不直接处理key和编码
+//区块索引和高度索引见chainstate.go的blockIndex和connectChainState
+//多个写入放在同一个store.Update中，一起提交或者一起丢弃
+
+func blockBucket(btx store.Tx) (store.Bucket, error) {
+	b := btx.Bucket([]byte(blockBucketName))
+	if b == nil {
+		return nil, fmt.Errorf("%w: 没有 %s", ErrChainNotFound, blockBucketName)
+	}
+	return b, nil
+}
+
+//读取区块，区块体已经被裁剪时返回ErrPrunedBlock，不在索引中时返回ErrUnknownBlock
+func getBlock(btx store.Tx, hash []byte) (*block.Block, error) {
+	b, err := blockBucket(btx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := b.Get(hash)
+	if data == nil {
+		if _, _, err := blockIndex(btx, hash); err == nil {
+			return nil, fmt.Errorf("%w: %x", ErrPrunedBlock, hash)
+		}
+		return nil, fmt.Errorf("%w: %x", ErrUnknownBlock, hash)
+	}
+
+	blk, err := block.Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("区块 %x: %w", hash, err)
+	}
+	return blk, nil
+}
+
+//按规范编码写入区块，不改变链尾
+func putBlock(btx store.Tx, blk *block.Block) error {
+	b, err := blockBucket(btx)
+	if err != nil {
+		return err
+	}
+	return b.Put(blk.Hash, blk.Serialize())
+}
+
+//链尾区块的哈希
+func getTip(btx store.Tx) ([]byte, error) {
+	b, err := blockBucket(btx)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b.Get([]byte(lastHashKey))...), nil
+}
+
+func putTip(btx store.Tx, hash []byte) error {
+	b, err := blockBucket(btx)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(lastHashKey), hash)
+}
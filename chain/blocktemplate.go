@@ -0,0 +1,276 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//区块大小的上限(字节)，按照区块的规范编码计算
+const MaxBlockSize = 1000000
+
+//区块模板：已经选好了交易、填好了区块头，只差Nonce
+//矿工对Block运行ProofOfWork，找到Nonce后把区块交给SubmitBlock
+type BlockTemplate struct {
+	Block   *block.Block
+	Entries []*TxPoolEntry //打包的交易池条目，按照在区块中的顺序，不包括挖矿交易
+	Fees    float64        //所有交易的手续费之和，已经计入挖矿交易
+	Size    int            //挖矿完成后区块编码的大小(估算值，不会小于实际大小)
+}
+
+//根据交易池创建区块模板
+//1. 按照祖先包的费率选择交易，保证父交易排在子交易前面，总大小不超过maxSize
+//2. 挖矿交易的金额是挖矿奖励加上所有交易的手续费
+//3. 填写区块头：前区块哈希、梅克尔根、时间戳和难度值，时间戳必须大于链尾的MTP
+func (bc *BlockChain) NewBlockTemplate(miner string, maxSize int) (*BlockTemplate, error) {
+	if !wallet.IsValidAddress(miner) {
+		return nil, fmt.Errorf("miner : %s 是无效地址", miner)
+	}
+
+	//先用一个不含手续费的挖矿交易估算区块头和挖矿交易的大小
+	//金额是定长编码，加上手续费之后大小不变
+	b := &block.Block{
+		Version:       bc.nextBlockVersion(),
+		PrevBlockHash: bc.tail,
+		TimeStamp:     bc.nextBlockTime(),
+		Difficulity:   pow.Bits,
+		Transactions:  []*tx.Transaction{tx.NewCoinbaseTx(miner, "")},
+	}
+	b.HashTransactions()
+
+	//区块哈希还没有填写，交易个数的编码最多会增长到MaxVarintLen64
+	baseSize := len(b.Serialize()) + sha256.Size + binary.MaxVarintLen64
+	if baseSize > maxSize {
+		return nil, fmt.Errorf("区块大小上限 %d 太小，至少需要 %d", maxSize, baseSize)
+	}
+
+	entries := SelectPoolTransactions(bc.finalPoolEntries(), maxSize-baseSize)
+
+	var fees float64
+	txs := make([]*tx.Transaction, 0, len(entries)+1)
+	for _, entry := range entries {
+		fees += entry.Fee
+		txs = append(txs, entry.Tx)
+	}
+
+	coinbase := tx.NewCoinbaseTxWithFees(miner, "", fees)
+	b.Transactions = append([]*tx.Transaction{coinbase}, txs...)
+	b.HashTransactions()
+
+	size := baseSize
+	for _, entry := range entries {
+		size += entry.blockSize()
+	}
+
+	return &BlockTemplate{Block: b, Entries: entries, Fees: fees, Size: size}, nil
+}
+
+//交易池中可以打包进下一个区块的交易
+//区块被断开之后，交易池中的交易可能又回到锁定时间之前，这些交易和它们的后代都不能打包
+func (bc *BlockChain) finalPoolEntries() map[string]*TxPoolEntry {
+	pool := bc.PoolEntries()
+	height, mtp := bc.Height()+1, bc.MedianTimePast()
+
+	var nonFinal []string
+	for txid, entry := range pool {
+		if !entry.Tx.IsFinal(height, mtp) {
+			nonFinal = append(nonFinal, txid)
+		}
+	}
+
+	for _, txid := range nonFinal {
+		for descendant := range poolDescendants(pool, txid) {
+			delete(pool, descendant)
+		}
+		delete(pool, txid)
+	}
+
+	return pool
+}
+
+var errStaleBlock = errors.New("区块不是在当前链尾之后创建的")
+
+//接收一个挖好的区块，校验通过后写入区块链
+func (bc *BlockChain) SubmitBlock(b *block.Block) error {
+	start := time.Now()
+	err := bc.checkBlock(b)
+	blockValidationSeconds.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	err = bc.db.Update(func(btx store.Tx) error {
+		return connectBlock(btx, b)
+	})
+	if err != nil {
+		return err
+	}
+	blockConnectSeconds.Observe(time.Since(start).Seconds())
+
+	bc.tail = b.Hash
+	return nil
+}
+
+//校验提交的区块
+//1. 区块必须连接在当前链尾之后
+//2. 第一个交易是挖矿交易，其他交易都是普通交易
+//3. 梅克尔根、区块大小和工作量证明正确
+//4. 所有交易校验通过并且没有双花，挖矿交易的金额不超过挖矿奖励加上手续费
+func (bc *BlockChain) checkBlock(b *block.Block) error {
+	if !bytes.Equal(b.PrevBlockHash, bc.tail) {
+		return fmt.Errorf("%w: 前区块哈希 %x，链尾 %x", errStaleBlock, b.PrevBlockHash, bc.tail)
+	}
+
+	if bc.IsInvalidBlock(b.Hash) {
+		return fmt.Errorf("%w: %x", ErrInvalidBlock, b.Hash)
+	}
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return errors.New("区块的第一个交易必须是挖矿交易")
+	}
+	//梅克尔树复制奇数层的最后一个节点，重复的交易可以构造出相同的梅克尔根，必须拒绝
+	seen := make(map[string]bool)
+	for i, tx := range b.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return errors.New("区块中只能有一个挖矿交易")
+		}
+		if err := tx.CheckTXID(); err != nil {
+			return err
+		}
+		if seen[string(tx.TXid)] {
+			return fmt.Errorf("区块中有重复的交易: %x", tx.TXid)
+		}
+		seen[string(tx.TXid)] = true
+	}
+
+	if !isVersionBitsBlock(b.Version) {
+		return fmt.Errorf("不支持的区块版本: %d", b.Version)
+	}
+
+	err := bc.db.View(func(btx store.Tx) error {
+		if err := checkBlockTime(btx, b); err != nil {
+			return err
+		}
+		height, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+		return checkBlockFinality(btx, b, height+1)
+	})
+	if err != nil {
+		return err
+	}
+
+	expected := block.Block{Version: b.Version, Transactions: b.Transactions}
+	expected.HashTransactions()
+	if !bytes.Equal(b.MerkleRoot, expected.MerkleRoot) {
+		return fmt.Errorf("梅克尔根不正确: %x，应该是 %x", b.MerkleRoot, expected.MerkleRoot)
+	}
+
+	if err := pow.Check(b); err != nil {
+		return err
+	}
+
+	if size := len(b.Serialize()); size > MaxBlockSize {
+		return fmt.Errorf("区块大小 %d 超过上限 %d", size, MaxBlockSize)
+	}
+
+	fees, err := bc.blockFees(b)
+	if err != nil {
+		return err
+	}
+
+	coinbase := b.Transactions[0]
+	if err := bc.VerifyTransaction(coinbase); err != nil {
+		return err
+	}
+	if err := coinbase.CheckOutputValues(); err != nil {
+		return err
+	}
+
+	var coinbaseValue float64
+	for _, output := range coinbase.TXOutputs {
+		coinbaseValue += output.Value
+	}
+	//手续费是浮点数之和，允许一点误差
+	if coinbaseValue > tx.Reward+fees+1e-9 {
+		return fmt.Errorf("挖矿交易的金额 %f 超过了奖励加手续费 %f", coinbaseValue, tx.Reward+fees)
+	}
+
+	//父交易可能在同一个区块中，它不一定在本节点的交易池里
+	inBlock := make(map[string]*tx.Transaction)
+	for _, t := range b.Transactions[1:] {
+		prevTXs := bc.findPrevTXs(t)
+		for _, input := range t.TXInputs {
+			if parent, ok := inBlock[string(input.TXID)]; ok {
+				prevTXs[string(input.TXID)] = *parent
+			}
+		}
+		if err := t.Verify(prevTXs); err != nil {
+			return err
+		}
+		inBlock[string(t.TXid)] = t
+	}
+
+	return nil
+}
+
+//区块中普通交易的手续费之和，同时检查双花和output的金额
+//input引用的output必须在UTXO集合中，或者是同一个区块中排在前面的交易的output
+func (bc *BlockChain) blockFees(block *block.Block) (float64, error) {
+	inBlock := make(map[string]*tx.Transaction)
+	spent := make(map[string]bool)
+	var fees float64
+
+	for _, tx := range block.Transactions[1:] {
+		var inValue, outValue float64
+
+		for _, input := range tx.TXInputs {
+			key := outpointKey(input.TXID, input.Index)
+			if spent[key] {
+				return 0, fmt.Errorf("交易 %x: output %x:%d 已经被花费", tx.TXid, input.TXID, input.Index)
+			}
+			spent[key] = true
+
+			if prevTX, ok := inBlock[string(input.TXID)]; ok {
+				if input.Index < 0 || input.Index >= int64(len(prevTX.TXOutputs)) || prevTX.TXOutputs[input.Index].IsDataOutput() {
+					return 0, fmt.Errorf("交易 %x: %w: %x:%d", tx.TXid, errMissingInputs, input.TXID, input.Index)
+				}
+				inValue += prevTX.TXOutputs[input.Index].Value
+				continue
+			}
+
+			entry, err := bc.GetUTXO(input.TXID, input.Index)
+			if err != nil {
+				return 0, fmt.Errorf("交易 %x: %w: %x:%d", tx.TXid, errMissingInputs, input.TXID, input.Index)
+			}
+			inValue += entry.Output.Value
+		}
+
+		if err := tx.CheckOutputValues(); err != nil {
+			return 0, err
+		}
+		for _, output := range tx.TXOutputs {
+			outValue += output.Value
+		}
+
+		if inValue < outValue {
+			return 0, fmt.Errorf("交易 %x 的output金额 %f 超过了input金额 %f", tx.TXid, outValue, inValue)
+		}
+		fees += inValue - outValue
+
+		inBlock[string(tx.TXid)] = tx
+	}
+
+	return fees, nil
+}
@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/tx"
+)
+
+func TestCheckBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		build  func(c *testChain, funds *tx.Transaction) *block.Block
+		ok     bool
+		reason string //拒绝的原因中包含的文字
+	}{
+		{"valid spend", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			return c.newBlock(coinbaseWithValue(c.addr, tx.Reward+2.5), spend)
+		}, true, ""},
+		{"coinbase NaN", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return c.newBlock(coinbaseWithValue(c.addr, math.NaN()))
+		}, false, "金额 NaN 无效"},
+		{"coinbase negative", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return c.newBlock(coinbaseWithValue(c.addr, -1))
+		}, false, "金额 -1.000000 无效"},
+		{"coinbase infinity", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return c.newBlock(coinbaseWithValue(c.addr, math.Inf(1)))
+		}, false, "金额 +Inf 无效"},
+		{"coinbase inflated", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return c.newBlock(coinbaseWithValue(c.addr, tx.Reward+1))
+		}, false, "超过了奖励加手续费"},
+		{"coinbase takes more than fees", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			return c.newBlock(coinbaseWithValue(c.addr, tx.Reward+3), spend)
+		}, false, "超过了奖励加手续费"},
+		{"output NaN", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(math.NaN()))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "金额 NaN 无效"},
+		//负数抵消另一个output，总额等于input
+		{"output negative", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(20), c.pay(-7.5))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "金额 -7.500000 无效"},
+		{"output infinity", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(math.Inf(1)))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "金额 +Inf 无效"},
+		{"output exceeds input", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(13))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "超过了input金额"},
+		{"double spend", func(c *testChain, funds *tx.Transaction) *block.Block {
+			first := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			second := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(11))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), first, second)
+		}, false, "已经被花费"},
+		{"duplicate transaction", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend, spend)
+		}, false, "重复的交易"},
+		{"missing input", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			spend.TXInputs[0].Index = 1
+			spend.SetTXID()
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "不存在或者已经被花费"},
+		{"bad signature", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			spend.TXOutputs[0].Value = 11
+			spend.SetTXID()
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "签名无效"},
+		{"wrong txid", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			spend.TXid = []byte("not the hash")
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), spend)
+		}, false, "交易id"},
+		{"no coinbase", func(c *testChain, funds *tx.Transaction) *block.Block {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(10))
+			return c.newBlock(spend)
+		}, false, "第一个交易必须是挖矿交易"},
+		{"two coinbases", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), tx.NewCoinbaseTx(c.addr, ""))
+		}, false, "只能有一个挖矿交易"},
+		{"bad merkle root", func(c *testChain, funds *tx.Transaction) *block.Block {
+			b := c.newBlock(tx.NewCoinbaseTx(c.addr, ""))
+			b.MerkleRoot = make([]byte, 32)
+			return remine(b)
+		}, false, "梅克尔根不正确"},
+		{"bad proof of work", func(c *testChain, funds *tx.Transaction) *block.Block {
+			b := c.newBlock(tx.NewCoinbaseTx(c.addr, ""))
+			b.Nonce++
+			return b
+		}, false, "工作量证明无效"},
+		{"stale parent", func(c *testChain, funds *tx.Transaction) *block.Block {
+			b := c.newBlock(tx.NewCoinbaseTx(c.addr, ""))
+			b.PrevBlockHash = funds.TXid
+			return remine(b)
+		}, false, "不是在当前链尾之后创建的"},
+		{"unknown version", func(c *testChain, funds *tx.Transaction) *block.Block {
+			b := c.newBlock(tx.NewCoinbaseTx(c.addr, ""))
+			b.Version = 1
+			return remine(b)
+		}, false, "不支持的区块版本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+			funds := c.mine(t).Transactions[0]
+			tip := c.Tip()
+
+			err := c.SubmitBlock(tt.build(c, funds))
+			if tt.ok && err != nil {
+				t.Fatalf("SubmitBlock: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("SubmitBlock accepted an invalid block")
+				}
+				if !strings.Contains(err.Error(), tt.reason) {
+					t.Fatalf("SubmitBlock = %v, want %q", err, tt.reason)
+				}
+				if !bytes.Equal(c.Tip(), tip) {
+					t.Fatal("rejected block changed the tip")
+				}
+			}
+		})
+	}
+}
+
+//模板中的交易按祖先包的费率选择，挖出的区块能通过校验
+func TestNewBlockTemplate(t *testing.T) {
+	c := newTestChain(t)
+	funds := c.mine(t).Transactions[0]
+
+	spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(12))
+	if err := c.AcceptToPool(spend); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := c.NewBlockTemplate(c.addr, MaxBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpl.Entries) != 1 || math.Abs(tmpl.Fees-0.5) > 1e-9 {
+		t.Fatalf("template has %d entries and fees %f", len(tmpl.Entries), tmpl.Fees)
+	}
+	if value := tmpl.Block.Transactions[0].TXOutputs[0].Value; value != tx.Reward+tmpl.Fees {
+		t.Fatalf("coinbase value %f, want %f", value, tx.Reward+tmpl.Fees)
+	}
+
+	if err := c.SubmitBlock(remine(tmpl.Block)); err != nil {
+		t.Fatalf("SubmitBlock: %v", err)
+	}
+	if len(c.PoolEntries()) != 0 {
+		t.Fatal("mined transaction is still in the pool")
+	}
+
+	if _, err := c.NewBlockTemplate("invalid", MaxBlockSize); err == nil {
+		t.Fatal("NewBlockTemplate accepted an invalid miner address")
+	}
+	if _, err := c.NewBlockTemplate(c.addr, 10); err == nil {
+		t.Fatal("NewBlockTemplate accepted a size limit smaller than the header")
+	}
+}
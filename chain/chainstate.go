@@ -0,0 +1,323 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+)
+
+//链状态：区块索引和UTXO集合，在区块连接到链上时更新
+//有了链状态，查询余额、签名和校验交易都不需要遍历区块，区块体被裁剪之后也能正常工作
+
+const (
+	blockIndexBucketName = "blockIndexBucket" //区块哈希 -> 高度和区块头
+	heightBucketName     = "heightBucket"     //高度 -> 主链上的区块哈希
+	utxoBucketName       = "utxoBucket"       //output定位 -> UTXOEntry
+	chainStateBucketName = "chainStateBucket" //链状态的元数据
+	undoBucketName       = "undoBucket"       //区块哈希 -> 区块花费的UTXO(撤销数据)
+	utxoTipKey           = "utxoTip"          //UTXO集合对应的链尾
+)
+
+//UTXO集合中的一条记录，除了output本身还记录了它所在区块的高度和是否来自挖矿交易
+type UTXOEntry struct {
+	Output   tx.TXOutput
+	Height   uint64
+	Coinbase bool
+}
+
+func (u *UTXOEntry) encode(e *wire.Encoder) {
+	e.PutFloat64(u.Output.Value)
+	e.PutBytes(u.Output.PubKeyHash)
+	e.PutUvarint(u.Height)
+	if u.Coinbase {
+		e.PutUvarint(1)
+	} else {
+		e.PutUvarint(0)
+	}
+}
+
+func (u *UTXOEntry) decode(d *wire.Decoder) {
+	u.Output.Value = d.GetFloat64()
+	u.Output.PubKeyHash = d.GetBytes()
+	u.Height = d.GetUvarint()
+	u.Coinbase = d.GetUvarint() != 0
+}
+
+func (u *UTXOEntry) serialize() []byte {
+	var e wire.Encoder
+	u.encode(&e)
+	return e.Bytes()
+}
+
+func deserializeUTXOEntry(data []byte) (*UTXOEntry, error) {
+	var entry UTXOEntry
+
+	d := wire.NewDecoder(data)
+	entry.decode(d)
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+//UTXO集合的key(outpointBytes)拆分为交易id和索引
+func splitOutpoint(key []byte) ([]byte, int64) {
+	n := len(key) - 8
+	return append([]byte{}, key[:n]...), int64(binary.LittleEndian.Uint64(key[n:]))
+}
+
+func chainStateBuckets(btx store.Tx) error {
+	for _, name := range []string{blockIndexBucketName, heightBucketName, utxoBucketName, chainStateBucketName, undoBucketName} {
+		if _, err := btx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeBlockIndex(height uint64, block *block.Block) []byte {
+	var e wire.Encoder
+	e.PutUvarint(height)
+	e.PutBytes(block.Header().Serialize())
+	return e.Bytes()
+}
+
+//从区块索引中读取区块的高度和区块头
+func blockIndex(btx store.Tx, hash []byte) (uint64, *block.Block, error) {
+	b := btx.Bucket([]byte(blockIndexBucketName))
+	if b == nil {
+		return 0, nil, fmt.Errorf("%w: %x", ErrUnknownBlock, hash)
+	}
+
+	data := b.Get(hash)
+	if data == nil {
+		return 0, nil, fmt.Errorf("%w: %x", ErrUnknownBlock, hash)
+	}
+
+	d := wire.NewDecoder(data)
+	height := d.GetUvarint()
+	headerData := d.GetBytes()
+	if err := d.Finish(); err != nil {
+		return 0, nil, err
+	}
+
+	header, err := block.DecodeHeader(headerData)
+	return height, header, err
+}
+
+//更新链状态：写入区块索引，花费input引用的UTXO，加入新的output
+//同一个区块中后面的交易可以花费前面交易的output
+//被花费的UTXO按顺序写入撤销数据，断开区块时用来恢复
+func connectChainState(btx store.Tx, block *block.Block) (uint64, error) {
+	if err := chainStateBuckets(btx); err != nil {
+		return 0, err
+	}
+
+	var height uint64
+	if len(block.PrevBlockHash) > 0 {
+		prevHeight, _, err := blockIndex(btx, block.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		height = prevHeight + 1
+	}
+
+	if err := btx.Bucket([]byte(blockIndexBucketName)).Put(block.Hash, encodeBlockIndex(height, block)); err != nil {
+		return 0, err
+	}
+	if err := btx.Bucket([]byte(heightBucketName)).Put(uintToByte(height), block.Hash); err != nil {
+		return 0, err
+	}
+
+	utxos := btx.Bucket([]byte(utxoBucketName))
+	var spent []*UTXOEntry
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, input := range tx.TXInputs {
+				key := filter.OutpointBytes(input.TXID, input.Index)
+				data := utxos.Get(key)
+				if data == nil {
+					return 0, fmt.Errorf("交易 %x: %w: %x:%d", tx.TXid, ErrMissingUTXO, input.TXID, input.Index)
+				}
+
+				entry, err := deserializeUTXOEntry(data)
+				if err != nil {
+					return 0, err
+				}
+				spent = append(spent, entry)
+
+				if err := utxos.Delete(key); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		for i, output := range tx.TXOutputs {
+			//数据output不可花费，不进入UTXO集合
+			if output.IsDataOutput() {
+				continue
+			}
+
+			entry := UTXOEntry{Output: output, Height: height, Coinbase: tx.IsCoinbase()}
+			if err := utxos.Put(filter.OutpointBytes(tx.TXid, int64(i)), entry.serialize()); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := btx.Bucket([]byte(undoBucketName)).Put(block.Hash, encodeUndo(spent)); err != nil {
+		return 0, err
+	}
+
+	return height, btx.Bucket([]byte(chainStateBucketName)).Put([]byte(utxoTipKey), block.Hash)
+}
+
+//旧的数据库没有链状态，或者链状态与链尾不一致时，从创世块开始重建
+//gob格式的旧数据库需要先执行migrateDB
+func ensureChainState(db store.Store, tail []byte) error {
+	return db.Update(func(btx store.Tx) error {
+		if state := btx.Bucket([]byte(chainStateBucketName)); state != nil && bytes.Equal(state.Get([]byte(utxoTipKey)), tail) {
+			return nil
+		}
+
+		blocks := btx.Bucket([]byte(blockBucketName))
+		if !block.IsCanonical(blocks.Get(tail)) {
+			chainLog.Warn("区块链数据库是旧的gob格式，请先执行migrateDB")
+			return nil
+		}
+
+		chainLog.Info("正在重建UTXO集合和区块索引", logging.Hex("tail", tail))
+
+		for _, name := range []string{blockIndexBucketName, heightBucketName, utxoBucketName, undoBucketName} {
+			if btx.Bucket([]byte(name)) == nil {
+				continue
+			}
+			if err := btx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		var hashes [][]byte
+		for hash := tail; len(hash) > 0; {
+			data := blocks.Get(hash)
+			if data == nil {
+				return fmt.Errorf("区块 %x 不存在，无法重建链状态", hash)
+			}
+			blk, err := block.Deserialize(data)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+			hash = blk.PrevBlockHash
+		}
+
+		//地址索引也从创世块开始重建
+		if _, ok := addrIndexFrom(btx); ok {
+			if err := resetAddrIndex(btx, 0); err != nil {
+				return err
+			}
+		}
+
+		for i := len(hashes) - 1; i >= 0; i-- {
+			blk, err := block.Deserialize(blocks.Get(hashes[i]))
+			if err != nil {
+				return err
+			}
+			height, err := connectChainState(btx, blk)
+			if err != nil {
+				return err
+			}
+			if err := connectAddrIndex(btx, blk, height); err != nil {
+				return err
+			}
+		}
+
+
+		chainLog.Info("链状态重建完成", "blocks", len(hashes))
+		return nil
+	})
+}
+
+func (bc *BlockChain) GetUTXO(txid []byte, index int64) (*UTXOEntry, error) {
+	var entry *UTXOEntry
+
+	err := bc.db.View(func(btx store.Tx) error {
+		b := btx.Bucket([]byte(utxoBucketName))
+		if b == nil {
+			return ErrMissingUTXO
+		}
+
+		data := b.Get(filter.OutpointBytes(txid, index))
+		if data == nil {
+			return ErrMissingUTXO
+		}
+
+		var err error
+		entry, err = deserializeUTXOEntry(data)
+		return err
+	})
+
+	return entry, err
+}
+
+//当前链尾的高度
+func (bc *BlockChain) Height() uint64 {
+	var height uint64
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		height, _, _ = blockIndex(btx, bc.tail)
+		return nil
+	})
+
+	return height
+}
+
+//把input引用的output组装成Sign和Verify需要的prevTXs
+//output来自UTXO集合，找不到时到交易池中查找(花费未确认的output)
+//UTXO集合中只有output，没有完整的交易，所以组装出来的交易只填写了被引用的output
+func (bc *BlockChain) findPrevTXs(t *tx.Transaction) map[string]tx.Transaction {
+	prevTXs := make(map[string]tx.Transaction)
+	var pool map[string]*TxPoolEntry
+
+	for _, input := range t.TXInputs {
+		if input.Index < 0 {
+			continue
+		}
+
+		var output *tx.TXOutput
+		if entry, err := bc.GetUTXO(input.TXID, input.Index); err == nil {
+			output = &entry.Output
+		} else {
+			if pool == nil {
+				pool = bc.PoolEntries()
+			}
+			if poolEntry, ok := pool[string(input.TXID)]; ok && input.Index < int64(len(poolEntry.Tx.TXOutputs)) {
+				output = &poolEntry.Tx.TXOutputs[input.Index]
+			}
+		}
+
+		if output == nil {
+			txLog.Debug("没有找到input引用的output", logging.Hex("txid", input.TXID), "index", input.Index)
+
+			continue
+		}
+
+		prevTX := prevTXs[string(input.TXID)]
+		prevTX.TXid = input.TXID
+		for int64(len(prevTX.TXOutputs)) <= input.Index {
+			prevTX.TXOutputs = append(prevTX.TXOutputs, tx.TXOutput{})
+		}
+		prevTX.TXOutputs[input.Index] = *output
+		prevTXs[string(input.TXID)] = prevTX
+	}
+
+	return prevTXs
+}
@@ -0,0 +1,18 @@
+package chain
+
+import "errors"
+
+//对外的错误类型，返回时用fmt.Errorf("%w: ...")附带上下文，调用者用errors.Is判断
+var (
+	ErrChainExists   = errors.New("区块链已经存在")
+	ErrChainNotFound = errors.New("区块链不存在")
+	ErrChainLocked   = errors.New("区块链数据库被占用，节点可能正在运行")
+	ErrUnknownBlock  = errors.New("区块不在链上")
+	ErrPrunedBlock   = errors.New("区块体已经被裁剪")
+	ErrInvalidBlock  = errors.New("区块已被标记为无效")
+
+	ErrMissingUTXO       = errors.New("引用的output不在UTXO集合中")
+	ErrTxNotFound        = errors.New("交易不存在")
+	ErrNonFinal          = errors.New("交易还没有到锁定时间")
+	ErrInsufficientFunds = errors.New("余额不足")
+)
@@ -0,0 +1,266 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+)
+
+//区块导出文件，用于在机器之间迁移区块链，与数据库的存储格式无关
+//  魔数 "BTCBLKS\x00" | 版本 | 起始高度 | 区块个数
+//  每个区块: 长度 + 区块的规范编码
+//整数都是uvarint，区块按高度从小到大排列
+//文件可以用gzip压缩，读取时根据文件开头的两个字节自动识别
+
+const exportMagic = "BTCBLKS\x00"
+const exportVersion = 1
+
+var ErrBadExportFile = errors.New("不是有效的区块导出文件")
+
+//按高度写出主链上[from, to]的区块，progress在每个区块写出之后调用
+//区块体被裁剪的区块无法导出
+func (bc *BlockChain) ExportBlocks(w io.Writer, from, to uint64, progress func(done, total uint64)) error {
+	if tip := bc.Height(); to > tip {
+		return fmt.Errorf("高度 %d 超过了链尾的高度 %d", to, tip)
+	}
+	if from > to {
+		return fmt.Errorf("起始高度 %d 大于结束高度 %d", from, to)
+	}
+	total := to - from + 1
+
+	var header wire.Encoder
+	header.PutRaw([]byte(exportMagic))
+	header.PutUvarint(exportVersion)
+	header.PutUvarint(from)
+	header.PutUvarint(total)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	for height := from; height <= to; height++ {
+		var record wire.Encoder
+
+		err := bc.db.View(func(btx store.Tx) error {
+			data, err := blockDataAtHeight(btx, height)
+			record.PutBytes(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(record.Bytes()); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(height-from+1, total)
+		}
+	}
+
+	return nil
+}
+
+//主链上指定高度的区块的编码
+func blockDataAtHeight(btx store.Tx, height uint64) ([]byte, error) {
+	hash := btx.Bucket([]byte(heightBucketName)).Get(uintToByte(height))
+	if hash == nil {
+		return nil, fmt.Errorf("高度 %d 不在主链上", height)
+	}
+	data := btx.Bucket([]byte(blockBucketName)).Get(hash)
+	if data == nil {
+		return nil, fmt.Errorf("%w: 高度 %d", ErrPrunedBlock, height)
+	}
+	return data, nil
+}
+
+//从高度from开始最多count个主链上的区块，到链尾为止
+func (bc *BlockChain) BlocksByHeight(from uint64, count int) ([]*block.Block, error) {
+	var blocks []*block.Block
+
+	err := bc.db.View(func(btx store.Tx) error {
+		tipHeight, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+
+		for height := from; height <= tipHeight && len(blocks) < count; height++ {
+			data, err := blockDataAtHeight(btx, height)
+			if err != nil {
+				return err
+			}
+			b, err := block.Deserialize(data)
+			if err != nil {
+				return fmt.Errorf("高度 %d: %w", height, err)
+			}
+			blocks = append(blocks, b)
+		}
+		return nil
+	})
+
+	return blocks, err
+}
+
+//顺序读取导出文件中的区块
+type BlockReader struct {
+	r     *bufio.Reader
+	From  uint64 //第一个区块的高度
+	Count uint64 //文件中的区块个数
+	read  uint64
+}
+
+//读取文件头，gzip压缩的文件自动解压
+func NewBlockReader(r io.Reader) (*BlockReader, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != exportMagic {
+		return nil, ErrBadExportFile
+	}
+
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrBadExportFile
+	}
+	if version != exportVersion {
+		return nil, fmt.Errorf("%w: 不支持的版本 %d", ErrBadExportFile, version)
+	}
+
+	reader := &BlockReader{r: br}
+	if reader.From, err = binary.ReadUvarint(br); err != nil {
+		return nil, ErrBadExportFile
+	}
+	if reader.Count, err = binary.ReadUvarint(br); err != nil {
+		return nil, ErrBadExportFile
+	}
+
+	return reader, nil
+}
+
+//下一个区块和它的高度，读完之后返回io.EOF
+func (r *BlockReader) Next() (*block.Block, uint64, error) {
+	if r.read == r.Count {
+		return nil, 0, io.EOF
+	}
+	height := r.From + r.read
+
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: 高度 %d: %v", ErrBadExportFile, height, err)
+	}
+	if size > MaxBlockSize {
+		return nil, 0, fmt.Errorf("%w: 高度 %d 的区块大小 %d 超过上限", ErrBadExportFile, height, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, 0, fmt.Errorf("%w: 高度 %d: %v", ErrBadExportFile, height, err)
+	}
+
+	b, err := block.Deserialize(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("高度 %d: %w", height, err)
+	}
+
+	r.read++
+	return b, height, nil
+}
+
+//导入一个区块：主链上已经有的区块跳过，返回false
+//其他区块和SubmitBlock一样完整校验之后再连接，必须连接在链尾之后
+func (bc *BlockChain) ImportBlock(b *block.Block, height uint64) (bool, error) {
+	var existing []byte
+	_ = bc.db.View(func(btx store.Tx) error {
+		existing = append([]byte{}, btx.Bucket([]byte(heightBucketName)).Get(uintToByte(height))...)
+		return nil
+	})
+
+	if len(existing) > 0 {
+		if !bytes.Equal(existing, b.Hash) {
+			return false, fmt.Errorf("高度 %d 的区块 %x 与链上的区块 %x 不同", height, b.Hash, existing)
+		}
+		return false, nil
+	}
+
+	if err := bc.SubmitBlock(b); err != nil {
+		return false, fmt.Errorf("高度 %d 的区块 %x: %w", height, b.Hash, err)
+	}
+	return true, nil
+}
+
+//用导出文件中的创世块创建区块链，创世块只能校验它自身
+func CreateBlockChainFromGenesis(genesis *block.Block) (*BlockChain, error) {
+	path := DBPath()
+
+	if IsFileExist(path) {
+		return nil, fmt.Errorf("%w: %s", ErrChainExists, path)
+	}
+	if err := checkGenesis(genesis); err != nil {
+		return nil, err
+	}
+
+	db, err := store.Open(Backend, path, dbOpenTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := createGenesis(db, genesis)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+//创世块没有前一个区块，只有一个挖矿交易，金额不超过挖矿奖励
+func checkGenesis(genesis *block.Block) error {
+	if len(genesis.PrevBlockHash) != 0 {
+		return fmt.Errorf("区块 %x 不是创世块", genesis.Hash)
+	}
+	if len(genesis.Transactions) != 1 || !genesis.Transactions[0].IsCoinbase() {
+		return errors.New("创世块中只能有一个挖矿交易")
+	}
+	coinbase := genesis.Transactions[0]
+	if err := coinbase.CheckTXID(); err != nil {
+		return err
+	}
+
+	expected := block.Block{Version: genesis.Version, Transactions: genesis.Transactions}
+	expected.HashTransactions()
+	if !bytes.Equal(genesis.MerkleRoot, expected.MerkleRoot) {
+		return fmt.Errorf("梅克尔根不正确: %x，应该是 %x", genesis.MerkleRoot, expected.MerkleRoot)
+	}
+
+	if err := pow.Check(genesis); err != nil {
+		return err
+	}
+
+	var value float64
+	for _, output := range coinbase.TXOutputs {
+		value += output.Value
+	}
+	if value > tx.Reward {
+		return fmt.Errorf("创世块的挖矿金额 %f 超过了奖励 %f", value, tx.Reward)
+	}
+
+	chainLog.Info("创世块校验通过", logging.Hex("hash", genesis.Hash))
+	return nil
+}
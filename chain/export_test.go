@@ -0,0 +1,181 @@
+package chain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/store"
+)
+
+//和ExportBlocks相同格式的导出文件，用来写出被篡改的区块
+func encodeExportFile(from uint64, blocks []*block.Block) []byte {
+	var e wire.Encoder
+	e.PutRaw([]byte(exportMagic))
+	e.PutUvarint(exportVersion)
+	e.PutUvarint(from)
+	e.PutUvarint(uint64(len(blocks)))
+	for _, b := range blocks {
+		e.PutBytes(b.Serialize())
+	}
+	return e.Bytes()
+}
+
+func readExportFile(t *testing.T, data []byte) []*block.Block {
+	t.Helper()
+
+	reader, err := NewBlockReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*block.Block
+	for {
+		b, _, err := reader.Next()
+		if err == io.EOF {
+			return blocks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+}
+
+//和import命令一样：用文件中的创世块创建新的区块链，然后逐个导入之后的区块
+//返回的区块链包含出错之前导入的所有区块
+func importFile(data []byte) (*BlockChain, error) {
+	reader, err := NewBlockReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	genesis, _, err := reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGenesis(genesis); err != nil {
+		return nil, err
+	}
+	bc, err := createGenesis(store.OpenMemory(), genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		b, height, err := reader.Next()
+		if err == io.EOF {
+			return bc, nil
+		}
+		if err == nil {
+			_, err = bc.ImportBlock(b, height)
+		}
+		if err != nil {
+			return bc, err
+		}
+	}
+}
+
+func TestImportTamperedFile(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 3; i++ {
+		c.mine(t)
+	}
+
+	var exported bytes.Buffer
+	if err := c.ExportBlocks(&exported, 0, c.Height(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(blocks []*block.Block) []byte
+		height uint64 //导入之后的链尾高度
+		reason string //空表示导入成功
+	}{
+		{"untouched", func(blocks []*block.Block) []byte {
+			return exported.Bytes()
+		}, 3, ""},
+		{"gzip", func(blocks []*block.Block) []byte {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(exported.Bytes())
+			zw.Close()
+			return buf.Bytes()
+		}, 3, ""},
+		//重新计算交易id、梅克尔根和工作量证明，只有金额检查能发现
+		{"inflated coinbase", func(blocks []*block.Block) []byte {
+			inflate(blocks[2])
+			blocks[2].HashTransactions()
+			remine(blocks[2])
+			return encodeExportFile(0, blocks)
+		}, 1, "超过了奖励加手续费"},
+		{"inflated coinbase with old merkle root", func(blocks []*block.Block) []byte {
+			inflate(blocks[2])
+			return encodeExportFile(0, blocks)
+		}, 1, "梅克尔根不正确"},
+		{"inflated coinbase with old proof of work", func(blocks []*block.Block) []byte {
+			inflate(blocks[2])
+			blocks[2].HashTransactions()
+			return encodeExportFile(0, blocks)
+		}, 1, "工作量证明无效"},
+		{"inflated genesis", func(blocks []*block.Block) []byte {
+			inflate(blocks[0])
+			blocks[0].HashTransactions()
+			remine(blocks[0])
+			return encodeExportFile(0, blocks)
+		}, 0, "超过了奖励"},
+		{"truncated", func(blocks []*block.Block) []byte {
+			return exported.Bytes()[:exported.Len()-10]
+		}, 2, ErrBadExportFile.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.tamper(readExportFile(t, exported.Bytes()))
+
+			bc, err := importFile(data)
+			if bc != nil {
+				defer bc.Close()
+			}
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("import: %v", err)
+				}
+				if !bytes.Equal(bc.Tip(), c.Tip()) {
+					t.Fatalf("imported tip = %x, want %x", bc.Tip(), c.Tip())
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("tampered file was imported")
+			}
+			if !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("import = %v, want %q", err, tt.reason)
+			}
+			//出错之前的区块已经导入，被篡改的区块没有
+			if bc != nil && bc.Height() != tt.height {
+				t.Fatalf("height after failed import = %d, want %d", bc.Height(), tt.height)
+			}
+			if bc == nil && tt.height != 0 {
+				t.Fatal("no chain was created")
+			}
+		})
+	}
+
+	if _, err := importFile([]byte("not an export file")); !errors.Is(err, ErrBadExportFile) {
+		t.Fatalf("import garbage = %v, want ErrBadExportFile", err)
+	}
+}
+
+//挖矿交易的金额增加10，并重新计算交易id
+func inflate(b *block.Block) {
+	coinbase := b.Transactions[0]
+	coinbase.TXOutputs[0].Value += 10
+	coinbase.SetTXID()
+}
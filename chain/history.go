@@ -0,0 +1,291 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ELOATS/btc/address"
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//地址的交易历史：所有给地址转账(output)和花费地址的钱(input)的交易
+//input花费的金额来自区块的撤销数据，所以被裁剪的区块不在历史中
+//开启了地址索引时不需要遍历区块，见addrindex.go
+
+//主链上的一个交易的位置
+type txRef struct {
+	Height uint64
+	Index  int //交易在区块中的序号
+}
+
+//交易历史中的一条记录，金额都是对于查询的地址而言的
+type HistoryEntry struct {
+	TXID      []byte
+	BlockHash []byte //交易池中的交易为空
+	Height    uint64
+	TimeStamp uint64 //区块的时间戳，交易池中的交易是进入交易池的时间
+	Received  float64
+	Sent      float64
+	Coinbase  bool
+
+	//对方的地址：花费了这个地址的钱时是收款方，否则是付款方，都不包括地址自己
+	Counterparties []string
+
+	//0表示还在交易池中
+	Confirmations uint64
+}
+
+//地址的净收入，负数表示支出
+func (e *HistoryEntry) Net() float64 {
+	return e.Received - e.Sent
+}
+
+//和pubKeyHash有关的交易的位置，开启了地址索引时从索引中读取，否则遍历区块
+func addressTxs(btx store.Tx, tipHeight uint64, pubKeyHash []byte) ([]txRef, error) {
+	if _, ok := addrIndexFrom(btx); !ok {
+		return scanAddressTxs(btx, tipHeight, pubKeyHash)
+	}
+
+	records, err := addressRecords(btx, pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	//索引中可能还有后来被裁剪的区块
+	pruned := getStateUint(btx.Bucket([]byte(chainStateBucketName)), prunedHeightKey)
+
+	var refs []txRef
+	for _, r := range records {
+		if r.Height >= pruned {
+			refs = append(refs, txRef{r.Height, r.TxIndex})
+		}
+	}
+	return refs, nil
+}
+
+//遍历主链上没有被裁剪的区块，找到和pubKeyHash有关的交易，按高度排列
+func scanAddressTxs(btx store.Tx, tipHeight uint64, pubKeyHash []byte) ([]txRef, error) {
+	var refs []txRef
+
+	from := getStateUint(btx.Bucket([]byte(chainStateBucketName)), prunedHeightKey)
+	for height := from; height <= tipHeight; height++ {
+		data, err := blockDataAtHeight(btx, height)
+		if err != nil {
+			return nil, err
+		}
+		b, err := block.Deserialize(data)
+		if err != nil {
+			return nil, fmt.Errorf("高度 %d: %w", height, err)
+		}
+
+		for i, t := range b.Transactions {
+			if txInvolves(t, pubKeyHash) {
+				refs = append(refs, txRef{height, i})
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+//交易是否给pubKeyHash转账，或者花费了pubKeyHash的output
+func txInvolves(t *tx.Transaction, pubKeyHash []byte) bool {
+	for _, output := range t.TXOutputs {
+		if !output.IsDataOutput() && bytes.Equal(output.PubKeyHash, pubKeyHash) {
+			return true
+		}
+	}
+	if t.IsCoinbase() {
+		return false
+	}
+	for _, input := range t.TXInputs {
+		if bytes.Equal(wallet.HashPubKey(input.PubKey), pubKeyHash) {
+			return true
+		}
+	}
+	return false
+}
+
+//地址的交易历史，先是链上的交易(按高度)，然后是交易池中的交易
+func (bc *BlockChain) AddressHistory(addr string) ([]HistoryEntry, error) {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+
+	err = bc.db.View(func(btx store.Tx) error {
+		tipHeight, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+
+		refs, err := addressTxs(btx, tipHeight, pubKeyHash)
+		if err != nil {
+			return err
+		}
+
+		entries, err = historyEntries(btx, tipHeight, addr, pubKeyHash, refs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//交易池中的交易按进入交易池的时间排列
+	var pending []HistoryEntry
+	for _, poolEntry := range bc.PoolEntries() {
+		t := poolEntry.Tx
+		if !txInvolves(t, pubKeyHash) {
+			continue
+		}
+
+		var spent []*UTXOEntry
+		prevTXs := bc.findPrevTXs(t)
+		for _, input := range t.TXInputs {
+			prevTX, ok := prevTXs[string(input.TXID)]
+			if !ok || input.Index >= int64(len(prevTX.TXOutputs)) {
+				return nil, fmt.Errorf("交易池中的交易 %x: %w: %x:%d", t.TXid, ErrMissingUTXO, input.TXID, input.Index)
+			}
+			spent = append(spent, &UTXOEntry{Output: prevTX.TXOutputs[input.Index]})
+		}
+
+		entry := newHistoryEntry(t, spent, addr, pubKeyHash)
+		entry.TimeStamp = poolEntry.Time
+		pending = append(pending, entry)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].TimeStamp < pending[j].TimeStamp
+	})
+
+	return append(entries, pending...), nil
+}
+
+//按照交易的位置组装历史记录，同一个区块的交易只读取一次区块和撤销数据
+func historyEntries(btx store.Tx, tipHeight uint64, addr string, pubKeyHash []byte, refs []txRef) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+
+	var b *block.Block
+	var spent [][]*UTXOEntry //区块中每个交易花费的UTXO
+
+	for _, ref := range refs {
+		if b == nil || ref.Height != entries[len(entries)-1].Height {
+			var err error
+			b, spent, err = blockWithUndo(btx, ref.Height)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ref.Index >= len(b.Transactions) {
+			return nil, fmt.Errorf("高度 %d 的区块没有第 %d 个交易", ref.Height, ref.Index)
+		}
+
+		entry := newHistoryEntry(b.Transactions[ref.Index], spent[ref.Index], addr, pubKeyHash)
+		entry.BlockHash = b.Hash
+		entry.Height = ref.Height
+		entry.TimeStamp = b.TimeStamp
+		entry.Confirmations = tipHeight - ref.Height + 1
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+//主链上指定高度的区块，以及按交易拆分的撤销数据
+func blockWithUndo(btx store.Tx, height uint64) (*block.Block, [][]*UTXOEntry, error) {
+	data, err := blockDataAtHeight(btx, height)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := block.Deserialize(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("高度 %d: %w", height, err)
+	}
+
+	undoData := btx.Bucket([]byte(undoBucketName)).Get(b.Hash)
+	if undoData == nil {
+		return nil, nil, fmt.Errorf("%w: %x", errMissingUndo, b.Hash)
+	}
+	undo, err := decodeUndo(undoData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//撤销数据按照交易和input的顺序保存，挖矿交易没有
+	spent := make([][]*UTXOEntry, len(b.Transactions))
+	for i, t := range b.Transactions {
+		if t.IsCoinbase() {
+			continue
+		}
+		if len(undo) < len(t.TXInputs) {
+			return nil, nil, fmt.Errorf("区块 %x 的撤销数据不完整", b.Hash)
+		}
+		spent[i], undo = undo[:len(t.TXInputs)], undo[len(t.TXInputs):]
+	}
+
+	return b, spent, nil
+}
+
+//spent是交易的input花费的UTXO，和input一一对应
+func newHistoryEntry(t *tx.Transaction, spent []*UTXOEntry, addr string, pubKeyHash []byte) HistoryEntry {
+	entry := HistoryEntry{TXID: t.TXid, Coinbase: t.IsCoinbase()}
+
+	//output中只有公钥哈希，看不出地址的版本号(密钥类型)
+	//能从input的公钥得知类型的地址用正确的版本号，其他的按默认的P-256显示
+	known := map[string]string{string(pubKeyHash): addr}
+	var payers []string
+
+	for i, output := range spent {
+		input := t.TXInputs[i]
+		payer := wallet.AddressFromPubKey(input.PubKey)
+		known[string(wallet.HashPubKey(input.PubKey))] = payer
+
+		if bytes.Equal(output.Output.PubKeyHash, pubKeyHash) {
+			entry.Sent += output.Output.Value
+		} else {
+			payers = appendAddress(payers, payer, addr)
+		}
+	}
+
+	var payees []string
+	for _, output := range t.TXOutputs {
+		if output.IsDataOutput() {
+			continue
+		}
+		if bytes.Equal(output.PubKeyHash, pubKeyHash) {
+			entry.Received += output.Value
+			continue
+		}
+
+		payee, ok := known[string(output.PubKeyHash)]
+		if !ok {
+			payee = address.Encode(byte(wallet.KeyTypeP256), output.PubKeyHash)
+		}
+		payees = appendAddress(payees, payee, addr)
+	}
+
+	if entry.Sent > 0 {
+		entry.Counterparties = payees
+	} else {
+		entry.Counterparties = payers
+	}
+	return entry
+}
+
+//去重，不包括地址自己
+func appendAddress(addresses []string, addr string, self string) []string {
+	if addr == self {
+		return addresses
+	}
+	for _, a := range addresses {
+		if a == addr {
+			return addresses
+		}
+	}
+	return append(addresses, addr)
+}
@@ -0,0 +1,120 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+)
+
+//区块时间戳的共识规则(版本2开始)：
+//1. 时间戳必须大于前11个区块时间戳的中位数(median-time-past，MTP)，矿工无法把时间往回拨
+//2. 时间戳最多比本地时间晚MaxFutureBlockTime秒，矿工无法把时间往后拨太多
+//节点之间没有P2P连接，拿不到其他节点的时间样本，所以不像比特币那样用节点时间的中位数调整本地时钟，
+//直接使用本地时间，本地时钟偏差较大的节点会拒绝有效的区块或者挖出被拒绝的区块
+//MTP只会随着区块增长单调不减，所以交易的锁定时间也和MTP比较(BIP113)，而不是和矿工填写的时间戳比较
+
+const blockVersionTime = 2
+
+//计算MTP使用的区块个数
+const medianTimeBlocks = 11
+
+//区块时间戳最多比本地时间晚多少秒，可以用--maxFutureTime=SECONDS修改
+var MaxFutureBlockTime uint64 = 2 * 60 * 60
+
+func localTime() uint64 {
+	return uint64(time.Now().Unix())
+}
+
+//区块头从区块索引中读取，区块体被裁剪之后也可以计算
+func medianTimePast(btx store.Tx, hash []byte) (uint64, error) {
+	return MedianTimePast(func(hash []byte) (*block.Block, error) {
+		_, header, err := blockIndex(btx, hash)
+		return header, err
+	}, hash)
+}
+
+//hash以及之前共11个区块时间戳的中位数，hash为空(创世块之前)时返回0
+//headerOf按哈希读取区块头，SPV客户端用自己保存的区块头计算
+func MedianTimePast(headerOf func(hash []byte) (*block.Block, error), hash []byte) (uint64, error) {
+	var timestamps []uint64
+
+	for len(hash) > 0 && len(timestamps) < medianTimeBlocks {
+		header, err := headerOf(hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.TimeStamp)
+		hash = header.PrevBlockHash
+	}
+
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+//链尾的MTP，也就是下一个区块的时间戳下限(不含)
+func (bc *BlockChain) MedianTimePast() uint64 {
+	var mtp uint64
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		var err error
+		mtp, err = medianTimePast(btx, bc.tail)
+		return err
+	})
+
+	return mtp
+}
+
+//下一个区块使用的时间戳：本地时间，但至少比MTP大1秒
+func (bc *BlockChain) nextBlockTime() uint64 {
+	return max(localTime(), bc.MedianTimePast()+1)
+}
+
+//检查区块的时间戳
+func checkBlockTime(btx store.Tx, header *block.Block) error {
+	mtp, err := medianTimePast(btx, header.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	return CheckHeaderTime(header, mtp)
+}
+
+//区块头的时间戳规则，mtp是前一个区块的MTP，版本2之前的区块只检查是否超前
+//SPV客户端只有区块头，也使用这个检查
+func CheckHeaderTime(header *block.Block, mtp uint64) error {
+	now := localTime()
+	if header.TimeStamp > now+MaxFutureBlockTime {
+		return fmt.Errorf("区块时间戳 %d 比本地时间 %d 晚了 %d 秒以上", header.TimeStamp, now, MaxFutureBlockTime)
+	}
+
+	if header.Version < blockVersionTime {
+		return nil
+	}
+
+	if header.TimeStamp <= mtp {
+		return fmt.Errorf("区块时间戳 %d 必须大于前 %d 个区块的中位数 %d", header.TimeStamp, medianTimeBlocks, mtp)
+	}
+
+	return nil
+}
+
+//检查区块中的交易是否都已经到了锁定时间，高度锁定与区块高度比较，时间锁定与前一个区块的MTP比较
+func checkBlockFinality(btx store.Tx, block *block.Block, height uint64) error {
+	mtp, err := medianTimePast(btx, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsFinal(height, mtp) {
+			return fmt.Errorf("%w: 交易 %x，锁定时间 %d", ErrNonFinal, tx.TXid, tx.LockTime)
+		}
+	}
+	return nil
+}
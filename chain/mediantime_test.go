@@ -0,0 +1,177 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/tx"
+)
+
+//按时间戳依次连接的区块头，返回链尾的哈希
+func headerChain(timestamps []uint64) (map[string]*block.Block, []byte) {
+	headers := make(map[string]*block.Block)
+	var prev []byte
+	for i, ts := range timestamps {
+		hash := []byte(fmt.Sprintf("header %d", i))
+		headers[string(hash)] = &block.Block{Hash: hash, PrevBlockHash: prev, TimeStamp: ts}
+		prev = hash
+	}
+	return headers, prev
+}
+
+func TestMedianTimePast(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []uint64 //从创世块开始
+		want       uint64
+	}{
+		{"no blocks", nil, 0},
+		{"one block", []uint64{100}, 100},
+		{"even count takes upper middle", []uint64{100, 200}, 200},
+		{"unsorted", []uint64{500, 100, 300}, 300},
+		{"eleven blocks", []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, 6},
+		//只看最后11个区块
+		{"older blocks ignored", []uint64{1000, 1000, 1000, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, 6},
+		{"miner sets time back", []uint64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 5}, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, tip := headerChain(tt.timestamps)
+			headerOf := func(hash []byte) (*block.Block, error) {
+				header, ok := headers[string(hash)]
+				if !ok {
+					return nil, ErrUnknownBlock
+				}
+				return header, nil
+			}
+
+			got, err := MedianTimePast(headerOf, tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("MedianTimePast = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	//缺少区块头时返回错误
+	_, err := MedianTimePast(func(hash []byte) (*block.Block, error) { return nil, ErrUnknownBlock }, []byte("missing"))
+	if !errors.Is(err, ErrUnknownBlock) {
+		t.Fatalf("MedianTimePast missing header = %v, want ErrUnknownBlock", err)
+	}
+}
+
+func TestCheckHeaderTime(t *testing.T) {
+	now := localTime()
+	const mtp = 1000
+
+	tests := []struct {
+		name      string
+		version   uint64
+		timestamp uint64
+		ok        bool
+	}{
+		{"after MTP", blockVersionTime, mtp + 1, true},
+		{"equal to MTP", blockVersionTime, mtp, false},
+		{"before MTP", blockVersionTime, mtp - 1, false},
+		{"at future limit", blockVersionTime, now + MaxFutureBlockTime, true},
+		{"too far in future", blockVersionTime, now + MaxFutureBlockTime + 60, false},
+		//版本2之前的区块不检查MTP
+		{"old version before MTP", 1, mtp - 1, true},
+		{"old version too far in future", 1, now + MaxFutureBlockTime + 60, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := &block.Block{Version: tt.version, TimeStamp: tt.timestamp}
+			err := CheckHeaderTime(header, mtp)
+			if tt.ok && err != nil {
+				t.Fatalf("CheckHeaderTime: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("CheckHeaderTime accepted an invalid timestamp")
+			}
+		})
+	}
+}
+
+//设置了锁定时间的交易，花费funds的第一个output
+func (c *testChain) lockedSpend(t *testing.T, funds *tx.Transaction, lockTime uint32, sequence uint32) *tx.Transaction {
+	t.Helper()
+
+	locked := &tx.Transaction{Version: tx.TxVersion, TXOutputs: []tx.TXOutput{c.pay(12)}, LockTime: lockTime}
+	locked.TXInputs = []tx.TXInput{{TXID: funds.TXid, Index: 0, PubKey: c.key.PubKey(), Sequence: sequence}}
+	if err := c.SignTransaction(locked, c.key); err != nil {
+		t.Fatal(err)
+	}
+	locked.SetTXID()
+	return locked
+}
+
+//区块的时间戳规则和区块中交易的锁定时间(BIP113，时间锁定和前一个区块的MTP比较)
+func TestBlockTimeAndLockTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		build  func(c *testChain, funds *tx.Transaction) *block.Block
+		ok     bool
+		err    error  //nil时检查reason
+		reason string //拒绝的原因中包含的文字
+	}{
+		{"timestamp equal to MTP", func(c *testChain, funds *tx.Transaction) *block.Block {
+			return newBlockWithTime([]*tx.Transaction{tx.NewCoinbaseTx(c.addr, "")}, c.tail, c.nextBlockVersion(), c.MedianTimePast())
+		}, false, nil, "必须大于前"},
+		{"timestamp too far in future", func(c *testChain, funds *tx.Transaction) *block.Block {
+			future := localTime() + MaxFutureBlockTime + 60
+			return newBlockWithTime([]*tx.Transaction{tx.NewCoinbaseTx(c.addr, "")}, c.tail, c.nextBlockVersion(), future)
+		}, false, nil, "比本地时间"},
+		{"height lock reached", func(c *testChain, funds *tx.Transaction) *block.Block {
+			locked := c.lockedSpend(t, funds, uint32(c.Height()), tx.SequenceLockTime)
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), locked)
+		}, true, nil, ""},
+		//锁定高度必须小于区块高度
+		{"height lock not reached", func(c *testChain, funds *tx.Transaction) *block.Block {
+			locked := c.lockedSpend(t, funds, uint32(c.Height()+1), tx.SequenceLockTime)
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), locked)
+		}, false, ErrNonFinal, ""},
+		{"time lock reached", func(c *testChain, funds *tx.Transaction) *block.Block {
+			locked := c.lockedSpend(t, funds, uint32(c.MedianTimePast()-1), tx.SequenceLockTime)
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), locked)
+		}, true, nil, ""},
+		//和MTP比较，而不是和区块自己的时间戳比较
+		{"time lock at MTP", func(c *testChain, funds *tx.Transaction) *block.Block {
+			locked := c.lockedSpend(t, funds, uint32(c.MedianTimePast()), tx.SequenceLockTime)
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), locked)
+		}, false, ErrNonFinal, ""},
+		{"lock ignored with final sequence", func(c *testChain, funds *tx.Transaction) *block.Block {
+			locked := c.lockedSpend(t, funds, uint32(c.Height()+100), tx.SequenceFinal)
+			return c.newBlock(tx.NewCoinbaseTx(c.addr, ""), locked)
+		}, true, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+			funds := c.mine(t).Transactions[0]
+			c.mine(t)
+
+			err := c.SubmitBlock(tt.build(c, funds))
+			switch {
+			case tt.ok:
+				if err != nil {
+					t.Fatalf("SubmitBlock: %v", err)
+				}
+			case err == nil:
+				t.Fatal("SubmitBlock accepted an invalid block")
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Fatalf("SubmitBlock = %v, want %v", err, tt.err)
+			case !strings.Contains(err.Error(), tt.reason):
+				t.Fatalf("SubmitBlock = %v, want %q", err, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package chain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ELOATS/btc/store"
+)
+
+//裁剪模式：删除较早的区块体和撤销数据，只保留区块头(区块索引)、UTXO集合和最近的区块
+//裁剪总是从创世块开始按高度进行，所以被裁剪的区块是一个连续的范围[0, prunedHeight)
+//裁剪目标有两种：保留最近的N个区块，或者区块体的总大小不超过预算，两者可以同时设置
+
+const (
+	pruneDepthKey   = "pruneDepth"   //保留的区块个数，0表示不限制
+	pruneSizeKey    = "pruneSize"    //区块体的大小预算(字节)，0表示不限制
+	prunedHeightKey = "prunedHeight" //第一个没有被裁剪的高度
+)
+
+//无论裁剪目标是多少，最近的minPruneKeep个区块都不会被裁剪
+const minPruneKeep = 10
+
+//解析裁剪目标：N表示保留最近的N个区块，带KB/MB/GB后缀表示区块体的大小预算，off表示关闭裁剪
+func ParsePruneTarget(target string) (depth uint64, size uint64, err error) {
+	if target == "off" || target == "0" {
+		return 0, 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		scale  uint64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}}
+
+	upper := strings.ToUpper(target)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			n, err := strconv.ParseUint(strings.TrimSuffix(upper, unit.suffix), 10, 64)
+			if err != nil || n == 0 {
+				return 0, 0, fmt.Errorf("无效的裁剪大小: %s", target)
+			}
+			return 0, n * unit.scale, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(target, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的裁剪目标: %s", target)
+	}
+	if n < minPruneKeep {
+		return 0, 0, fmt.Errorf("至少需要保留 %d 个区块", minPruneKeep)
+	}
+	return n, 0, nil
+}
+
+func getStateUint(state store.Bucket, key string) uint64 {
+	data := state.Get([]byte(key))
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+//保存裁剪目标，并立即按新的目标裁剪
+func (bc *BlockChain) SetPrune(depth, size uint64) (int, error) {
+	count := 0
+
+	err := bc.db.Update(func(btx store.Tx) error {
+		if err := chainStateBuckets(btx); err != nil {
+			return err
+		}
+
+		state := btx.Bucket([]byte(chainStateBucketName))
+		if err := state.Put([]byte(pruneDepthKey), uintToByte(depth)); err != nil {
+			return err
+		}
+		if err := state.Put([]byte(pruneSizeKey), uintToByte(size)); err != nil {
+			return err
+		}
+
+		height, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+
+		count, err = pruneBlocks(btx, height)
+		return err
+	})
+
+	return count, err
+}
+
+//第一个没有被裁剪的高度，0表示没有区块被裁剪
+func (bc *BlockChain) PrunedHeight() uint64 {
+	var height uint64
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		if state := btx.Bucket([]byte(chainStateBucketName)); state != nil {
+			height = getStateUint(state, prunedHeightKey)
+		}
+		return nil
+	})
+
+	return height
+}
+
+//按照裁剪目标删除区块体，tipHeight是链尾的高度，返回删除的区块个数
+func pruneBlocks(btx store.Tx, tipHeight uint64) (int, error) {
+	state := btx.Bucket([]byte(chainStateBucketName))
+	depth := getStateUint(state, pruneDepthKey)
+	size := getStateUint(state, pruneSizeKey)
+	if depth == 0 && size == 0 {
+		return 0, nil
+	}
+
+	total := tipHeight + 1
+	if total <= minPruneKeep {
+		return 0, nil
+	}
+
+	blocks := btx.Bucket([]byte(blockBucketName))
+	heights := btx.Bucket([]byte(heightBucketName))
+	undo := btx.Bucket([]byte(undoBucketName))
+	pruned := getStateUint(state, prunedHeightKey)
+
+	//keepFrom之前的区块都需要裁剪
+	var keepFrom uint64
+	if depth > 0 && total > depth {
+		keepFrom = total - depth
+	}
+
+	if size > 0 {
+		var used uint64
+		for h := tipHeight; h >= pruned; h-- {
+			used += uint64(len(blocks.Get(heights.Get(uintToByte(h)))))
+			if used > size {
+				keepFrom = max(keepFrom, h+1)
+				break
+			}
+			if h == 0 {
+				break
+			}
+		}
+	}
+
+	if keepFrom > total-minPruneKeep {
+		keepFrom = total - minPruneKeep
+	}
+
+	count := 0
+	for h := pruned; h < keepFrom; h++ {
+		hash := heights.Get(uintToByte(h))
+		if hash == nil {
+			return count, fmt.Errorf("高度 %d 不在区块索引中", h)
+		}
+		if err := blocks.Delete(hash); err != nil {
+			return count, err
+		}
+		//没有区块体就无法断开区块，撤销数据一起删除
+		if err := undo.Delete(hash); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if keepFrom <= pruned {
+		return 0, nil
+	}
+	return count, state.Put([]byte(prunedHeightKey), uintToByte(keepFrom))
+}
@@ -0,0 +1,468 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+)
+
+//UTXO快照(类似assumeutxo)：新节点直接载入某个高度的UTXO集合，不需要从创世块重放
+//快照文件：
+//  魔数 "BTCUTXO\x00" | 版本 | 高度 | 区块哈希 | 过滤器头 | 内容哈希
+//  区块头个数 | 从创世块到快照区块的区块头
+//  UTXO个数 | 每个UTXO: key(outpointBytes) + UTXOEntry，按key排序
+//内容哈希是"UTXO个数 + 所有UTXO"这一段的sha256，载入时必须与可信的哈希一致
+//区块头用工作量证明和链接关系校验，载入后快照之前的区块体都按照已裁剪处理
+//载入的链状态在后台从创世块重放验证之前，记录为未验证
+
+const snapshotMagic = "BTCUTXO\x00"
+const snapshotVersion = 1
+
+const (
+	snapshotHeightKey = "snapshotHeight" //未验证的快照的高度，验证通过后删除
+	snapshotHashKey   = "snapshotHash"   //未验证的快照的内容哈希
+)
+
+//可信的快照：高度 -> 内容哈希(十六进制)
+//每条链的创世块都不同，这里没有内置的值，载入时用--hash指定
+var AssumeUTXO = map[uint64]string{}
+
+var ErrBadSnapshot = errors.New("无效的UTXO快照")
+
+type SnapshotInfo struct {
+	Height       uint64
+	BlockHash    []byte
+	FilterHeader []byte //快照区块的过滤器头，快照之后的区块在它的基础上计算过滤器头
+	ContentHash  []byte
+	UTXOCount    uint64
+}
+
+//计算UTXO集合的内容哈希，UTXO必须按key的顺序加入
+type utxoHasher struct {
+	h hash.Hash
+}
+
+func newUTXOHasher(count uint64) *utxoHasher {
+	var e wire.Encoder
+	e.PutUvarint(count)
+
+	hasher := &utxoHasher{sha256.New()}
+	hasher.h.Write(e.Bytes())
+	return hasher
+}
+
+func (h *utxoHasher) add(key, value []byte) {
+	h.h.Write(encodeUTXORecord(key, value))
+}
+
+//快照文件中的一个UTXO
+func encodeUTXORecord(key, value []byte) []byte {
+	var e wire.Encoder
+	e.PutBytes(key)
+	e.PutBytes(value)
+	return e.Bytes()
+}
+
+func (h *utxoHasher) sum() []byte {
+	return h.h.Sum(nil)
+}
+
+//把链尾的UTXO集合写入w
+func (bc *BlockChain) DumpUTXOSet(w io.Writer) (*SnapshotInfo, error) {
+	info := &SnapshotInfo{BlockHash: bc.tail}
+	bw := bufio.NewWriter(w)
+
+	//旧的数据库可能还没有过滤器，需要先补齐，所以使用Update
+	err := bc.db.Update(func(btx store.Tx) error {
+		height, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+		info.Height = height
+
+		if err := indexMissingFilters(btx, bc.tail); err != nil {
+			return err
+		}
+		info.FilterHeader = btx.Bucket([]byte(filterHeaderBucketName)).Get(bc.tail)
+
+		//第一遍计算内容哈希，第二遍写出UTXO
+		utxos := btx.Bucket([]byte(utxoBucketName))
+		_ = utxos.ForEach(func(k, v []byte) error {
+			info.UTXOCount++
+			return nil
+		})
+		hasher := newUTXOHasher(info.UTXOCount)
+		_ = utxos.ForEach(func(k, v []byte) error {
+			hasher.add(k, v)
+			return nil
+		})
+		info.ContentHash = hasher.sum()
+
+		var e wire.Encoder
+		e.PutRaw([]byte(snapshotMagic))
+		e.PutUvarint(snapshotVersion)
+		e.PutUvarint(info.Height)
+		e.PutBytes(info.BlockHash)
+		e.PutBytes(info.FilterHeader)
+		e.PutBytes(info.ContentHash)
+
+		e.PutUvarint(height + 1)
+		for h := uint64(0); h <= height; h++ {
+			header, err := headerAtHeight(btx, h)
+			if err != nil {
+				return err
+			}
+			e.PutBytes(header.Serialize())
+		}
+
+		e.PutUvarint(info.UTXOCount)
+		if _, err := bw.Write(e.Bytes()); err != nil {
+			return err
+		}
+
+		return utxos.ForEach(func(k, v []byte) error {
+			_, err := bw.Write(encodeUTXORecord(k, v))
+			return err
+		})
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+//从快照创建区块链，expectedHash是可信的内容哈希，为空时使用AssumeUTXO中的值
+func LoadUTXOSnapshot(r io.Reader, expectedHash []byte) (*BlockChain, *SnapshotInfo, error) {
+	path := DBPath()
+	if IsFileExist(path) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrChainExists, path)
+	}
+
+	d := wire.NewStreamDecoder(r)
+	info, headers, err := readSnapshotHeaders(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(expectedHash) == 0 {
+		trusted, ok := AssumeUTXO[info.Height]
+		if !ok {
+			return nil, nil, fmt.Errorf("没有高度 %d 的可信快照哈希，需要指定快照的哈希", info.Height)
+		}
+		if expectedHash, err = hex.DecodeString(trusted); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !bytes.Equal(info.ContentHash, expectedHash) {
+		return nil, nil, fmt.Errorf("%w: 内容哈希 %x 与可信的哈希 %x 不一致", ErrBadSnapshot, info.ContentHash, expectedHash)
+	}
+
+	//先写到临时路径，载入完成后再改名，中途失败或者进程退出不会留下不完整的数据库
+	tmpPath := path + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return nil, nil, err
+	}
+	db, err := store.Open(Backend, tmpPath, dbOpenTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = db.Update(func(btx store.Tx) error {
+		return loadSnapshot(btx, d, info, headers)
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return nil, nil, err
+	}
+
+	if db, err = store.Open(Backend, path, dbOpenTimeout); err != nil {
+		return nil, nil, err
+	}
+
+	chainLog.Info("UTXO快照已载入", "height", info.Height, logging.Hex("tip", info.BlockHash), "utxos", info.UTXOCount)
+
+	return &BlockChain{db, info.BlockHash, newDeploymentCache()}, info, nil
+}
+
+//读取快照的元数据和区块头，区块头必须从创世块开始连续链接，并满足工作量证明
+func readSnapshotHeaders(d *wire.Decoder) (*SnapshotInfo, []*block.Block, error) {
+	if magic := d.GetRaw(len(snapshotMagic)); string(magic) != snapshotMagic {
+		return nil, nil, ErrBadSnapshot
+	}
+
+	var info SnapshotInfo
+	version := d.GetUvarint()
+	info.Height = d.GetUvarint()
+	info.BlockHash = d.GetBytes()
+	info.FilterHeader = d.GetBytes()
+	info.ContentHash = d.GetBytes()
+	count := d.GetUvarint()
+	if err := d.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if version != snapshotVersion || count != info.Height+1 || len(info.FilterHeader) != sha256.Size {
+		return nil, nil, fmt.Errorf("%w: 文件头不正确", ErrBadSnapshot)
+	}
+
+	headers := make([]*block.Block, 0, count)
+	var prevHash []byte
+	for h := uint64(0); h < count; h++ {
+		data := d.GetBytes()
+		if err := d.Err(); err != nil {
+			return nil, nil, fmt.Errorf("%w: 高度 %d 的区块头: %v", ErrBadSnapshot, h, err)
+		}
+		header, err := block.DecodeHeader(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: 高度 %d 的区块头: %v", ErrBadSnapshot, h, err)
+		}
+		if !bytes.Equal(header.PrevBlockHash, prevHash) {
+			return nil, nil, fmt.Errorf("%w: 高度 %d 的区块头没有连接到前一个区块", ErrBadSnapshot, h)
+		}
+		if err := pow.Check(header); err != nil {
+			return nil, nil, fmt.Errorf("%w: 高度 %d: %v", ErrBadSnapshot, h, err)
+		}
+		headers = append(headers, header)
+		prevHash = header.Hash
+	}
+
+	if !bytes.Equal(prevHash, info.BlockHash) {
+		return nil, nil, fmt.Errorf("%w: 区块头的链尾 %x 不是快照区块 %x", ErrBadSnapshot, prevHash, info.BlockHash)
+	}
+
+	return &info, headers, nil
+}
+
+//写入区块索引、UTXO集合和链状态，UTXO的内容哈希必须与文件头一致
+func loadSnapshot(btx store.Tx, d *wire.Decoder, info *SnapshotInfo, headers []*block.Block) error {
+	if _, err := btx.CreateBucket([]byte(blockBucketName)); err != nil {
+		return err
+	}
+	if err := chainStateBuckets(btx); err != nil {
+		return err
+	}
+	_, filterHeaders, err := filterBuckets(btx)
+	if err != nil {
+		return err
+	}
+
+	index := btx.Bucket([]byte(blockIndexBucketName))
+	heights := btx.Bucket([]byte(heightBucketName))
+	for h, header := range headers {
+		if err := index.Put(header.Hash, encodeBlockIndex(uint64(h), header)); err != nil {
+			return err
+		}
+		if err := heights.Put(uintToByte(uint64(h)), header.Hash); err != nil {
+			return err
+		}
+	}
+
+	count := d.GetUvarint()
+	hasher := newUTXOHasher(count)
+	utxos := btx.Bucket([]byte(utxoBucketName))
+	var prevKey []byte
+	for i := uint64(0); i < count; i++ {
+		key, value := d.GetBytes(), d.GetBytes()
+		if err := d.Err(); err != nil {
+			return fmt.Errorf("%w: 第 %d 个UTXO: %v", ErrBadSnapshot, i, err)
+		}
+		if len(key) <= 8 || bytes.Compare(key, prevKey) <= 0 {
+			return fmt.Errorf("%w: 第 %d 个UTXO的key无效或者没有排序", ErrBadSnapshot, i)
+		}
+		if _, err := deserializeUTXOEntry(value); err != nil {
+			return fmt.Errorf("%w: 第 %d 个UTXO: %v", ErrBadSnapshot, i, err)
+		}
+
+		hasher.add(key, value)
+		if err := utxos.Put(key, value); err != nil {
+			return err
+		}
+		prevKey = key
+	}
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if !bytes.Equal(hasher.sum(), info.ContentHash) {
+		return fmt.Errorf("%w: UTXO的内容与文件头中的哈希不一致", ErrBadSnapshot)
+	}
+	info.UTXOCount = count
+
+	//快照区块之前的区块体都没有，记为已裁剪
+	if err := putTip(btx, info.BlockHash); err != nil {
+		return err
+	}
+	if err := filterHeaders.Put(info.BlockHash, info.FilterHeader); err != nil {
+		return err
+	}
+
+	state := btx.Bucket([]byte(chainStateBucketName))
+	for key, value := range map[string][]byte{
+		utxoTipKey:        info.BlockHash,
+		prunedHeightKey:   uintToByte(info.Height + 1),
+		snapshotHeightKey: uintToByte(info.Height),
+		snapshotHashKey:   info.ContentHash,
+	} {
+		if err := state.Put([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//还没有验证的快照，没有时返回nil
+func (bc *BlockChain) UnvalidatedSnapshot() *SnapshotInfo {
+	var info *SnapshotInfo
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		state := btx.Bucket([]byte(chainStateBucketName))
+		if state == nil || state.Get([]byte(snapshotHashKey)) == nil {
+			return nil
+		}
+
+		info = &SnapshotInfo{
+			Height:      getStateUint(state, snapshotHeightKey),
+			ContentHash: append([]byte{}, state.Get([]byte(snapshotHashKey))...),
+		}
+		info.BlockHash = append([]byte{}, btx.Bucket([]byte(heightBucketName)).Get(uintToByte(info.Height))...)
+		info.FilterHeader = append([]byte{}, btx.Bucket([]byte(filterHeaderBucketName)).Get(info.BlockHash)...)
+		return nil
+	})
+
+	return info
+}
+
+//后台验证快照：从创世块开始按高度重放区块，到快照区块时比较UTXO集合的内容哈希和过滤器头
+//只读取区块索引，不修改链状态，可以和节点的其他操作同时进行
+type SnapshotValidator struct {
+	bc           *BlockChain
+	Info         *SnapshotInfo
+	next         uint64 //下一个需要的区块高度
+	prevHash     []byte
+	filterHeader []byte
+	replay       *verifyState
+}
+
+func (bc *BlockChain) NewSnapshotValidator() (*SnapshotValidator, error) {
+	info := bc.UnvalidatedSnapshot()
+	if info == nil {
+		return nil, errors.New("没有需要验证的UTXO快照")
+	}
+
+	return &SnapshotValidator{
+		bc:           bc,
+		Info:         info,
+		filterHeader: make([]byte, sha256.Size),
+		replay:       &verifyState{utxos: make(map[string]*UTXOEntry), spent: make(map[string]bool)},
+	}, nil
+}
+
+//下一个需要的区块高度
+func (v *SnapshotValidator) Next() uint64 {
+	return v.next
+}
+
+func (v *SnapshotValidator) Done() bool {
+	return v.next > v.Info.Height
+}
+
+//按顺序连接下一个区块，连接快照区块之后完成验证，验证通过时把快照记为已验证
+func (v *SnapshotValidator) Connect(b *block.Block) error {
+	if v.Done() {
+		return errors.New("快照已经验证完成")
+	}
+	height := v.next
+
+	var failure *ChainVerifyFailure
+	_ = v.bc.db.View(func(btx store.Tx) error {
+		hash := btx.Bucket([]byte(heightBucketName)).Get(uintToByte(height))
+		if !bytes.Equal(hash, b.Hash) {
+			failure = &ChainVerifyFailure{Check: "linkage", Reason: fmt.Sprintf("区块 %x 不是区块索引中高度 %d 的区块", b.Hash, height)}
+			return nil
+		}
+		failure = verifyBlock(btx, b.Serialize(), b.Hash, height, v.prevHash, false, VerifyLevelTransactions, v.replay)
+		return nil
+	})
+	if failure != nil {
+		return fmt.Errorf("%w: 高度 %d 的区块 %x 验证失败(%s): %s", ErrBadSnapshot, height, b.Hash, failure.Check, failure.Reason)
+	}
+
+	v.filterHeader = filter.NextHeader(filter.NewBlockFilter(b).Bytes(), v.filterHeader)
+	v.prevHash = b.Hash
+	v.next++
+
+	if v.Done() {
+		return v.finish()
+	}
+	return nil
+}
+
+func (v *SnapshotValidator) finish() error {
+	if hash := v.replay.utxoSetHash(); !bytes.Equal(hash, v.Info.ContentHash) {
+		return fmt.Errorf("%w: 重放得到的UTXO集合哈希 %x 与快照 %x 不一致", ErrBadSnapshot, hash, v.Info.ContentHash)
+	}
+	if !bytes.Equal(v.filterHeader, v.Info.FilterHeader) {
+		return fmt.Errorf("%w: 重放得到的过滤器头 %x 与快照 %x 不一致", ErrBadSnapshot, v.filterHeader, v.Info.FilterHeader)
+	}
+
+	err := v.bc.db.Update(func(btx store.Tx) error {
+		state := btx.Bucket([]byte(chainStateBucketName))
+		if err := state.Delete([]byte(snapshotHashKey)); err != nil {
+			return err
+		}
+		return state.Delete([]byte(snapshotHeightKey))
+	})
+	if err != nil {
+		return err
+	}
+
+	chainLog.Info("UTXO快照验证通过", "height", v.Info.Height, logging.Hex("hash", v.Info.ContentHash))
+	return nil
+}
+
+//重放得到的UTXO集合的内容哈希，与快照文件中的计算方式相同
+func (s *verifyState) utxoSetHash() []byte {
+	records := make(map[string][]byte, len(s.utxos))
+	keys := make([]string, 0, len(s.utxos))
+
+	for outpoint, entry := range s.utxos {
+		i := strings.LastIndexByte(outpoint, ':')
+		index, _ := strconv.ParseInt(outpoint[i+1:], 10, 64)
+		key := string(filter.OutpointBytes([]byte(outpoint[:i]), index))
+
+		records[key] = entry.serialize()
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hasher := newUTXOHasher(uint64(len(keys)))
+	for _, key := range keys {
+		hasher.add([]byte(key), records[key])
+	}
+	return hasher.sum()
+}
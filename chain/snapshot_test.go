@@ -0,0 +1,71 @@
+package chain
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+//LoadUTXOSnapshot在当前目录下创建数据库，测试在临时目录中运行
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+//载入失败时不能留下不完整的数据库，之后可以重新载入
+func TestLoadUTXOSnapshotFailure(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 3; i++ {
+		c.mine(t)
+	}
+
+	var dump bytes.Buffer
+	info, err := c.DumpUTXOSet(&dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//最后一个字节属于最后一个UTXO，文件头完整，失败发生在写入数据库的过程中
+	altered := append([]byte{}, dump.Bytes()...)
+	altered[len(altered)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated utxo records", dump.Bytes()[:dump.Len()-10]},
+		{"altered utxo record", altered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+
+			if _, _, err := LoadUTXOSnapshot(bytes.NewReader(tt.data), info.ContentHash); err == nil {
+				t.Fatal("LoadUTXOSnapshot accepted a damaged snapshot")
+			}
+			for _, path := range []string{DBPath(), DBPath() + ".tmp"} {
+				if IsFileExist(path) {
+					t.Fatalf("%s was left behind after a failed load", path)
+				}
+			}
+
+			bc, loaded, err := LoadUTXOSnapshot(bytes.NewReader(dump.Bytes()), info.ContentHash)
+			if err != nil {
+				t.Fatalf("LoadUTXOSnapshot after a failed load: %v", err)
+			}
+			defer bc.Close()
+			if loaded.Height != info.Height || !bytes.Equal(bc.Tip(), c.Tip()) {
+				t.Fatalf("loaded tip %x at height %d, want %x at height %d", bc.Tip(), loaded.Height, c.Tip(), info.Height)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package chain
+
+import (
+	"github.com/ELOATS/btc/internal/metrics"
+	"github.com/ELOATS/btc/store"
+)
+
+var (
+	blockValidationSeconds = metrics.NewHistogram("btc_block_validation_seconds", "提交的区块校验的耗时(秒)", metrics.LatencyBuckets)
+	blockConnectSeconds    = metrics.NewHistogram("btc_block_connect_seconds", "区块写入数据库并更新链状态的耗时(秒)", metrics.LatencyBuckets)
+)
+
+//链的统计信息，用于监控
+type ChainStats struct {
+	Height      uint64
+	TipTime     uint64  //链尾区块的时间戳
+	UTXOCount   int     //UTXO集合中的output个数
+	TotalSupply float64 //UTXO集合中所有output的金额之和
+	PoolTxs     int     //交易池中的交易个数
+	PoolBytes   int     //交易池中交易的大小之和
+	DBSize      int64   //数据库占用的空间(字节)
+}
+
+//统计链的状态，需要遍历整个UTXO集合
+func (bc *BlockChain) Stats() (*ChainStats, error) {
+	var stats ChainStats
+
+	err := bc.db.View(func(btx store.Tx) error {
+		height, header, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+		stats.Height = height
+		stats.TipTime = header.TimeStamp
+
+		if b := btx.Bucket([]byte(utxoBucketName)); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				entry, err := deserializeUTXOEntry(v)
+				if err != nil {
+					return err
+				}
+				stats.UTXOCount++
+				stats.TotalSupply += entry.Output.Value
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range loadTxPool(btx) {
+			stats.PoolTxs++
+			stats.PoolBytes += entry.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.DBSize, err = bc.db.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
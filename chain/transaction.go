@@ -0,0 +1,210 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/ELOATS/btc/address"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//创建普通交易的可选参数
+type TxOptions struct {
+	Fee         float64 //交易费，付给打包交易的矿工
+	Data        []byte  //附加数据，不为空时交易中附加一个数据output
+	Replaceable bool    //是否允许被手续费更高的交易替换(RBF)
+	Unconfirmed bool    //是否可以花费交易池中还没有打包的output
+	LockTime    uint32  //锁定时间，区块高度或者unix时间戳
+}
+
+//检查收款地址、手续费和附加数据
+func checkTxOptions(to string, opts TxOptions) error {
+	if opts.Fee < 0 {
+		return fmt.Errorf("%w: 手续费不能为负数", tx.ErrInvalidTransaction)
+	}
+
+	if len(opts.Data) > tx.MaxDataSize {
+		return fmt.Errorf("%w: 附加数据不能超过 %d 字节", tx.ErrInvalidTransaction, tx.MaxDataSize)
+	}
+
+	if !wallet.IsValidAddress(to) {
+		return fmt.Errorf("%w: %s", address.ErrInvalidAddress, to)
+	}
+	return nil
+}
+
+//input的序列号，Sequence都是SequenceFinal时锁定时间不生效
+func inputSequence(opts TxOptions) uint32 {
+	if opts.Replaceable {
+		return tx.SequenceRBF
+	}
+	if opts.LockTime != 0 {
+		return tx.SequenceLockTime
+	}
+	return tx.SequenceFinal
+}
+
+/*
+实现普通交易
+内部逻辑：
+1. 遍历账本，找到属于付款人的合适的金额，把这个outputs找到
+2. 如果找到钱不足以转账，创建交易失败
+3. 将outputs转成inputs
+4. 创建输出，创建一个属于收款人的output
+5. 如果有找零，创建属于付款人的output
+6. 设置交易id
+7. 返回交易结构
+*/
+
+func NewTransaction(from, to string, amount float64, opts TxOptions, bc *BlockChain) (*tx.Transaction, error) {
+
+	data := opts.Data
+	if err := checkTxOptions(to, opts); err != nil {
+		return nil, err
+	}
+
+	//1. 打开钱包
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		return nil, err
+	}
+	//获取秘钥对
+	keyPair := ws.WalletsMap[from]
+	if keyPair == nil {
+		return nil, fmt.Errorf("%w: %s", wallet.ErrKeyNotFound, from)
+	}
+	//2. 获取公钥
+	publicKey := keyPair.PublicKey
+
+	pubKeyHash := wallet.HashPubKey(keyPair.PublicKey)
+
+	utxoes := make(map[string][]int64) //标示能用的utxo
+	var resValue float64               //这些utxo存储的金额
+
+	//需要的金额包括手续费
+	need := amount + opts.Fee
+
+	//1. 遍历账本，找到属于付款人的合适的金额，把这个outputs找到
+	utxoes, resValue, err = bc.FindNeedUtxoes(pubKeyHash, need, opts.Unconfirmed)
+	if err != nil {
+		return nil, err
+	}
+
+	//2. 如果找到钱不足以转账，创建交易失败
+	if resValue < need {
+		return nil, fmt.Errorf("%w: %s 需要 %f，可用 %f", ErrInsufficientFunds, from, need, resValue)
+	}
+
+	var inputs []tx.TXInput
+	var outputs []tx.TXOutput
+
+	sequence := inputSequence(opts)
+
+	//3. 将outputs转成inputs
+	for txid, indexes := range utxoes {
+		for _, i /*0,1*/ := range indexes {
+			input := tx.TXInput{TXID: []byte(txid), Index: i, PubKey: publicKey, Sequence: sequence}
+			inputs = append(inputs, input)
+		}
+	}
+
+	//4. 创建输出，创建一个属于收款人的output
+	//output := TXOutput{amount, to}
+	output := tx.NewTXOutput(amount,to)
+	outputs = append(outputs, output)
+
+	//5. 如果有找零，创建属于付款人的output，剩下的就是手续费
+	if resValue > need {
+		//output2 := TXOutput{resValue - amount, from}
+		output2 := tx.NewTXOutput(resValue-need,from)
+		outputs = append(outputs, output2)
+	}
+
+	//附加数据output
+	if len(data) != 0 {
+		outputs = append(outputs, tx.NewDataOutput(data))
+	}
+
+	//创建交易
+	newTx := tx.Transaction{Version: tx.TxVersion, TXInputs: inputs, TXOutputs: outputs, LockTime: opts.LockTime}
+
+	if err := bc.SignTransaction(&newTx,keyPair); err != nil {
+		return nil, err
+	}
+
+	//6. 设置交易id，交易id包含签名，所以要在签名之后计算
+	newTx.SetTXID()
+
+	//7. 返回交易结构
+	return &newTx, nil
+}
+
+//从钱包中所有地址的output中凑出金额，每个input用它的地址对应的秘钥签名
+//钱包必须已经同步到链尾，找零转给第一个被选中的output的地址
+//opts.Unconfirmed为true时可以花费交易池中转给钱包的output
+func NewWalletTransaction(ws *wallet.Wallets, to string, amount float64, opts TxOptions, bc *BlockChain) (*tx.Transaction, error) {
+	if err := checkTxOptions(to, opts); err != nil {
+		return nil, err
+	}
+
+	var minConf uint64 = 1
+	if opts.Unconfirmed {
+		minConf = 0
+	}
+	utxos, err := bc.ListWalletUnspent(ws, minConf)
+	if err != nil {
+		return nil, err
+	}
+
+	need := amount + opts.Fee
+
+	//确认数多的output优先
+	var selected []WalletUTXO
+	var resValue float64
+	for _, utxo := range utxos {
+		if resValue >= need {
+			break
+		}
+		selected = append(selected, utxo)
+		resValue += utxo.Output.Value
+	}
+
+	if resValue < need {
+		return nil, fmt.Errorf("%w: 钱包需要 %f，可用 %f", ErrInsufficientFunds, need, resValue)
+	}
+
+	sequence := inputSequence(opts)
+
+	var inputs []tx.TXInput
+	signers := make(map[string]*wallet.WalletKeyPair)
+
+	for _, utxo := range selected {
+		keyPair := ws.WalletsMap[utxo.Address]
+		if keyPair == nil {
+			return nil, fmt.Errorf("%w: %s", wallet.ErrKeyNotFound, utxo.Address)
+		}
+		signers[utxo.Address] = keyPair
+
+		inputs = append(inputs, tx.TXInput{TXID: utxo.TXID, Index: utxo.Index, PubKey: keyPair.PublicKey, Sequence: sequence})
+	}
+
+	outputs := []tx.TXOutput{tx.NewTXOutput(amount, to)}
+	if resValue > need {
+		outputs = append(outputs, tx.NewTXOutput(resValue-need, selected[0].Address))
+	}
+	if len(opts.Data) != 0 {
+		outputs = append(outputs, tx.NewDataOutput(opts.Data))
+	}
+
+	newTx := tx.Transaction{Version: tx.TxVersion, TXInputs: inputs, TXOutputs: outputs, LockTime: opts.LockTime}
+
+	//每个签名者只签名自己的input
+	for _, keyPair := range signers {
+		if err := bc.SignTransaction(&newTx, keyPair); err != nil {
+			return nil, err
+		}
+	}
+
+	newTx.SetTXID()
+	return &newTx, nil
+}
@@ -0,0 +1,564 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//交易池：交易创建之后先放在这里，等待矿工打包
+//交易池保存在区块链数据库的txPoolBucket中，key是交易id，value是交易池条目的编码
+
+const txPoolBucketName = "txPoolBucket"
+
+const (
+	//最低的手续费率(每字节)，低于这个费率的交易不进入交易池
+	minRelayFeeRate = 0.00001
+	//替换交易时，新交易除了支付被替换交易的手续费，还要为自己的大小额外支付的费率
+	incrementalRelayFeeRate = 0.00001
+	//一次替换最多能踢出的交易数(包括后代交易)
+	maxReplacementEvictions = 100
+)
+
+var (
+	errTxInPool       = errors.New("交易已经在交易池中")
+	errMissingInputs  = errors.New("交易引用的output不存在或者已经被花费")
+	errFeeTooLow      = errors.New("手续费太低")
+	errDuplicateInput = errors.New("交易多次花费同一个output")
+)
+
+//交易池中的一条记录
+type TxPoolEntry struct {
+	Tx   *tx.Transaction
+	Fee  float64 //手续费，inputs的金额减去outputs的金额
+	Time uint64  //进入交易池的时间
+}
+
+func (e *TxPoolEntry) Size() int {
+	return e.Tx.Size()
+}
+
+//在区块中占用的大小，区块中除了交易的编码还单独保存了交易id
+func (e *TxPoolEntry) blockSize() int {
+	return e.Size() + binary.MaxVarintLen64 + len(e.Tx.TXid)
+}
+
+//手续费率，每字节的手续费
+func (e *TxPoolEntry) FeeRate() float64 {
+	return e.Fee / float64(e.Size())
+}
+
+func (e *TxPoolEntry) serialize() []byte {
+	var enc wire.Encoder
+	enc.PutBytes(e.Tx.Serialize())
+	enc.PutFloat64(e.Fee)
+	enc.PutUint64(e.Time)
+	return enc.Bytes()
+}
+
+//txid是交易池中的key，版本0的交易解码时没有id，从key恢复
+func deserializePoolEntry(txid []byte, data []byte) (*TxPoolEntry, error) {
+	d := wire.NewDecoder(data)
+	txData := d.GetBytes()
+	fee := d.GetFloat64()
+	addTime := d.GetUint64()
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+
+	t, err := tx.DeserializeTransaction(txData)
+	if err != nil {
+		return nil, err
+	}
+	if t.Version == 0 {
+		t.TXid = append([]byte{}, txid...)
+	}
+	if err := t.CheckTXID(); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(t.TXid, txid) {
+		return nil, fmt.Errorf("交易池中 %x 的交易id是 %x", txid, t.TXid)
+	}
+
+	return &TxPoolEntry{Tx: t, Fee: fee, Time: addTime}, nil
+}
+
+//output的定位：交易id + 索引
+func outpointKey(txid []byte, index int64) string {
+	return fmt.Sprintf("%s:%d", txid, index)
+}
+
+//读取交易池中的所有交易，key是交易id
+func loadTxPool(tx store.Tx) map[string]*TxPoolEntry {
+	pool := make(map[string]*TxPoolEntry)
+
+	b := tx.Bucket([]byte(txPoolBucketName))
+	if b == nil {
+		return pool
+	}
+
+	_ = b.ForEach(func(k, v []byte) error {
+		entry, err := deserializePoolEntry(k, v)
+		if err != nil {
+			txLog.Warn("交易池中的交易解码失败，忽略", logging.Hex("txid", k), "err", err)
+			return nil
+		}
+		pool[string(k)] = entry
+		return nil
+	})
+
+	return pool
+}
+
+func (bc *BlockChain) PoolEntries() map[string]*TxPoolEntry {
+	var pool map[string]*TxPoolEntry
+
+	_ = bc.db.View(func(tx store.Tx) error {
+		pool = loadTxPool(tx)
+		return nil
+	})
+
+	return pool
+}
+
+//交易池中所有被花费的output，value是花费它的交易id
+func poolSpends(pool map[string]*TxPoolEntry) map[string]string {
+	spends := make(map[string]string)
+
+	for txid, entry := range pool {
+		for _, input := range entry.Tx.TXInputs {
+			spends[outpointKey(input.TXID, input.Index)] = txid
+		}
+	}
+
+	return spends
+}
+
+//找到交易池中txid的所有后代交易(直接或间接花费它的output的交易)
+func poolDescendants(pool map[string]*TxPoolEntry, txid string) map[string]bool {
+	descendants := make(map[string]bool)
+	queue := []string{txid}
+
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for id, entry := range pool {
+			if descendants[id] {
+				continue
+			}
+			for _, input := range entry.Tx.TXInputs {
+				if string(input.TXID) == parent {
+					descendants[id] = true
+					queue = append(queue, id)
+					break
+				}
+			}
+		}
+	}
+
+	return descendants
+}
+
+//找到交易池中txid的所有祖先交易(它直接或间接花费的、还没有打包的交易)
+func PoolAncestors(pool map[string]*TxPoolEntry, txid string) map[string]bool {
+	ancestors := make(map[string]bool)
+	queue := []string{txid}
+
+	for len(queue) > 0 {
+		entry := pool[queue[0]]
+		queue = queue[1:]
+
+		if entry == nil {
+			continue
+		}
+
+		for _, input := range entry.Tx.TXInputs {
+			parent := string(input.TXID)
+			if _, ok := pool[parent]; ok && !ancestors[parent] {
+				ancestors[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return ancestors
+}
+
+//将交易加入交易池
+//1. 检查交易已经到了锁定时间
+//2. 检查引用的output在UTXO集合中(没有被花费)，或者是交易池中交易的output
+//3. 计算手续费，检查最低费率
+//4. 校验签名
+//5. 与交易池中的交易冲突时，按照RBF规则决定是否替换
+func (bc *BlockChain) AcceptToPool(t *tx.Transaction) error {
+	if t.IsCoinbase() {
+		return errors.New("挖矿交易不能进入交易池")
+	}
+
+	if err := t.CheckDataOutputs(); err != nil {
+		return err
+	}
+
+	pool := bc.PoolEntries()
+	txid := string(t.TXid)
+	if _, ok := pool[txid]; ok {
+		return errTxInPool
+	}
+
+	//交易池中的交易都可以打包进下一个区块
+	if !t.IsFinal(bc.Height()+1, bc.MedianTimePast()) {
+		return fmt.Errorf("%w: %d", ErrNonFinal, t.LockTime)
+	}
+
+	spends := poolSpends(pool)
+
+	var inValue, outValue float64
+	conflicts := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for _, input := range t.TXInputs {
+		key := outpointKey(input.TXID, input.Index)
+		if seen[key] {
+			return fmt.Errorf("%w: %x:%d", errDuplicateInput, input.TXID, input.Index)
+		}
+		seen[key] = true
+
+		var output tx.TXOutput
+		if entry, err := bc.GetUTXO(input.TXID, input.Index); err == nil {
+			output = entry.Output
+		} else if parent, ok := pool[string(input.TXID)]; ok && input.Index >= 0 && input.Index < int64(len(parent.Tx.TXOutputs)) {
+			output = parent.Tx.TXOutputs[input.Index]
+		} else {
+			return fmt.Errorf("%w: %x:%d", errMissingInputs, input.TXID, input.Index)
+		}
+		inValue += output.Value
+
+		//被交易池中其他交易花费了，记录冲突
+		if spender, ok := spends[key]; ok {
+			conflicts[spender] = true
+		}
+	}
+
+	if err := t.CheckOutputValues(); err != nil {
+		return err
+	}
+	for _, output := range t.TXOutputs {
+		outValue += output.Value
+	}
+
+	fee := inValue - outValue
+	entry := &TxPoolEntry{Tx: t, Fee: fee, Time: uint64(time.Now().Unix())}
+
+	if fee < minRelayFeeRate*float64(entry.Size()) {
+		return fmt.Errorf("%w: %f, 最低需要 %f", errFeeTooLow, fee, minRelayFeeRate*float64(entry.Size()))
+	}
+
+	if err := t.Verify(bc.findPrevTXs(t)); err != nil {
+		return err
+	}
+
+	evicted, err := checkReplacement(pool, entry, conflicts)
+	if err != nil {
+		return err
+	}
+
+	return bc.db.Update(func(btx store.Tx) error {
+		b, err := btx.CreateBucketIfNotExists([]byte(txPoolBucketName))
+		if err != nil {
+			return err
+		}
+
+		for id := range evicted {
+			txLog.Info("交易被替换，移出交易池", logging.Hex("txid", []byte(id)))
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		return b.Put(t.TXid, entry.serialize())
+	})
+}
+
+//RBF规则，参考BIP125：
+//1. 被替换的交易必须声明了可以被替换
+//2. 新交易不能引用原来的交易没有引用的、未打包的output
+//3. 新交易的手续费率必须高于每一个被替换的交易
+//4. 新交易的手续费必须不低于所有被踢出交易(包括后代)的手续费之和，并且额外为自己的大小支付incrementalRelayFeeRate
+//返回需要踢出的交易
+func checkReplacement(pool map[string]*TxPoolEntry, entry *TxPoolEntry, conflicts map[string]bool) (map[string]bool, error) {
+	evicted := make(map[string]bool)
+	if len(conflicts) == 0 {
+		return evicted, nil
+	}
+
+	originalParents := make(map[string]bool)
+
+	for id := range conflicts {
+		original := pool[id]
+
+		if !original.Tx.IsReplaceable() {
+			return nil, fmt.Errorf("与交易池中的交易 %x 冲突，并且它不允许被替换", id)
+		}
+
+		if entry.FeeRate() <= original.FeeRate() {
+			return nil, fmt.Errorf("%w: 费率 %f 不高于被替换交易的费率 %f", errFeeTooLow, entry.FeeRate(), original.FeeRate())
+		}
+
+		evicted[id] = true
+		for d := range poolDescendants(pool, id) {
+			evicted[d] = true
+		}
+
+		for _, input := range original.Tx.TXInputs {
+			originalParents[string(input.TXID)] = true
+		}
+	}
+
+	if len(evicted) > maxReplacementEvictions {
+		return nil, fmt.Errorf("替换需要踢出 %d 个交易，超过上限 %d", len(evicted), maxReplacementEvictions)
+	}
+
+	for _, input := range entry.Tx.TXInputs {
+		parent := string(input.TXID)
+		if _, unconfirmed := pool[parent]; unconfirmed && !originalParents[parent] {
+			return nil, fmt.Errorf("替换交易不能引用新的未打包交易 %x", parent)
+		}
+		if evicted[parent] {
+			return nil, fmt.Errorf("替换交易不能花费被它替换的交易 %x", parent)
+		}
+	}
+
+	var evictedFees float64
+	for id := range evicted {
+		evictedFees += pool[id].Fee
+	}
+
+	minFee := evictedFees + incrementalRelayFeeRate*float64(entry.Size())
+	if entry.Fee < minFee {
+		return nil, fmt.Errorf("%w: 手续费 %f，替换至少需要 %f", errFeeTooLow, entry.Fee, minFee)
+	}
+
+	return evicted, nil
+}
+
+//区块打包之后，从交易池中删除区块中的交易，以及与区块中交易冲突的交易(和它们的后代)
+func removeBlockFromPool(btx store.Tx, block *block.Block) error {
+	b := btx.Bucket([]byte(txPoolBucketName))
+	if b == nil {
+		return nil
+	}
+
+	pool := loadTxPool(btx)
+	spends := poolSpends(pool)
+	removed := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		removed[string(tx.TXid)] = true
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, input := range tx.TXInputs {
+			spender, ok := spends[outpointKey(input.TXID, input.Index)]
+			if !ok || spender == string(tx.TXid) {
+				continue
+			}
+
+			removed[spender] = true
+			for d := range poolDescendants(pool, spender) {
+				removed[d] = true
+			}
+		}
+	}
+
+	for id := range removed {
+		if _, ok := pool[id]; !ok {
+			continue
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//按照"交易加上未打包祖先"的整体费率从高到低选择交易，祖先总是排在后代前面
+//这样一个手续费很高的子交易可以把低手续费的父交易一起带进区块(CPFP)
+//选中交易在区块中占用的大小之和不超过maxSize，放不下的包跳过，继续尝试费率更低的包
+func SelectPoolTransactions(pool map[string]*TxPoolEntry, maxSize int) []*TxPoolEntry {
+	var selected []*TxPoolEntry
+	done := make(map[string]bool)
+	skipped := make(map[string]bool)
+	used := 0
+
+	//包的费率：交易和它还没有被选中的祖先一起计算
+	packageRate := func(id string) float64 {
+		fee := pool[id].Fee
+		size := pool[id].Size()
+		for a := range PoolAncestors(pool, id) {
+			if !done[a] {
+				fee += pool[a].Fee
+				size += pool[a].Size()
+			}
+		}
+		return fee / float64(size)
+	}
+
+	for {
+		//遍历顺序固定，费率相同时结果可以复现
+		ids := make([]string, 0, len(pool))
+		for id := range pool {
+			if !done[id] && !skipped[id] {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		sort.Strings(ids)
+
+		var best string
+		bestRate := -1.0
+		for _, id := range ids {
+			if rate := packageRate(id); rate > bestRate {
+				best, bestRate = id, rate
+			}
+		}
+
+		//把祖先按依赖顺序放在前面
+		order := packageOrder(pool, best, done)
+
+		size := 0
+		for _, id := range order {
+			size += pool[id].blockSize()
+		}
+		//后代的包包含了这个包，同样放不下，不需要单独标记
+		if used+size > maxSize {
+			skipped[best] = true
+			continue
+		}
+		used += size
+
+		for _, id := range order {
+			done[id] = true
+			selected = append(selected, pool[id])
+		}
+	}
+
+	return selected
+}
+
+//返回txid和它未被选中的祖先，父交易在前
+func packageOrder(pool map[string]*TxPoolEntry, txid string, done map[string]bool) []string {
+	var order []string
+	visited := make(map[string]bool)
+
+	var visit func(id string)
+	visit = func(id string) {
+		if visited[id] || done[id] {
+			return
+		}
+		visited[id] = true
+
+		for _, input := range pool[id].Tx.TXInputs {
+			if _, ok := pool[string(input.TXID)]; ok {
+				visit(string(input.TXID))
+			}
+		}
+		order = append(order, id)
+	}
+
+	visit(txid)
+	return order
+}
+
+//提高交易池中交易的手续费(RBF)
+//使用原来的inputs，从找零中扣除增加的手续费，重新签名后替换原来的交易
+//feeRate为0时，使用原交易的费率加上incrementalRelayFeeRate
+func (bc *BlockChain) BumpFee(txid []byte, feeRate float64) (*tx.Transaction, error) {
+	pool := bc.PoolEntries()
+
+	entry, ok := pool[string(txid)]
+	if !ok {
+		return nil, fmt.Errorf("交易 %x 不在交易池中", txid)
+	}
+
+	if !entry.Tx.IsReplaceable() {
+		return nil, fmt.Errorf("交易 %x 没有声明可以被替换(RBF)", txid)
+	}
+
+	//找到付款人的钱包，普通交易的所有input都属于同一个付款人
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		return nil, err
+	}
+	var payer *wallet.WalletKeyPair
+	for _, w := range ws.WalletsMap {
+		if len(entry.Tx.TXInputs) > 0 && string(w.PublicKey) == string(entry.Tx.TXInputs[0].PubKey) {
+			payer = w
+			break
+		}
+	}
+	if payer == nil {
+		return nil, fmt.Errorf("%w: 交易 %x 的付款人", wallet.ErrKeyNotFound, txid)
+	}
+
+	//找零是付给付款人自己的output，取最后一个
+	myPubKeyHash := wallet.HashPubKey(payer.PublicKey)
+	change := -1
+	for i, output := range entry.Tx.TXOutputs {
+		if string(output.PubKeyHash) == string(myPubKeyHash) {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, errors.New("交易没有找零，无法提高手续费")
+	}
+
+	size := float64(entry.Size())
+	if feeRate <= 0 {
+		feeRate = entry.FeeRate() + incrementalRelayFeeRate
+	}
+
+	//新的手续费要同时满足新费率，以及比原来的手续费多出incrementalRelayFeeRate*size
+	newFee := feeRate * size
+	if min := entry.Fee + incrementalRelayFeeRate*size; newFee < min {
+		newFee = min
+	}
+	delta := newFee - entry.Fee
+
+	newTx := tx.Transaction{Version: entry.Tx.Version, LockTime: entry.Tx.LockTime}
+	for _, input := range entry.Tx.TXInputs {
+		newTx.TXInputs = append(newTx.TXInputs, tx.TXInput{TXID: input.TXID, Index: input.Index, PubKey: input.PubKey, Sequence: input.Sequence})
+	}
+	newTx.TXOutputs = append(newTx.TXOutputs, entry.Tx.TXOutputs...)
+
+	if newTx.TXOutputs[change].Value <= delta {
+		return nil, fmt.Errorf("找零 %f 不足以支付增加的手续费 %f", newTx.TXOutputs[change].Value, delta)
+	}
+	newTx.TXOutputs[change].Value -= delta
+
+	if err := bc.SignTransaction(&newTx, payer); err != nil {
+		return nil, err
+	}
+	newTx.SetTXID()
+
+	if err := bc.AcceptToPool(&newTx); err != nil {
+		return nil, err
+	}
+
+	return &newTx, nil
+}
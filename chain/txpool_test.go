@@ -0,0 +1,248 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ELOATS/btc/tx"
+)
+
+func TestAcceptToPool(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(c *testChain, funds *tx.Transaction) *tx.Transaction
+		err   error //nil表示接受
+	}{
+		{"valid", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(12))
+		}, nil},
+		{"NaN output", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(math.NaN()))
+		}, tx.ErrInvalidTransaction},
+		{"negative output", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(20), c.pay(-8))
+		}, tx.ErrInvalidTransaction},
+		{"infinite output", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(math.Inf(1)))
+		}, tx.ErrInvalidTransaction},
+		//同一个output花费两次，input的金额会被算两遍
+		{"duplicate input", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0), outpoint(funds, 0)}, c.pay(24))
+		}, errDuplicateInput},
+		{"missing input", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(12))
+			spend.TXInputs[0].Index = 1
+			spend.SetTXID()
+			return spend
+		}, errMissingInputs},
+		{"no fee", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			return c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(tx.Reward))
+		}, errFeeTooLow},
+		{"bad signature", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(12))
+			spend.TXOutputs[0].Value = 11
+			spend.SetTXID()
+			return spend
+		}, tx.ErrInvalidTransaction},
+		{"non-final", func(c *testChain, funds *tx.Transaction) *tx.Transaction {
+			spend := &tx.Transaction{Version: tx.TxVersion, TXOutputs: []tx.TXOutput{c.pay(12)}, LockTime: 100}
+			spend.TXInputs = []tx.TXInput{{TXID: funds.TXid, Index: 0, PubKey: c.key.PubKey(), Sequence: tx.SequenceLockTime}}
+			if err := c.SignTransaction(spend, c.key); err != nil {
+				t.Fatal(err)
+			}
+			spend.SetTXID()
+			return spend
+		}, ErrNonFinal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+			funds := c.mine(t).Transactions[0]
+
+			t1 := tt.build(c, funds)
+			err := c.AcceptToPool(t1)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("AcceptToPool: %v", err)
+				}
+				if err := c.AcceptToPool(t1); !errors.Is(err, errTxInPool) {
+					t.Fatalf("AcceptToPool again = %v, want errTxInPool", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("AcceptToPool = %v, want %v", err, tt.err)
+			}
+			if len(c.PoolEntries()) != 0 {
+				t.Fatal("rejected transaction is in the pool")
+			}
+		})
+	}
+
+	c := newTestChain(t)
+	if err := c.AcceptToPool(tx.NewCoinbaseTx(c.addr, "")); err == nil {
+		t.Fatal("AcceptToPool accepted a coinbase transaction")
+	}
+}
+
+//RBF：原交易声明可替换，新交易花费相同的output并且支付更高的手续费
+func TestReplaceByFee(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence uint32  //原交易的Sequence
+		fee      float64 //替换交易的手续费，原交易是0.5
+		child    bool    //原交易在交易池中有子交易，手续费0.5
+		ok       bool
+	}{
+		{"higher fee", tx.SequenceRBF, 1, false, true},
+		{"same fee", tx.SequenceRBF, 0.5, false, false},
+		{"lower fee", tx.SequenceRBF, 0.1, false, false},
+		{"not replaceable", tx.SequenceFinal, 2, false, false},
+		//要为被踢出的子交易一起付手续费
+		{"evicts child", tx.SequenceRBF, 1.5, true, true},
+		{"does not pay for child", tx.SequenceRBF, 0.9, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+			funds := c.mine(t).Transactions[0]
+
+			original := c.spend(t, tt.sequence, []tx.TXInput{outpoint(funds, 0)}, c.pay(12))
+			if err := c.AcceptToPool(original); err != nil {
+				t.Fatal(err)
+			}
+			var child *tx.Transaction
+			if tt.child {
+				child = c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(original, 0)}, c.pay(11.5))
+				if err := c.AcceptToPool(child); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replacement := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(tx.Reward-tt.fee))
+			err := c.AcceptToPool(replacement)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("replacement accepted")
+				}
+				if _, ok := c.PoolEntries()[string(original.TXid)]; !ok {
+					t.Fatal("original was evicted by a rejected replacement")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("AcceptToPool replacement: %v", err)
+			}
+			pool := c.PoolEntries()
+			if len(pool) != 1 || pool[string(replacement.TXid)] == nil {
+				t.Fatalf("pool has %d entries after replacement", len(pool))
+			}
+		})
+	}
+}
+
+//CPFP：低费率的父交易和高费率的子交易作为一个包按整体费率选择，父交易在前
+func TestSelectPoolTransactions(t *testing.T) {
+	c := newTestChain(t)
+	first := c.mine(t).Transactions[0]
+	second := c.mine(t).Transactions[0]
+
+	accept := func(t1 *tx.Transaction) *tx.Transaction {
+		if err := c.AcceptToPool(t1); err != nil {
+			t.Fatal(err)
+		}
+		return t1
+	}
+	//子交易签名时要从交易池中找到父交易
+	parent := accept(c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(first, 0)}, c.pay(12.49)))
+	child := accept(c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(parent, 0)}, c.pay(11.49)))
+	other := accept(c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(second, 0)}, c.pay(12.3)))
+
+	pool := c.PoolEntries()
+	size := func(txs ...*tx.Transaction) int {
+		total := 0
+		for _, t1 := range txs {
+			total += pool[string(t1.TXid)].blockSize()
+		}
+		return total
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int
+		want    []*tx.Transaction
+	}{
+		//父交易单独的费率低于other，但父子一起的费率更高
+		{"unlimited", MaxBlockSize, []*tx.Transaction{parent, child, other}},
+		{"package fits", size(parent, child), []*tx.Transaction{parent, child}},
+		//父子放不下时跳过，继续选择费率更低的other
+		{"package too large", size(parent, child) - 1, []*tx.Transaction{other}},
+		{"nothing fits", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := SelectPoolTransactions(pool, tt.maxSize)
+			if len(selected) != len(tt.want) {
+				t.Fatalf("selected %d transactions, want %d", len(selected), len(tt.want))
+			}
+			for i, entry := range selected {
+				if string(entry.Tx.TXid) != string(tt.want[i].TXid) {
+					t.Fatalf("transaction %d is %x, want %x", i, entry.Tx.TXid, tt.want[i].TXid)
+				}
+			}
+		})
+	}
+
+	//挖出的区块包含整个包
+	b := c.mine(t, parent, child, other)
+	if len(b.Transactions) != 4 || len(c.PoolEntries()) != 0 {
+		t.Fatalf("block has %d transactions, pool has %d", len(b.Transactions), len(c.PoolEntries()))
+	}
+}
+
+//迁移过来的版本0交易的id是旧的gob哈希，从交易池读回时用key恢复
+func TestPoolEntryLegacyTXID(t *testing.T) {
+	legacy := &tx.Transaction{
+		TXInputs:  []tx.TXInput{{TXID: []byte("prev"), Index: 0, Signature: []byte("sig"), PubKey: []byte("pub")}},
+		TXOutputs: []tx.TXOutput{{Value: 1, PubKeyHash: make([]byte, 20)}},
+	}
+	txid, err := legacy.LegacyTXID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.TXid = txid
+	data := (&TxPoolEntry{Tx: legacy, Fee: 0.5, Time: 1}).serialize()
+
+	tests := []struct {
+		name string
+		key  []byte
+		ok   bool
+	}{
+		{"pool key", txid, true},
+		{"wrong key", []byte("other"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := deserializePoolEntry(tt.key, data)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("deserializePoolEntry accepted a key that is not the transaction id")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("deserializePoolEntry: %v", err)
+			}
+			if !bytes.Equal(entry.Tx.TXid, txid) {
+				t.Fatalf("TXid = %x, want %x", entry.Tx.TXid, txid)
+			}
+		})
+	}
+}
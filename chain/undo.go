@@ -0,0 +1,233 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/store"
+)
+
+//撤销数据：区块中所有input花费的UTXO(金额、锁定脚本、所在高度、是否来自挖矿交易)
+//按照区块中交易和input的顺序保存，和区块在同一个数据库事务中写入
+//断开区块时倒序处理：删除区块创建的output，恢复被花费的UTXO
+
+const invalidBlockBucketName = "invalidBlockBucket" //被管理员标记为无效的区块
+
+var errMissingUndo = errors.New("区块没有撤销数据")
+
+func encodeUndo(spent []*UTXOEntry) []byte {
+	var e wire.Encoder
+	e.PutUvarint(uint64(len(spent)))
+	for _, entry := range spent {
+		entry.encode(&e)
+	}
+	return e.Bytes()
+}
+
+func decodeUndo(data []byte) ([]*UTXOEntry, error) {
+	d := wire.NewDecoder(data)
+	n := d.GetCount()
+
+	var spent []*UTXOEntry
+	for i := 0; i < n; i++ {
+		var entry UTXOEntry
+		entry.decode(d)
+		spent = append(spent, &entry)
+	}
+
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+//断开链尾区块，恢复链状态，返回被断开的区块
+//区块体和区块索引保留在数据库中，只是不再属于主链
+func (bc *BlockChain) DisconnectTip() (*block.Block, error) {
+	var block *block.Block
+
+	err := bc.db.Update(func(btx store.Tx) error {
+		var err error
+		block, err = disconnectBlock(btx, bc.tail)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bc.tail = block.PrevBlockHash
+	return block, nil
+}
+
+func disconnectBlock(btx store.Tx, hash []byte) (*block.Block, error) {
+	state := btx.Bucket([]byte(chainStateBucketName))
+	if state == nil || !bytes.Equal(state.Get([]byte(utxoTipKey)), hash) {
+		return nil, fmt.Errorf("区块 %x 不是链尾", hash)
+	}
+
+	height, _, err := blockIndex(btx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := getBlock(btx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.PrevBlockHash) == 0 {
+		return nil, errors.New("不能断开创世块")
+	}
+
+	undoData := btx.Bucket([]byte(undoBucketName)).Get(hash)
+	if undoData == nil {
+		return nil, fmt.Errorf("%w: %x", errMissingUndo, hash)
+	}
+	spent, err := decodeUndo(undoData)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos := btx.Bucket([]byte(utxoBucketName))
+
+	//倒序处理，同一个区块中后面的交易可能花费了前面交易的output
+	for t := len(b.Transactions) - 1; t >= 0; t-- {
+		tx := b.Transactions[t]
+
+		for i, output := range tx.TXOutputs {
+			if output.IsDataOutput() {
+				continue
+			}
+			key := filter.OutpointBytes(tx.TXid, int64(i))
+			if utxos.Get(key) == nil {
+				return nil, fmt.Errorf("UTXO集合不一致，output %x:%d 不存在", tx.TXid, i)
+			}
+			if err := utxos.Delete(key); err != nil {
+				return nil, err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for i := len(tx.TXInputs) - 1; i >= 0; i-- {
+			input := tx.TXInputs[i]
+			if len(spent) == 0 {
+				return nil, fmt.Errorf("区块 %x 的撤销数据不完整", hash)
+			}
+			entry := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+
+			if err := utxos.Put(filter.OutpointBytes(input.TXID, input.Index), entry.serialize()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(spent) != 0 {
+		return nil, fmt.Errorf("区块 %x 的撤销数据与区块不匹配", hash)
+	}
+
+	if err := disconnectAddrIndex(btx, b, height); err != nil {
+		return nil, err
+	}
+
+	if err := btx.Bucket([]byte(undoBucketName)).Delete(hash); err != nil {
+		return nil, err
+	}
+	if err := btx.Bucket([]byte(heightBucketName)).Delete(uintToByte(height)); err != nil {
+		return nil, err
+	}
+	if err := state.Put([]byte(utxoTipKey), b.PrevBlockHash); err != nil {
+		return nil, err
+	}
+	if err := putTip(btx, b.PrevBlockHash); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+//把区块标记为无效，并断开它以及之后的所有区块，返回被断开的区块(从链尾开始)
+//被标记的区块不会再被SubmitBlock接受
+func (bc *BlockChain) InvalidateBlock(hash []byte) ([]*block.Block, error) {
+	var disconnected []*block.Block
+
+	err := bc.db.Update(func(btx store.Tx) error {
+		height, _, err := blockIndex(btx, hash)
+		if err != nil {
+			return err
+		}
+		if onChain := btx.Bucket([]byte(heightBucketName)).Get(uintToByte(height)); !bytes.Equal(onChain, hash) {
+			return fmt.Errorf("%w: %x", ErrUnknownBlock, hash)
+		}
+
+		invalid, err := btx.CreateBucketIfNotExists([]byte(invalidBlockBucketName))
+		if err != nil {
+			return err
+		}
+		if err := invalid.Put(hash, []byte{1}); err != nil {
+			return err
+		}
+
+		//整个回滚在一个事务中，中途失败时什么都不会改变
+		tail := bc.tail
+		for {
+			block, err := disconnectBlock(btx, tail)
+			if err != nil {
+				return err
+			}
+			disconnected = append(disconnected, block)
+			tail = block.PrevBlockHash
+
+			if bytes.Equal(block.Hash, hash) {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bc.tail = disconnected[len(disconnected)-1].PrevBlockHash
+	return disconnected, nil
+}
+
+func (bc *BlockChain) IsInvalidBlock(hash []byte) bool {
+	invalid := false
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		if b := btx.Bucket([]byte(invalidBlockBucketName)); b != nil {
+			invalid = b.Get(hash) != nil
+		}
+		return nil
+	})
+
+	return invalid
+}
+
+//被断开区块中的普通交易重新放回交易池，从最早的区块开始，保证父交易先进入
+//不满足交易池规则的交易(比如没有手续费)会被丢弃，返回放回的交易个数
+func (bc *BlockChain) ResurrectTransactions(disconnected []*block.Block) int {
+	count := 0
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			if err := bc.AcceptToPool(tx); err != nil {
+				txLog.Info("交易没有放回交易池", logging.Hex("txid", tx.TXid), "err", err)
+				continue
+			}
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+)
+
+//UTXO集合的副本，用来比较断开区块前后的链状态
+func utxoSnapshot(t *testing.T, c *testChain) map[string]string {
+	t.Helper()
+
+	snapshot := make(map[string]string)
+	err := c.db.View(func(btx store.Tx) error {
+		return btx.Bucket([]byte(utxoBucketName)).ForEach(func(k, v []byte) error {
+			snapshot[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+//挖n个区块，每个区块花费前一个区块的挖矿奖励，并且在同一个区块中花费刚创建的output
+//before在每个区块挖出之前调用
+func mineSpendingBlocks(t *testing.T, c *testChain, n int, before func()) []*block.Block {
+	t.Helper()
+
+	var blocks []*block.Block
+	funds := c.mine(t).Transactions[0]
+	for i := 0; i < n; i++ {
+		spend := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(funds, 0)}, c.pay(12), c.pay(0.4))
+		if err := c.AcceptToPool(spend); err != nil {
+			t.Fatal(err)
+		}
+		chained := c.spend(t, tx.SequenceFinal, []tx.TXInput{outpoint(spend, 1)}, c.pay(0.3))
+		if err := c.AcceptToPool(chained); err != nil {
+			t.Fatal(err)
+		}
+
+		before()
+		b := c.mine(t, spend, chained)
+		blocks = append(blocks, b)
+		funds = b.Transactions[0]
+	}
+	return blocks
+}
+
+func TestDisconnectTip(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth int //断开的区块个数
+	}{
+		{"one block", 1},
+		{"two blocks", 2},
+		{"all spending blocks", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+
+			var snapshots []map[string]string
+			var tips [][]byte
+			var heights []uint64
+			blocks := mineSpendingBlocks(t, c, 3, func() {
+				snapshots = append(snapshots, utxoSnapshot(t, c))
+				tips = append(tips, c.Tip())
+				heights = append(heights, c.Height())
+			})
+
+			for i := 0; i < tt.depth; i++ {
+				disconnected, err := c.DisconnectTip()
+				if err != nil {
+					t.Fatalf("DisconnectTip: %v", err)
+				}
+				if want := blocks[len(blocks)-1-i]; !bytes.Equal(disconnected.Hash, want.Hash) {
+					t.Fatalf("disconnected %x, want %x", disconnected.Hash, want.Hash)
+				}
+			}
+
+			//链状态和断开的区块被连接之前完全一样
+			k := len(blocks) - tt.depth
+			if !bytes.Equal(c.Tip(), tips[k]) || c.Height() != heights[k] {
+				t.Fatalf("tip = %x at height %d, want %x at height %d", c.Tip(), c.Height(), tips[k], heights[k])
+			}
+			if got := utxoSnapshot(t, c); !reflect.DeepEqual(got, snapshots[k]) {
+				t.Fatalf("UTXO set differs: %d entries, want %d", len(got), len(snapshots[k]))
+			}
+			if report := c.VerifyChain(4); report.Failure != nil {
+				t.Fatalf("VerifyChain: %+v", report.Failure)
+			}
+
+			//断开的区块可以重新连接
+			for i := k; i < len(blocks); i++ {
+				if err := c.SubmitBlock(blocks[i]); err != nil {
+					t.Fatalf("SubmitBlock %d: %v", i, err)
+				}
+			}
+			if !bytes.Equal(c.Tip(), blocks[len(blocks)-1].Hash) {
+				t.Fatal("reconnected chain has a different tip")
+			}
+		})
+	}
+}
+
+func TestDisconnectGenesis(t *testing.T) {
+	c := newTestChain(t)
+	if _, err := c.DisconnectTip(); err == nil {
+		t.Fatal("DisconnectTip disconnected the genesis block")
+	}
+}
+
+func TestInvalidateBlock(t *testing.T) {
+	c := newTestChain(t)
+	blocks := mineSpendingBlocks(t, c, 2, func() {})
+
+	//断开第一个花费区块以及之后的区块，回到挖出资金的区块
+	disconnected, err := c.InvalidateBlock(blocks[0].Hash)
+	if err != nil {
+		t.Fatalf("InvalidateBlock: %v", err)
+	}
+	if len(disconnected) != 2 || !bytes.Equal(disconnected[0].Hash, blocks[1].Hash) {
+		t.Fatalf("InvalidateBlock disconnected %d blocks", len(disconnected))
+	}
+	if !bytes.Equal(c.Tip(), blocks[0].PrevBlockHash) {
+		t.Fatalf("tip = %x, want %x", c.Tip(), blocks[0].PrevBlockHash)
+	}
+	if !c.IsInvalidBlock(blocks[0].Hash) {
+		t.Fatal("block is not marked invalid")
+	}
+
+	//第一个区块的交易回到交易池，第二个区块的交易花费的挖矿奖励已经不存在
+	if n := c.ResurrectTransactions(disconnected); n != 2 {
+		t.Fatalf("ResurrectTransactions = %d, want 2", n)
+	}
+	if len(c.PoolEntries()) != 2 {
+		t.Fatalf("pool has %d entries, want 2", len(c.PoolEntries()))
+	}
+
+	if err := c.SubmitBlock(blocks[0]); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("SubmitBlock invalidated block = %v, want ErrInvalidBlock", err)
+	}
+	if _, err := c.InvalidateBlock([]byte("missing")); err == nil {
+		t.Fatal("InvalidateBlock accepted an unknown block")
+	}
+}
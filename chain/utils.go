@@ -0,0 +1,24 @@
+package chain
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+//这是一个工具函数文件
+
+//大端编码，定长8字节，bolt中按key排序时与数字的大小顺序一致
+func uintToByte(num uint64) []byte {
+	var buffer [8]byte
+	binary.BigEndian.PutUint64(buffer[:], num)
+
+	return buffer[:]
+}
+
+func IsFileExist(fileName string) bool {
+	_,err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,334 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+)
+
+//从创世块开始验证整条链，用于崩溃之后检查数据库文件
+//验证级别，每一级包含前面所有级别的检查：
+//0. 区块头的链接(PrevBlockHash)和区块索引
+//1. 工作量证明和时间戳(MTP和超前时间)
+//2. 区块结构、交易id和梅克尔根
+//3. 重放所有交易：锁定时间、签名、双花和金额规则
+//4. 重放得到的UTXO集合和数据库中的UTXO集合一致
+
+const (
+	VerifyLevelHeaders = iota
+	VerifyLevelPoW
+	VerifyLevelBlocks
+	VerifyLevelTransactions
+	VerifyLevelUTXOSet
+)
+
+const DefaultVerifyLevel = VerifyLevelTransactions
+
+//找到第一个不一致的UTXO后停止遍历
+var errStopIteration = errors.New("停止遍历")
+
+//第一个失败的检查
+type ChainVerifyFailure struct {
+	Height uint64
+	Hash   []byte
+	TXID   []byte //与交易无关的检查为空
+	Check  string //检查项: linkage, pow, timestamp, structure, txid, merkle, locktime, signature, double-spend, missing-input, value, coinbase, utxo-set
+	Reason string
+}
+
+type ChainVerifyReport struct {
+	Level        int
+	Checked      uint64 //已经验证的区块个数
+	TipHeight    uint64
+	PrunedHeight uint64 //[0, PrunedHeight)的区块体已被裁剪，只验证了区块头
+	Skipped      string //因为裁剪而没有执行的检查
+	Failure      *ChainVerifyFailure
+}
+
+//验证过程中重放的UTXO集合
+type verifyState struct {
+	utxos map[string]*UTXOEntry
+	spent map[string]bool
+}
+
+func (bc *BlockChain) VerifyChain(level int) *ChainVerifyReport {
+	report := &ChainVerifyReport{Level: level}
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		heights := btx.Bucket([]byte(heightBucketName))
+		blocks := btx.Bucket([]byte(blockBucketName))
+		if heights == nil {
+			report.Failure = &ChainVerifyFailure{Check: "linkage", Reason: "没有区块索引"}
+			return nil
+		}
+
+		tipHeight, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			report.Failure = &ChainVerifyFailure{Hash: bc.tail, Check: "linkage", Reason: err.Error()}
+			return nil
+		}
+		report.TipHeight = tipHeight
+
+		if state := btx.Bucket([]byte(chainStateBucketName)); state != nil {
+			report.PrunedHeight = getStateUint(state, prunedHeightKey)
+		}
+
+		//从创世块开始重放交易，被裁剪的区块无法重放
+		var replay *verifyState
+		if level >= VerifyLevelTransactions {
+			if report.PrunedHeight > 0 {
+				report.Skipped = "区块体已被裁剪，无法从创世块重放交易，没有验证签名、双花、金额和UTXO集合"
+			} else {
+				replay = &verifyState{utxos: make(map[string]*UTXOEntry), spent: make(map[string]bool)}
+			}
+		}
+
+		var prevHash []byte
+		for height := uint64(0); height <= tipHeight; height++ {
+			hash := heights.Get(uintToByte(height))
+			if hash == nil {
+				report.Failure = &ChainVerifyFailure{Height: height, Check: "linkage", Reason: "高度不在区块索引中"}
+				return nil
+			}
+
+			pruned := height < report.PrunedHeight
+			failure := verifyBlock(btx, blocks.Get(hash), hash, height, prevHash, pruned, level, replay)
+			if failure != nil {
+				failure.Height = height
+				failure.Hash = append([]byte{}, hash...)
+				report.Failure = failure
+				return nil
+			}
+
+			prevHash = hash
+			report.Checked++
+		}
+
+		if !bytes.Equal(prevHash, bc.tail) {
+			report.Failure = &ChainVerifyFailure{Height: tipHeight, Hash: bc.tail, Check: "linkage", Reason: "链尾与区块索引不一致"}
+			return nil
+		}
+
+		if replay != nil && level >= VerifyLevelUTXOSet {
+			if failure := replay.compareUTXOSet(btx); failure != nil {
+				failure.Height = tipHeight
+				failure.Hash = bc.tail
+				report.Failure = failure
+			}
+		}
+
+		return nil
+	})
+
+	return report
+}
+
+//验证一个区块，pruned表示区块在裁剪高度之前，区块体可以不存在
+func verifyBlock(btx store.Tx, body []byte, hash []byte, height uint64, prevHash []byte, pruned bool, level int, replay *verifyState) *ChainVerifyFailure {
+	_, header, err := blockIndex(btx, hash)
+	if err != nil {
+		return &ChainVerifyFailure{Check: "linkage", Reason: err.Error()}
+	}
+
+	//0. 链接
+	if !bytes.Equal(header.Hash, hash) {
+		return &ChainVerifyFailure{Check: "linkage", Reason: fmt.Sprintf("区块索引中的区块头哈希为 %x", header.Hash)}
+	}
+	if !bytes.Equal(header.PrevBlockHash, prevHash) {
+		return &ChainVerifyFailure{Check: "linkage", Reason: fmt.Sprintf("前区块哈希为 %x，应该是 %x", header.PrevBlockHash, prevHash)}
+	}
+	if level < VerifyLevelPoW {
+		return nil
+	}
+
+	//1. 工作量证明和时间戳
+	if err := pow.Check(header); err != nil {
+		return &ChainVerifyFailure{Check: "pow", Reason: err.Error()}
+	}
+	if err := checkBlockTime(btx, header); err != nil {
+		return &ChainVerifyFailure{Check: "timestamp", Reason: err.Error()}
+	}
+	if level < VerifyLevelBlocks {
+		return nil
+	}
+
+	//2. 区块结构，没有被裁剪的区块体缺失说明数据库已经损坏
+	if body == nil {
+		if pruned {
+			return nil
+		}
+		return &ChainVerifyFailure{Check: "structure", Reason: "区块体缺失"}
+	}
+	b, err := block.Deserialize(body)
+	if err != nil {
+		return &ChainVerifyFailure{Check: "structure", Reason: "区块解码失败: " + err.Error()}
+	}
+	if failure := verifyBlockStructure(b, header); failure != nil {
+		return failure
+	}
+	if replay == nil {
+		return nil
+	}
+
+	//3. 重放交易
+	if err := checkBlockFinality(btx, b, height); err != nil {
+		return &ChainVerifyFailure{Check: "locktime", Reason: err.Error()}
+	}
+	return replay.connect(b, height)
+}
+
+func verifyBlockStructure(b *block.Block, header *block.Block) *ChainVerifyFailure {
+	if !bytes.Equal(b.Header().Serialize(), header.Serialize()) {
+		return &ChainVerifyFailure{Check: "structure", Reason: "区块体与区块索引中的区块头不一致"}
+	}
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return &ChainVerifyFailure{Check: "structure", Reason: "区块的第一个交易必须是挖矿交易"}
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range b.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return &ChainVerifyFailure{TXID: tx.TXid, Check: "structure", Reason: "区块中只能有一个挖矿交易"}
+		}
+		//迁移过来的版本0交易的id是旧的gob哈希，CheckTXID按旧的方法计算
+		if err := tx.CheckTXID(); err != nil {
+			return &ChainVerifyFailure{TXID: tx.TXid, Check: "txid", Reason: err.Error()}
+		}
+		if seen[string(tx.TXid)] {
+			return &ChainVerifyFailure{TXID: tx.TXid, Check: "structure", Reason: "区块中有重复的交易"}
+		}
+		seen[string(tx.TXid)] = true
+	}
+
+	expected := block.Block{Version: b.Version, Transactions: b.Transactions}
+	expected.HashTransactions()
+	if !bytes.Equal(b.MerkleRoot, expected.MerkleRoot) {
+		return &ChainVerifyFailure{Check: "merkle", Reason: fmt.Sprintf("梅克尔根为 %x，应该是 %x", b.MerkleRoot, expected.MerkleRoot)}
+	}
+
+	if size := len(b.Serialize()); size > MaxBlockSize {
+		return &ChainVerifyFailure{Check: "structure", Reason: fmt.Sprintf("区块大小 %d 超过上限 %d", size, MaxBlockSize)}
+	}
+
+	return nil
+}
+
+//按顺序重放区块中的交易，检查签名、双花和金额，并更新UTXO集合
+func (s *verifyState) connect(block *block.Block, height uint64) *ChainVerifyFailure {
+	var fees float64
+
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() {
+			if err := t.CheckDataOutputs(); err != nil {
+				return &ChainVerifyFailure{TXID: t.TXid, Check: "structure", Reason: err.Error()}
+			}
+			if err := t.CheckOutputValues(); err != nil {
+				return &ChainVerifyFailure{TXID: t.TXid, Check: "value", Reason: err.Error()}
+			}
+			s.addOutputs(t, height)
+			continue
+		}
+
+		var inValue, outValue float64
+		prevTXs := make(map[string]tx.Transaction)
+
+		for i, input := range t.TXInputs {
+			key := outpointKey(input.TXID, input.Index)
+			entry, ok := s.utxos[key]
+			if !ok {
+				if s.spent[key] {
+					return &ChainVerifyFailure{TXID: t.TXid, Check: "double-spend", Reason: fmt.Sprintf("input %d 引用的output %x:%d 已经被花费", i, input.TXID, input.Index)}
+				}
+				return &ChainVerifyFailure{TXID: t.TXid, Check: "missing-input", Reason: fmt.Sprintf("input %d 引用的output %x:%d 不存在", i, input.TXID, input.Index)}
+			}
+			inValue += entry.Output.Value
+
+			prevTX := prevTXs[string(input.TXID)]
+			prevTX.TXid = input.TXID
+			for int64(len(prevTX.TXOutputs)) <= input.Index {
+				prevTX.TXOutputs = append(prevTX.TXOutputs, tx.TXOutput{})
+			}
+			prevTX.TXOutputs[input.Index] = entry.Output
+			prevTXs[string(input.TXID)] = prevTX
+
+			delete(s.utxos, key)
+			s.spent[key] = true
+		}
+
+		if err := t.Verify(prevTXs); err != nil {
+			return &ChainVerifyFailure{TXID: t.TXid, Check: "signature", Reason: err.Error()}
+		}
+
+		if err := t.CheckOutputValues(); err != nil {
+			return &ChainVerifyFailure{TXID: t.TXid, Check: "value", Reason: err.Error()}
+		}
+		for _, output := range t.TXOutputs {
+			outValue += output.Value
+		}
+		if inValue < outValue {
+			return &ChainVerifyFailure{TXID: t.TXid, Check: "value", Reason: fmt.Sprintf("output金额 %f 超过了input金额 %f", outValue, inValue)}
+		}
+		fees += inValue - outValue
+
+		s.addOutputs(t, height)
+	}
+
+	coinbase := block.Transactions[0]
+	var coinbaseValue float64
+	for _, output := range coinbase.TXOutputs {
+		coinbaseValue += output.Value
+	}
+	if coinbaseValue > tx.Reward+fees+1e-9 {
+		return &ChainVerifyFailure{TXID: coinbase.TXid, Check: "coinbase", Reason: fmt.Sprintf("挖矿交易的金额 %f 超过了奖励加手续费 %f", coinbaseValue, tx.Reward+fees)}
+	}
+
+	return nil
+}
+
+func (s *verifyState) addOutputs(tx *tx.Transaction, height uint64) {
+	for i, output := range tx.TXOutputs {
+		if output.IsDataOutput() {
+			continue
+		}
+		s.utxos[outpointKey(tx.TXid, int64(i))] = &UTXOEntry{Output: output, Height: height, Coinbase: tx.IsCoinbase()}
+	}
+}
+
+//重放得到的UTXO集合与数据库中的比较
+func (s *verifyState) compareUTXOSet(btx store.Tx) *ChainVerifyFailure {
+	b := btx.Bucket([]byte(utxoBucketName))
+	if b == nil {
+		return &ChainVerifyFailure{Check: "utxo-set", Reason: "数据库中没有UTXO集合"}
+	}
+
+	count := 0
+	var failure *ChainVerifyFailure
+	_ = b.ForEach(func(k, v []byte) error {
+		count++
+
+		txid, index := splitOutpoint(k)
+		expected, ok := s.utxos[outpointKey(txid, index)]
+		if !ok {
+			failure = &ChainVerifyFailure{TXID: txid, Check: "utxo-set", Reason: fmt.Sprintf("数据库中多出了output %x:%d", txid, index)}
+			return errStopIteration
+		}
+
+		entry, err := deserializeUTXOEntry(v)
+		if err != nil || !bytes.Equal(entry.serialize(), expected.serialize()) {
+			failure = &ChainVerifyFailure{TXID: txid, Check: "utxo-set", Reason: fmt.Sprintf("数据库中的output %x:%d 与重放结果不一致", txid, index)}
+			return errStopIteration
+		}
+		return nil
+	})
+
+	if failure == nil && count != len(s.utxos) {
+		failure = &ChainVerifyFailure{Check: "utxo-set", Reason: fmt.Sprintf("数据库中有 %d 个UTXO，重放得到 %d 个", count, len(s.utxos))}
+	}
+	return failure
+}
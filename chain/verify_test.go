@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/ELOATS/btc/store"
+)
+
+//删除区块体的数据库损坏，以及裁剪之后正常缺失的区块体
+func TestVerifyChainMissingBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		delete  uint64 //删除区块体的高度
+		pruned  uint64 //记录的裁剪高度，0表示没有裁剪
+		level   int
+		failure string //空表示验证通过
+	}{
+		{"intact", 0, 0, VerifyLevelUTXOSet, ""},
+		{"missing body", 2, 0, VerifyLevelBlocks, "区块体缺失"},
+		{"missing body with replay", 2, 0, VerifyLevelTransactions, "区块体缺失"},
+		{"missing body at pruned height", 2, 2, VerifyLevelBlocks, "区块体缺失"},
+		{"pruned body", 2, 3, VerifyLevelBlocks, ""},
+		//只检查区块头时不读区块体
+		{"missing body at header level", 2, 0, VerifyLevelPoW, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t)
+			for i := 0; i < 3; i++ {
+				c.mine(t)
+			}
+
+			err := c.db.Update(func(btx store.Tx) error {
+				if tt.delete > 0 {
+					hash := btx.Bucket([]byte(heightBucketName)).Get(uintToByte(tt.delete))
+					if err := btx.Bucket([]byte(blockBucketName)).Delete(hash); err != nil {
+						return err
+					}
+				}
+				if tt.pruned > 0 {
+					return btx.Bucket([]byte(chainStateBucketName)).Put([]byte(prunedHeightKey), uintToByte(tt.pruned))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := c.VerifyChain(tt.level)
+			if tt.failure == "" {
+				if report.Failure != nil {
+					t.Fatalf("VerifyChain: %+v", report.Failure)
+				}
+				if report.Checked != 4 {
+					t.Fatalf("checked %d blocks, want 4", report.Checked)
+				}
+				return
+			}
+
+			failure := report.Failure
+			if failure == nil {
+				t.Fatal("VerifyChain passed a chain with a missing block body")
+			}
+			if failure.Check != "structure" || failure.Reason != tt.failure || failure.Height != tt.delete {
+				t.Fatalf("failure = %+v, want structure %q at height %d", failure, tt.failure, tt.delete)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package chain
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+)
+
+//版本位软分叉部署，参考BIP9
+//区块版本的高3位固定为001，低29位中每一位可以分配给一个部署，矿工置位表示支持
+//链按照versionBitsPeriod个区块划分周期，每个部署在周期边界上按区块头计算状态：
+//DEFINED -> STARTED: 周期末区块的MTP达到StartTime
+//STARTED -> FAILED: 周期末区块的MTP达到Timeout，先于锁定检查
+//STARTED -> LOCKED_IN: 没有超时，并且周期内置位的区块数达到versionBitsThreshold
+//LOCKED_IN -> ACTIVE: 锁定之后的下一个周期，新规则从这里开始生效
+//状态只取决于区块头(版本和时间戳)，区块体被裁剪之后也可以计算
+
+const (
+	versionBitsTopBits = 0x20000000
+	versionBitsTopMask = 0xe0000000
+	versionBitsNumBits = 29
+
+	versionBitsPeriod    = 32 //一个周期的区块个数
+	versionBitsThreshold = 24 //锁定需要的置位区块个数(75%)
+)
+
+//新创建区块使用的版本，版本2开始检查时间戳，见mediantime.go
+//现在的区块使用版本位格式，低位由矿工为软分叉部署置位
+const BlockVersion = versionBitsTopBits
+
+type DeploymentState int
+
+const (
+	DeploymentDefined DeploymentState = iota
+	DeploymentStarted
+	DeploymentLockedIn
+	DeploymentActive
+	DeploymentFailed
+)
+
+func (s DeploymentState) String() string {
+	switch s {
+	case DeploymentDefined:
+		return "DEFINED"
+	case DeploymentStarted:
+		return "STARTED"
+	case DeploymentLockedIn:
+		return "LOCKED_IN"
+	case DeploymentActive:
+		return "ACTIVE"
+	case DeploymentFailed:
+		return "FAILED"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(s))
+}
+
+type Deployment struct {
+	Name      string
+	Bit       uint8
+	StartTime uint64 //MTP达到这个时间后开始统计信号
+	Timeout   uint64 //MTP达到这个时间还没有锁定则部署失败
+}
+
+//已知的部署，新的共识规则通过deploymentActive判断是否生效
+var deployments = []Deployment{
+	//testdummy只用来检验信号和状态的计算，没有对应的规则
+	{Name: "testdummy", Bit: 28, StartTime: 0, Timeout: math.MaxUint64},
+}
+
+//矿工置位的部署，nil表示所有已知的部署，可以用--signal=NAME[,NAME]|none修改
+var SignalDeployments map[string]bool
+
+func findDeployment(name string) (*Deployment, error) {
+	for i := range deployments {
+		if deployments[i].Name == name {
+			return &deployments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("未知的部署: %s", name)
+}
+
+//解析--signal选项
+func ParseSignalDeployments(value string) (map[string]bool, error) {
+	signal := make(map[string]bool)
+	if value == "none" {
+		return signal, nil
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		if _, err := findDeployment(name); err != nil {
+			return nil, err
+		}
+		signal[name] = true
+	}
+	return signal, nil
+}
+
+//是否是版本位格式的区块版本
+func isVersionBitsBlock(version uint64) bool {
+	return version&^uint64(1<<versionBitsNumBits-1) == versionBitsTopBits
+}
+
+func (d *Deployment) mask() uint64 {
+	return 1 << d.Bit
+}
+
+//区块是否为部署发出了信号
+func (d *Deployment) signaled(version uint64) bool {
+	return isVersionBitsBlock(version) && version&d.mask() != 0
+}
+
+//主链上指定高度的区块头
+func headerAtHeight(btx store.Tx, height uint64) (*block.Block, error) {
+	hash := btx.Bucket([]byte(heightBucketName)).Get(uintToByte(height))
+	if hash == nil {
+		return nil, fmt.Errorf("高度 %d 不在主链上", height)
+	}
+	_, header, err := blockIndex(btx, hash)
+	return header, err
+}
+
+//部署状态的缓存：周期末区块的哈希 -> 这个区块之后的周期中的状态
+//状态只取决于这个区块以及之前的区块头，所以按哈希缓存的结果在链重组之后仍然有效
+type deploymentCache struct {
+	mu     sync.Mutex
+	states map[string]DeploymentState //部署名称 + 区块哈希
+}
+
+func newDeploymentCache() *deploymentCache {
+	return &deploymentCache{states: make(map[string]DeploymentState)}
+}
+
+//cache为nil时不缓存
+func (c *deploymentCache) get(d *Deployment, hash []byte) (DeploymentState, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.states[d.Name+string(hash)]
+	return state, ok
+}
+
+func (c *deploymentCache) put(d *Deployment, hash []byte, state DeploymentState) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states[d.Name+string(hash)] = state
+}
+
+//部署在prevHash之后的下一个区块上的状态，prevHash必须在主链上
+//从最近的周期末区块往前找到有缓存的周期，只计算之后的周期，算出的状态写入cache
+func deploymentState(btx store.Tx, cache *deploymentCache, prevHash []byte, d *Deployment) (DeploymentState, error) {
+	if len(prevHash) == 0 {
+		return DeploymentDefined, nil
+	}
+
+	prevHeight, _, err := blockIndex(btx, prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	//状态只在周期边界上变化，找到上一个周期的最后一个区块
+	if (prevHeight+1)%versionBitsPeriod != 0 {
+		if prevHeight+1 < versionBitsPeriod {
+			return DeploymentDefined, nil
+		}
+		prevHeight -= (prevHeight + 1) % versionBitsPeriod
+	}
+
+	//从新到旧需要计算的周期末区块
+	type boundary struct {
+		height uint64
+		hash   []byte
+		mtp    uint64
+	}
+	var boundaries []boundary
+	state := DeploymentDefined
+	for height := int64(prevHeight); height >= 0; height -= versionBitsPeriod {
+		header, err := headerAtHeight(btx, uint64(height))
+		if err != nil {
+			return 0, err
+		}
+		if cached, ok := cache.get(d, header.Hash); ok {
+			state = cached
+			break
+		}
+		mtp, err := medianTimePast(btx, header.Hash)
+		if err != nil {
+			return 0, err
+		}
+		//开始时间之前的周期都是DEFINED
+		if mtp < d.StartTime {
+			break
+		}
+		boundaries = append(boundaries, boundary{uint64(height), header.Hash, mtp})
+	}
+
+	for i := len(boundaries) - 1; i >= 0; i-- {
+		height, mtp := boundaries[i].height, boundaries[i].mtp
+
+		switch state {
+		case DeploymentDefined:
+			if mtp >= d.Timeout {
+				state = DeploymentFailed
+			} else if mtp >= d.StartTime {
+				state = DeploymentStarted
+			}
+		case DeploymentStarted:
+			//和BIP9一样先检查超时，超时的周期即使达到阈值也不会锁定
+			if mtp >= d.Timeout {
+				state = DeploymentFailed
+				break
+			}
+			count, err := countSignals(btx, d, height)
+			if err != nil {
+				return 0, err
+			}
+			if count >= versionBitsThreshold {
+				state = DeploymentLockedIn
+			}
+		case DeploymentLockedIn:
+			state = DeploymentActive
+		}
+
+		cache.put(d, boundaries[i].hash, state)
+	}
+
+	return state, nil
+}
+
+//以height结尾的周期中为部署发出信号的区块个数
+func countSignals(btx store.Tx, d *Deployment, height uint64) (int, error) {
+	count := 0
+	for h := height + 1 - versionBitsPeriod; h <= height; h++ {
+		header, err := headerAtHeight(btx, h)
+		if err != nil {
+			return 0, err
+		}
+		if d.signaled(header.Version) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//部署的规则在prevHash之后的下一个区块上是否生效
+func deploymentActive(btx store.Tx, cache *deploymentCache, prevHash []byte, name string) (bool, error) {
+	d, err := findDeployment(name)
+	if err != nil {
+		return false, err
+	}
+	state, err := deploymentState(btx, cache, prevHash, d)
+	return state == DeploymentActive, err
+}
+
+func (bc *BlockChain) IsDeploymentActive(name string) bool {
+	active := false
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		var err error
+		active, err = deploymentActive(btx, bc.deployments, bc.tail, name)
+		return err
+	})
+
+	return active
+}
+
+//下一个区块的版本：为处于STARTED和LOCKED_IN状态、并且选择支持的部署置位
+func (bc *BlockChain) nextBlockVersion() uint64 {
+	version := uint64(BlockVersion)
+
+	_ = bc.db.View(func(btx store.Tx) error {
+		for i := range deployments {
+			d := &deployments[i]
+			if SignalDeployments != nil && !SignalDeployments[d.Name] {
+				continue
+			}
+
+			state, err := deploymentState(btx, bc.deployments, bc.tail, d)
+			if err != nil {
+				return err
+			}
+			if state == DeploymentStarted || state == DeploymentLockedIn {
+				version |= d.mask()
+			}
+		}
+		return nil
+	})
+
+	return version
+}
+
+type DeploymentInfo struct {
+	Name      string `json:"name"`
+	Bit       uint8  `json:"bit"`
+	StartTime uint64 `json:"startTime"`
+	Timeout   uint64 `json:"timeout"`
+	State     string `json:"state"` //下一个区块上的状态
+	Signaling bool   `json:"signaling"`
+
+	//STARTED状态下当前周期的统计
+	PeriodStart uint64 `json:"periodStart"`
+	Elapsed     int    `json:"elapsed"` //当前周期已经过去的区块个数
+	Count       int    `json:"count"`   //其中置位的区块个数
+	Possible    bool   `json:"possible"`
+}
+
+type DeploymentInfoResult struct {
+	Height      uint64           `json:"height"`
+	Hash        string           `json:"hash"`
+	Period      int              `json:"period"`
+	Threshold   int              `json:"threshold"`
+	Deployments []DeploymentInfo `json:"deployments"`
+}
+
+func (bc *BlockChain) GetDeploymentInfo() (*DeploymentInfoResult, error) {
+	result := &DeploymentInfoResult{Hash: fmt.Sprintf("%x", bc.tail), Period: versionBitsPeriod, Threshold: versionBitsThreshold}
+	version := bc.nextBlockVersion()
+
+	err := bc.db.View(func(btx store.Tx) error {
+		height, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+		result.Height = height
+
+		//下一个区块所在周期的第一个高度
+		periodStart := (height + 1) - (height+1)%versionBitsPeriod
+
+		for i := range deployments {
+			d := &deployments[i]
+			state, err := deploymentState(btx, bc.deployments, bc.tail, d)
+			if err != nil {
+				return err
+			}
+
+			info := DeploymentInfo{
+				Name:      d.Name,
+				Bit:       d.Bit,
+				StartTime: d.StartTime,
+				Timeout:   d.Timeout,
+				State:     state.String(),
+				Signaling: d.signaled(version),
+			}
+
+			if state == DeploymentStarted {
+				info.PeriodStart = periodStart
+				for h := periodStart; h <= height; h++ {
+					header, err := headerAtHeight(btx, h)
+					if err != nil {
+						return err
+					}
+					info.Elapsed++
+					if d.signaled(header.Version) {
+						info.Count++
+					}
+				}
+				info.Possible = info.Count+versionBitsPeriod-info.Elapsed >= versionBitsThreshold
+			}
+
+			result.Deployments = append(result.Deployments, info)
+		}
+		return nil
+	})
+
+	return result, err
+}
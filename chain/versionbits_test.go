@@ -0,0 +1,159 @@
+package chain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+)
+
+//把区块头直接写入区块索引和高度索引，高度h的时间戳是1000+h
+//signals[i]是第i个周期中前signals[i]个区块使用signal版本，之后再追加extra个区块
+//返回最后一个区块的哈希
+func writeHeaders(t *testing.T, db store.Store, signals []int, extra int, signal uint64) []byte {
+	t.Helper()
+
+	var versions []uint64
+	for _, n := range signals {
+		for i := 0; i < versionBitsPeriod; i++ {
+			version := uint64(BlockVersion)
+			if i < n {
+				version = signal
+			}
+			versions = append(versions, version)
+		}
+	}
+	for i := 0; i < extra; i++ {
+		versions = append(versions, BlockVersion)
+	}
+
+	var prev []byte
+	err := db.Update(func(btx store.Tx) error {
+		index, err := btx.CreateBucketIfNotExists([]byte(blockIndexBucketName))
+		if err != nil {
+			return err
+		}
+		heights, err := btx.CreateBucketIfNotExists([]byte(heightBucketName))
+		if err != nil {
+			return err
+		}
+
+		for height, version := range versions {
+			hash := []byte(fmt.Sprintf("header %d", height))
+			header := &block.Block{Version: version, Hash: hash, PrevBlockHash: prev, TimeStamp: 1000 + uint64(height)}
+			if err := index.Put(hash, encodeBlockIndex(uint64(height), header)); err != nil {
+				return err
+			}
+			if err := heights.Put(uintToByte(uint64(height)), hash); err != nil {
+				return err
+			}
+			prev = hash
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prev
+}
+
+func TestDeploymentState(t *testing.T) {
+	d := &Deployment{Name: "test", Bit: 1, StartTime: 0, Timeout: 1 << 40}
+	signal := uint64(BlockVersion) | d.mask()
+
+	//周期末区块的MTP是它之前第5个区块的时间戳：第一个周期1026，第二个周期1058
+	tests := []struct {
+		name    string
+		start   uint64
+		timeout uint64
+		signals []int
+		extra   int
+		version uint64 //置位区块的版本，0表示signal
+		want    DeploymentState
+	}{
+		{"no blocks", 0, d.Timeout, nil, 0, 0, DeploymentDefined},
+		{"first period incomplete", 0, d.Timeout, nil, versionBitsPeriod - 1, 0, DeploymentDefined},
+		{"before start time", 2000, d.Timeout, []int{32, 32}, 0, 0, DeploymentDefined},
+		//开始之前的周期中的信号不算
+		{"started", 0, d.Timeout, []int{32}, 0, 0, DeploymentStarted},
+		{"start time reached in second period", 1050, d.Timeout, []int{32, 32}, 0, 0, DeploymentStarted},
+		{"threshold missed", 0, d.Timeout, []int{0, versionBitsThreshold - 1}, 0, 0, DeploymentStarted},
+		{"locked in", 0, d.Timeout, []int{0, versionBitsThreshold}, 0, 0, DeploymentLockedIn},
+		{"locked in mid period", 0, d.Timeout, []int{0, versionBitsThreshold}, 10, 0, DeploymentLockedIn},
+		{"active", 0, d.Timeout, []int{0, versionBitsThreshold, 0}, 0, 0, DeploymentActive},
+		{"stays active", 0, d.Timeout, []int{0, 32, 0, 0}, 5, 0, DeploymentActive},
+		//超时先于锁定检查
+		{"timeout at threshold period", 0, 1058, []int{0, 32}, 0, 0, DeploymentFailed},
+		{"timeout before start", 0, 0, []int{32}, 0, 0, DeploymentFailed},
+		{"stays failed", 0, 1058, []int{0, 0, 32, 32}, 0, 0, DeploymentFailed},
+		{"other bit", 0, d.Timeout, []int{0, 32}, 0, BlockVersion | 1<<2, DeploymentStarted},
+		//高3位不是001的版本不是版本位格式
+		{"not a version bits block", 0, d.Timeout, []int{0, 32}, 0, d.mask() | 2, DeploymentStarted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := store.OpenMemory()
+			version := signal
+			if tt.version != 0 {
+				version = tt.version
+			}
+			tip := writeHeaders(t, db, tt.signals, tt.extra, version)
+
+			deployment := &Deployment{Name: d.Name, Bit: d.Bit, StartTime: tt.start, Timeout: tt.timeout}
+			state := func(cache *deploymentCache, prevHash []byte) DeploymentState {
+				var state DeploymentState
+				err := db.View(func(btx store.Tx) error {
+					var err error
+					state, err = deploymentState(btx, cache, prevHash, deployment)
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return state
+			}
+
+			if got := state(nil, tip); got != tt.want {
+				t.Fatalf("state = %s, want %s", got, tt.want)
+			}
+
+			//按周期依次计算，后面的周期从缓存的状态继续，结果和不用缓存时一样
+			cache := newDeploymentCache()
+			for height := versionBitsPeriod - 1; height < versionBitsPeriod*len(tt.signals); height += versionBitsPeriod {
+				state(cache, []byte(fmt.Sprintf("header %d", height)))
+			}
+			for i := 0; i < 2; i++ {
+				if got := state(cache, tip); got != tt.want {
+					t.Fatalf("cached state = %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+//在真实的链上：矿工默认为所有部署置位，三个周期后testdummy生效
+func TestDeploymentActivation(t *testing.T) {
+	c := newTestChain(t)
+
+	for c.Height() < 3*versionBitsPeriod-1 {
+		if c.IsDeploymentActive("testdummy") {
+			t.Fatalf("testdummy active at height %d", c.Height())
+		}
+		c.mine(t)
+	}
+	if !c.IsDeploymentActive("testdummy") {
+		t.Fatalf("testdummy is not active at height %d", c.Height())
+	}
+
+	info, err := c.GetDeploymentInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range info.Deployments {
+		if d.Name == "testdummy" && d.State != DeploymentActive.String() {
+			t.Fatalf("getDeploymentInfo reports %s, want %s", d.State, DeploymentActive)
+		}
+	}
+}
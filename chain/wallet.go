@@ -0,0 +1,277 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/store"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//钱包的链上状态：钱包中所有地址的UTXO和交易
+//钱包文件和区块链数据库是分开的，区块连接时不会通知钱包，使用钱包时先调用SyncWallet，
+//重放上次同步之后连接的区块。上次同步的区块不在主链上(发生了重组)时从头重新扫描
+
+//挖矿得到的output至少要有这么多确认才计入钱包的可用余额，也不会被钱包选中花费
+//这是钱包的策略，共识规则并不限制
+const CoinbaseMaturity = 100
+
+var ErrWalletNotSynced = errors.New("钱包没有同步到链尾")
+
+//钱包中的公钥哈希 -> 地址
+func walletPubKeyHashes(ws *wallet.Wallets) map[string]string {
+	hashes := make(map[string]string)
+	for address, pair := range ws.WalletsMap {
+		hashes[string(wallet.HashPubKey(pair.PublicKey))] = address
+	}
+	return hashes
+}
+
+//把钱包的状态同步到链尾，返回状态是否有变化(需要保存钱包文件)
+func (bc *BlockChain) SyncWallet(ws *wallet.Wallets) (bool, error) {
+	if ws.State != nil && bytes.Equal(ws.State.TipHash, bc.tail) && ws.StateCovers() {
+		return false, nil
+	}
+
+	hashes := walletPubKeyHashes(ws)
+	state := ws.State
+
+	err := bc.db.View(func(btx store.Tx) error {
+		tipHeight, _, err := blockIndex(btx, bc.tail)
+		if err != nil {
+			return err
+		}
+		pruned := getStateUint(btx.Bucket([]byte(chainStateBucketName)), prunedHeightKey)
+
+		//能从上次同步的位置继续的条件：没有新地址，上次的区块还在主链上，之后的区块没有被裁剪
+		var from uint64
+		if state != nil && ws.StateCovers() && state.TipHeight <= tipHeight &&
+			bytes.Equal(btx.Bucket([]byte(heightBucketName)).Get(uintToByte(state.TipHeight)), state.TipHash) &&
+			state.TipHeight+1 >= pruned {
+			from = state.TipHeight + 1
+		} else {
+			state = wallet.NewWalletState()
+			for address := range ws.WalletsMap {
+				state.Addresses[address] = true
+			}
+
+			if pruned > 0 {
+				chainLog.Warn("区块体已被裁剪，钱包只能从UTXO集合重建，没有之前的交易记录", "prunedHeight", pruned)
+				if err := scanWalletUtxoes(btx, state, hashes); err != nil {
+					return err
+				}
+				from = tipHeight + 1
+			}
+		}
+
+		for height := from; height <= tipHeight; height++ {
+			data, err := blockDataAtHeight(btx, height)
+			if err != nil {
+				return err
+			}
+			b, err := block.Deserialize(data)
+			if err != nil {
+				return fmt.Errorf("高度 %d: %w", height, err)
+			}
+			connectWalletBlock(state, hashes, b, height)
+		}
+
+		state.TipHash = bc.tail
+		state.TipHeight = tipHeight
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	ws.State = state
+	return true, nil
+}
+
+//从UTXO集合中找到钱包的所有output
+func scanWalletUtxoes(btx store.Tx, state *wallet.WalletState, hashes map[string]string) error {
+	utxos := btx.Bucket([]byte(utxoBucketName))
+	if utxos == nil {
+		return nil
+	}
+
+	return utxos.ForEach(func(k, v []byte) error {
+		entry, err := deserializeUTXOEntry(v)
+		if err != nil {
+			return err
+		}
+		address, ok := hashes[string(entry.Output.PubKeyHash)]
+		if !ok {
+			return nil
+		}
+
+		txid, index := splitOutpoint(k)
+		output := &wallet.TrackedOutput{TXID: txid, Index: index, Address: address, Value: entry.Output.Value, Height: entry.Height, Coinbase: entry.Coinbase}
+		state.Outputs[output.Key()] = output
+		return nil
+	})
+}
+
+//把区块中和钱包有关的交易应用到钱包的状态上
+func connectWalletBlock(state *wallet.WalletState, hashes map[string]string, b *block.Block, height uint64) {
+	for _, t := range b.Transactions {
+		var sent, received float64
+		involved := false
+
+		if !t.IsCoinbase() {
+			for _, input := range t.TXInputs {
+				key := wallet.OutputKey(input.TXID, input.Index)
+				if output, ok := state.Outputs[key]; ok {
+					sent += output.Value
+					involved = true
+					delete(state.Outputs, key)
+				}
+			}
+		}
+
+		for i, output := range t.TXOutputs {
+			if output.IsDataOutput() {
+				continue
+			}
+			address, ok := hashes[string(output.PubKeyHash)]
+			if !ok {
+				continue
+			}
+
+			tracked := &wallet.TrackedOutput{TXID: t.TXid, Index: int64(i), Address: address, Value: output.Value, Height: height, Coinbase: t.IsCoinbase()}
+			state.Outputs[tracked.Key()] = tracked
+			received += output.Value
+			involved = true
+		}
+
+		if involved {
+			state.Txs = append(state.Txs, wallet.TrackedTx{TXID: t.TXid, Height: height, Net: received - sent})
+		}
+	}
+}
+
+//钱包可以花费的一个output
+type WalletUTXO struct {
+	UTXOInfo
+	Address       string
+	Confirmations uint64 //0表示在交易池中
+}
+
+//钱包的余额
+type WalletBalance struct {
+	Confirmed   float64 //已经打包、可以花费的
+	Unconfirmed float64 //交易池中的交易转给钱包的，包括找零
+	Immature    float64 //确认数不足CoinbaseMaturity的挖矿奖励
+}
+
+func (bc *BlockChain) checkWalletSynced(ws *wallet.Wallets) error {
+	if ws.State == nil || !bytes.Equal(ws.State.TipHash, bc.tail) {
+		return ErrWalletNotSynced
+	}
+	return nil
+}
+
+func isImmature(output *wallet.TrackedOutput, confirmations uint64) bool {
+	return output.Coinbase && confirmations < CoinbaseMaturity
+}
+
+//钱包已经同步过的余额，被交易池中的交易花费的output不计入已确认的余额
+func (bc *BlockChain) GetWalletBalance(ws *wallet.Wallets) (*WalletBalance, error) {
+	if err := bc.checkWalletSynced(ws); err != nil {
+		return nil, err
+	}
+
+	var balance WalletBalance
+	pool := bc.PoolEntries()
+	spends := poolSpends(pool)
+
+	for _, output := range ws.State.Outputs {
+		confirmations := ws.State.TipHeight - output.Height + 1
+		_, spent := spends[outpointKey(output.TXID, output.Index)]
+		switch {
+		case isImmature(output, confirmations):
+			balance.Immature += output.Value
+		case !spent:
+			balance.Confirmed += output.Value
+		}
+	}
+
+	for _, utxo := range walletPoolUtxoes(ws, pool, spends) {
+		balance.Unconfirmed += utxo.Output.Value
+	}
+
+	return &balance, nil
+}
+
+//交易池中转给钱包、还没有被交易池中的交易花费的output
+func walletPoolUtxoes(ws *wallet.Wallets, pool map[string]*TxPoolEntry, spends map[string]string) []WalletUTXO {
+	hashes := walletPubKeyHashes(ws)
+	var utxos []WalletUTXO
+
+	for _, entry := range pool {
+		for i, output := range entry.Tx.TXOutputs {
+			if output.IsDataOutput() {
+				continue
+			}
+			address, ok := hashes[string(output.PubKeyHash)]
+			if !ok {
+				continue
+			}
+			if _, spent := spends[outpointKey(entry.Tx.TXid, int64(i))]; spent {
+				continue
+			}
+			utxos = append(utxos, WalletUTXO{UTXOInfo{entry.Tx.TXid, int64(i), output}, address, 0})
+		}
+	}
+
+	return utxos
+}
+
+//钱包中至少有minConf个确认的可以花费的output，按确认数从多到少排列
+//不包括没有成熟的挖矿奖励和已经被交易池中的交易花费的output，minConf为0时包括交易池中的output
+func (bc *BlockChain) ListWalletUnspent(ws *wallet.Wallets, minConf uint64) ([]WalletUTXO, error) {
+	if err := bc.checkWalletSynced(ws); err != nil {
+		return nil, err
+	}
+
+	pool := bc.PoolEntries()
+	spends := poolSpends(pool)
+	var utxos []WalletUTXO
+
+	for _, output := range ws.State.Outputs {
+		confirmations := ws.State.TipHeight - output.Height + 1
+		if confirmations < minConf || isImmature(output, confirmations) {
+			continue
+		}
+		if _, spent := spends[outpointKey(output.TXID, output.Index)]; spent {
+			continue
+		}
+
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(output.Address)
+		if err != nil {
+			return nil, err
+		}
+		info := UTXOInfo{output.TXID, output.Index, tx.TXOutput{Value: output.Value, PubKeyHash: pubKeyHash}}
+		utxos = append(utxos, WalletUTXO{info, output.Address, confirmations})
+	}
+
+	if minConf == 0 {
+		utxos = append(utxos, walletPoolUtxoes(ws, pool, spends)...)
+	}
+
+	sort.SliceStable(utxos, func(i, j int) bool {
+		if utxos[i].Confirmations != utxos[j].Confirmations {
+			return utxos[i].Confirmations > utxos[j].Confirmations
+		}
+		if c := bytes.Compare(utxos[i].TXID, utxos[j].TXID); c != 0 {
+			return c < 0
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+
+	return utxos, nil
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/metrics"
+	"github.com/ELOATS/btc/node"
+	"github.com/ELOATS/btc/store"
+)
+
+const Usage = `
+Usage:
+	./blockchain createBlockChain ADDRESS
+	./blockchain printChain
+	./blockchain getBalance ADDRESS 
+	./blockchain history ADDRESS [--format text|csv|json]
+	./blockchain getWalletBalance
+	./blockchain listUnspent [--minconf N]
+	./blockchain sendFromWallet TO AMOUNT FEE [--rbf] [--data DATA] [--locktime N]
+	./blockchain send FROM TO AMOUNT MINER [--data DATA]
+	./blockchain findData PREFIX
+	./blockchain createWallet [p256|secp256k1|schnorr]
+	./blockchain listAddresses
+	./blockchain printTx
+	./blockchain migrateDB
+	./blockchain submitTx FROM TO AMOUNT FEE [--rbf] [--data DATA] [--locktime N]
+	./blockchain listPending
+	./blockchain bumpFee TXID [FEERATE]
+	./blockchain mine MINER
+	./blockchain getBlockTemplate MINER
+	./blockchain startNode MINER [LISTEN_ADDR] [--validateFrom NODE_URL] [--enableAdminRPC]
+	./blockchain miner [NODE_URL]
+	./blockchain spvSync [NODE_URL] [--genesis HASH]
+	./blockchain spvPayments
+	./blockchain getBlockFilter HASH
+	./blockchain prune N|SIZE|off
+	./blockchain invalidateBlock HASH
+	./blockchain verifyChain [--level N]
+	./blockchain getDeploymentInfo
+	./blockchain exportChain FILE [--from H] [--to H]
+	./blockchain importChain FILE
+	./blockchain dumpUtxoSet FILE
+	./blockchain loadUtxoSet FILE [--hash HASH]
+	./blockchain reindexAddresses [off]
+
+Options:
+	--db=bolt|leveldb         区块链数据库的存储后端，默认bolt
+	--prune=N|SIZE|off        在执行命令之前设置裁剪模式，N为保留的区块个数，SIZE如500KB、10MB
+	--maxFutureTime=SECONDS   区块时间戳最多比本地时间晚多少秒，默认7200
+	--signal=NAME[,NAME]|none 挖矿时为哪些部署置位，默认所有已知的部署
+	--metrics=ADDR            在ADDR上提供Prometheus格式的/metrics，用于startNode和miner
+	--log=text|json           日志格式，日志写到标准错误，默认text
+	--logLevel=LEVEL          日志级别debug|info|warn|error，默认info
+	--quiet                   只输出错误日志
+
+loadUtxoSet的--hash是快照的内容哈希(dumpUtxoSet输出的"内容哈希")，用来确认快照可信
+目前没有内置的可信快照哈希(AssumeUTXO为空)，载入快照时必须用--hash指定
+`
+
+var cliLog = logging.Component(logging.CLI)
+
+type CLI struct {
+	//bc *BlockChain //
+}
+
+//执行命令，命令失败时返回错误，错误信息已经输出，由main设置退出码
+func (cli *CLI) Run() (runErr error) {
+	cmds := os.Args
+
+	//日志选项最先处理，后面的选项和命令都使用设置好的日志
+	cmds, logFormat := extractOption(cmds, "--log")
+	cmds, logLevel := extractOption(cmds, "--logLevel")
+	cmds, quiet := extractFlag(cmds, "--quiet")
+	if logFormat == "" {
+		logFormat = "text"
+	}
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	if err := logging.Setup(os.Stderr, logFormat, logLevel, quiet); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	//监控指标在单独的端口上导出，不影响RPC接口
+	cmds, metricsAddr := extractOption(cmds, "--metrics")
+	if metricsAddr != "" {
+		go func() {
+			cliLog.Info("导出监控指标", "addr", metricsAddr)
+			if err := metrics.ListenAndServe(metricsAddr); err != nil {
+				cliLog.Error("监控指标接口退出", "err", err)
+			}
+		}()
+	}
+
+	//存储后端要在打开区块链之前确定，包括下面的--prune
+	cmds, backend := extractOption(cmds, "--db")
+	switch backend {
+	case "":
+	case store.Bolt, store.LevelDB:
+		chain.Backend = backend
+	default:
+		fmt.Printf("%v: %s\n", store.ErrUnknownBackend, backend)
+		os.Exit(1)
+	}
+
+	//--prune=TARGET可以放在任意命令中，先保存裁剪目标再执行命令
+	//区块链还没有创建时(createBlockChain)，在命令执行之后再设置
+	cmds, pruneTarget := extractOption(cmds, "--prune")
+	if pruneTarget != "" {
+		if chain.IsFileExist(chain.DBPath()) {
+			if err := cli.Prune(pruneTarget); err != nil {
+				return err
+			}
+		} else {
+			//命令失败时不再设置
+			defer func() {
+				if runErr == nil {
+					runErr = cli.Prune(pruneTarget)
+				}
+			}()
+		}
+	}
+
+	cmds, maxFutureTime := extractOption(cmds, "--maxFutureTime")
+	if maxFutureTime != "" {
+		seconds, err := strconv.ParseUint(maxFutureTime, 10, 64)
+		if err != nil {
+			fmt.Printf("无效的时间: %s\n", maxFutureTime)
+			os.Exit(1)
+		}
+		chain.MaxFutureBlockTime = seconds
+	}
+
+	cmds, signal := extractOption(cmds, "--signal")
+	if signal != "" {
+		var err error
+		chain.SignalDeployments, err = chain.ParseSignalDeployments(signal)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if len(cmds) < 2 {
+		fmt.Printf(Usage)
+		os.Exit(1)
+	}
+
+	cliLog.Debug("执行命令", "args", cmds[1:])
+
+	switch cmds[1] {
+	case "createBlockChain":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		addr := cmds[2]
+		return cli.CreateBlockChain(addr)
+	case "printChain":
+		return cli.PrintChain()
+	case "getBalance":
+		return cli.GetBalance(cmds[2])
+	case "history":
+		//--format: csv和json用于导出到其他系统，默认text
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--format": true})
+		if !ok || len(args) != 1 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		format := opts["--format"]
+		switch format {
+		case "", "text", "csv", "json":
+		default:
+			fmt.Printf("不支持的格式: %s\n", format)
+			os.Exit(1)
+		}
+		return cli.History(args[0], format)
+	case "send":
+		//附加数据是可选的: send FROM TO AMOUNT MINER [--data DATA]
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--data": true})
+		if !ok || len(args) != 4 {
+			fmt.Println("Please check it!")
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		from := args[0]
+		to := args[1]
+		amount,_ := strconv.ParseFloat(args[2],64)
+		miner := args[3]
+
+		return cli.Send(from,to,amount,miner,opts["--data"])
+	case "submitTx":
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--data": true, "--rbf": false, "--locktime": true})
+		if !ok || len(args) != 4 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		amount,_ := strconv.ParseFloat(args[2],64)
+		fee,_ := strconv.ParseFloat(args[3],64)
+		_, rbf := opts["--rbf"]
+
+		var lockTime uint64
+		if value, ok := opts["--locktime"]; ok {
+			var err error
+			lockTime, err = strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				fmt.Printf("无效的锁定时间: %s\n", value)
+				os.Exit(1)
+			}
+		}
+
+		return cli.SubmitTx(args[0],args[1],amount,fee,rbf,opts["--data"],uint32(lockTime))
+	case "sendFromWallet":
+		//从钱包中的任意地址付款，和submitTx一样放入交易池
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--data": true, "--rbf": false, "--locktime": true})
+		if !ok || len(args) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		amount,_ := strconv.ParseFloat(args[1],64)
+		fee,_ := strconv.ParseFloat(args[2],64)
+		_, rbf := opts["--rbf"]
+
+		var lockTime uint64
+		if value, ok := opts["--locktime"]; ok {
+			var err error
+			lockTime, err = strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				fmt.Printf("无效的锁定时间: %s\n", value)
+				os.Exit(1)
+			}
+		}
+
+		return cli.SendFromWallet(args[0],amount,fee,rbf,opts["--data"],uint32(lockTime))
+	case "getWalletBalance":
+		return cli.GetWalletBalance()
+	case "listUnspent":
+		//--minconf: 最少的确认数，默认1，0表示包括交易池中的output
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--minconf": true})
+		if !ok || len(args) != 0 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		var minConf uint64 = 1
+		if value, ok := opts["--minconf"]; ok {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				fmt.Printf("无效的确认数: %s\n", value)
+				os.Exit(1)
+			}
+			minConf = n
+		}
+		return cli.ListUnspent(minConf)
+	case "listPending":
+		return cli.ListPending()
+	case "bumpFee":
+		if len(cmds) != 3 && len(cmds) != 4 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		var feeRate float64
+		if len(cmds) == 4 {
+			feeRate,_ = strconv.ParseFloat(cmds[3],64)
+		}
+		return cli.BumpFee(cmds[2],feeRate)
+	case "mine":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.Mine(cmds[2])
+	case "getBlockTemplate":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.GetBlockTemplate(cmds[2])
+	case "startNode":
+		//--validateFrom: 从UTXO快照启动时，从这个节点下载区块在后台验证快照
+		//--enableAdminRPC: 开启invalidateblock等管理接口，默认关闭
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--validateFrom": true, "--enableAdminRPC": false})
+		if !ok || (len(args) != 1 && len(args) != 2) {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		listenAddr := node.DefaultRPCAddr
+		if len(args) == 2 {
+			listenAddr = args[1]
+		}
+		_, enableAdmin := opts["--enableAdminRPC"]
+		return cli.StartNode(args[0],listenAddr,opts["--validateFrom"],enableAdmin)
+	case "miner":
+		nodeURL := "http://" + node.DefaultRPCAddr
+		if len(cmds) > 2 {
+			nodeURL = cmds[2]
+		}
+		return cli.Miner(nodeURL)
+	case "spvSync":
+		//--genesis: 固定创世块的哈希，没有指定时固定第一次同步到的创世块
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--genesis": true})
+		if !ok || len(args) > 1 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		nodeURL := "http://" + node.DefaultRPCAddr
+		if len(args) == 1 {
+			nodeURL = args[0]
+		}
+		return cli.SPVSync(nodeURL, opts["--genesis"])
+	case "spvPayments":
+		return cli.SPVPayments()
+	case "getBlockFilter":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.GetBlockFilter(cmds[2])
+	case "prune":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.Prune(cmds[2])
+	case "invalidateBlock":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.InvalidateBlock(cmds[2])
+	case "verifyChain":
+		//--level 0-4，默认验证到交易
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--level": true})
+		if !ok || len(args) != 0 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		level := chain.DefaultVerifyLevel
+		if value, ok := opts["--level"]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < chain.VerifyLevelHeaders || n > chain.VerifyLevelUTXOSet {
+				fmt.Printf("验证级别必须是 %d - %d\n", chain.VerifyLevelHeaders, chain.VerifyLevelUTXOSet)
+				os.Exit(1)
+			}
+			level = n
+		}
+		return cli.VerifyChain(level)
+	case "getDeploymentInfo":
+		return cli.GetDeploymentInfo()
+	case "exportChain":
+		//FILE以.gz结尾时压缩，默认导出整条链
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--from": true, "--to": true})
+		if !ok || len(args) != 1 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+
+		var from uint64
+		to := int64(-1)
+		if value, ok := opts["--from"]; ok {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				fmt.Printf("无效的高度: %s\n", value)
+				os.Exit(1)
+			}
+			from = n
+		}
+		if value, ok := opts["--to"]; ok {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				fmt.Printf("无效的高度: %s\n", value)
+				os.Exit(1)
+			}
+			to = n
+		}
+		return cli.ExportChain(args[0], from, to)
+	case "importChain":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.ImportChain(cmds[2])
+	case "dumpUtxoSet":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.DumpUTXOSet(cmds[2])
+	case "loadUtxoSet":
+		//--hash: 可信的快照内容哈希，没有指定时使用内置的值，目前没有内置的值
+		args, opts, ok := parseArgs(cmds[2:], map[string]bool{"--hash": true})
+		if !ok || len(args) != 1 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.LoadUTXOSet(args[0], opts["--hash"])
+	case "reindexAddresses":
+		//off: 关闭地址索引
+		if len(cmds) > 3 || (len(cmds) == 3 && cmds[2] != "off") {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.ReindexAddresses(len(cmds) == 3)
+	case "findData":
+		if len(cmds) != 3 {
+			fmt.Printf(Usage)
+			os.Exit(1)
+		}
+		return cli.FindData(cmds[2])
+	case "createWallet":
+		//默认使用P-256
+		keyType := "p256"
+		if len(cmds) > 2 {
+			keyType = cmds[2]
+		}
+		return cli.CreateWallet(keyType)
+	case "listAddresses":
+		return cli.ListAddresses()
+	case "printTx":
+		return cli.PrintTx()
+	case "migrateDB":
+		return cli.MigrateDB()
+	default:
+		fmt.Println("Please check it.")
+		fmt.Printf(Usage)
+		os.Exit(1)
+	}
+	return nil
+}
+
+//把参数分为位置参数和以--开头的选项
+//valueFlags中值为true的选项后面跟一个值，值为false的选项是开关
+//遇到未知的选项或者选项缺少值时返回false
+func parseArgs(args []string, valueFlags map[string]bool) ([]string, map[string]string, bool) {
+	var positional []string
+	opts := make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[:2] != "--" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		hasValue, known := valueFlags[arg]
+		if !known {
+			return nil, nil, false
+		}
+
+		if !hasValue {
+			opts[arg] = ""
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, nil, false
+		}
+		opts[arg] = args[i+1]
+		i++
+	}
+
+	return positional, opts, true
+}
+
+//从参数中取出NAME=VALUE形式的全局选项
+func extractOption(args []string, name string) ([]string, string) {
+	var rest []string
+	var value string
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, name+"=") {
+			value = strings.TrimPrefix(arg, name+"=")
+			continue
+		}
+		rest = append(rest, arg)
+	}
+
+	return rest, value
+}
+
+//从参数中取出不带值的全局开关
+func extractFlag(args []string, name string) ([]string, bool) {
+	var rest []string
+	found := false
+
+	for _, arg := range args {
+		if arg == name {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+
+	return rest, found
+}
+
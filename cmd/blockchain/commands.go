@@ -0,0 +1,1269 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/node"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/spv"
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//参数错误时的提示已经输出，返回给main设置退出码
+var errInvalidAddress = errors.New("无效地址")
+
+func (cli *CLI) CreateBlockChain(addr string) error {
+	if !wallet.IsValidAddress(addr) {
+		fmt.Printf("%s 是无效地址!\n",addr)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.CreateBlockChain(addr)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	fmt.Println("Creating blockchain is successful.")
+	return nil
+}
+
+func (cli *CLI) GetBalance(addr string) error {
+
+	if !wallet.IsValidAddress(addr) {
+		fmt.Printf("%s 是无效地址!\n",addr)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	balance, err := bc.GetBalance(addr)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("%s 的余额为: %f\n", addr, balance)
+	return nil
+}
+
+func (cli *CLI) PrintChain() error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	it := bc.NewIterator()
+
+	for {
+		block, err := it.Next()
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		fmt.Printf("+++++++++++++++++++++++++++++++++++++++++NEW BLOCK++++++++++++++++++++++++++++++++++++++++\n")
+		fmt.Printf("Version: %v\n", block.Version)
+		fmt.Printf("PrevBlockHash: %x\n", block.PrevBlockHash)
+		fmt.Printf("MerkleRoot: %x\n", block.MerkleRoot)
+		timeFormat := time.Unix(int64(block.TimeStamp), 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("TimeStamp: %s\n", timeFormat)
+		fmt.Printf("Difficulity: %v\n", block.Difficulity)
+		fmt.Printf("Nonce: %v\n", block.Nonce)
+		if block.Pruned {
+			fmt.Println("Transactions: 区块体已被裁剪")
+		}
+		for _, tx := range block.Transactions {
+			for _, output := range tx.TXOutputs {
+				if output.IsDataOutput() {
+					fmt.Printf("Data: %q\n", output.Data)
+				}
+			}
+		}
+		fmt.Printf("Hash: %x\n", block.Hash)
+
+		pow := pow.NewProofOfWork(block)
+		fmt.Printf("IsValid: %v\n", pow.IsValid())
+
+		if bytes.Equal(block.PrevBlockHash, []byte{}) {
+			fmt.Println("Traversing blockchain is over!")
+			break
+		}
+	}
+
+	printPrunedRange(bc)
+	return nil
+}
+
+//提示被裁剪的区块范围
+func printPrunedRange(bc *chain.BlockChain) {
+	if pruned := bc.PrunedHeight(); pruned > 0 {
+		fmt.Printf("高度 0 - %d 的区块体已被裁剪，只保留了区块头\n", pruned-1)
+	}
+}
+
+func (cli *CLI) Send(from,to string,amount float64,miner,data string) error {
+
+	if !wallet.IsValidAddress(from) {
+		fmt.Printf("from : %s 是无效地址!\n",from)
+		return errInvalidAddress
+	}
+
+	if !wallet.IsValidAddress(to) {
+		fmt.Printf("to : %s 是无效地址!\n",to)
+		return errInvalidAddress
+	}
+
+	if !wallet.IsValidAddress(miner) {
+		fmt.Printf("miner : %s 是无效地址!\n",miner)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	//1. 创建挖矿交易
+	coinbase := tx.NewCoinbaseTx(miner,"")
+
+	//创建交易的集合
+	txes := []*tx.Transaction{coinbase}
+
+	//2. 创建普通交易，附加数据放在交易的数据output中
+	tx, err := chain.NewTransaction(from,to,amount,chain.TxOptions{Data: []byte(data)},bc)
+
+	if err == nil {
+		txes = append(txes,tx)
+	} else {
+		fmt.Printf("发现无效交易，过滤: %v\n", err)
+	}
+
+	//3. 添加到区块
+	if _, err := bc.AddBlock(txes); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Println("Mining is successful!")
+	return nil
+}
+
+func (cli *CLI) CreateWallet(keyTypeName string) error {
+
+	keyType, err := wallet.ParseKeyType(keyTypeName)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	address, err := ws.CreateWallet(keyType)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Println("新的钱包地址为: ",address)
+	return nil
+}
+
+func (cli *CLI) ListAddresses() error {
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	addresses := ws.ListAddress()
+
+	for _,address := range addresses {
+		fmt.Printf("  %v (%s)\n",address,ws.WalletsMap[address].KeyType)
+	}
+	return nil
+}
+
+func (cli *CLI) PrintTx() error {
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	defer bc.Close()
+
+	it := bc.NewIterator()
+
+	for {
+		block, err := it.Next()
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		//更早的区块都已经被裁剪了，没有交易可以打印
+		if block.Pruned {
+			printPrunedRange(bc)
+			break
+		}
+
+		fmt.Println("+++++++++++++++++++++++++++++++++++++++NEW BLOCK+++++++++++++++++++++++++++++++++++++++")
+		for _,tx := range block.Transactions {
+			fmt.Printf("tx : %v\n",tx)
+		}
+
+		if len(block.PrevBlockHash) == 0{
+			break
+		}
+	}
+	return nil
+}
+
+//将旧的gob格式的数据库转换为规范编码
+func (cli *CLI) MigrateDB() error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	count, err := bc.MigrateFromGob()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("数据库迁移完成，共转换 %d 个区块\n", count)
+	return nil
+}
+
+//查找以prefix开头的数据output
+func (cli *CLI) FindData(prefix string) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	infoes, err := bc.FindData([]byte(prefix))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+
+	for _, info := range infoes {
+		fmt.Printf("block: %x\n", info.BlockHash)
+		fmt.Printf("  tx: %x, output: %d\n", info.TXID, info.Index)
+		fmt.Printf("  data: %q\n", info.Data)
+	}
+
+	fmt.Printf("共找到 %d 条数据\n", len(infoes))
+	printPrunedRange(bc)
+	return nil
+}
+
+//创建交易并放入交易池，等待mine命令打包
+func (cli *CLI) SubmitTx(from,to string,amount,fee float64,rbf bool,data string,lockTime uint32) error {
+	if !wallet.IsValidAddress(from) {
+		fmt.Printf("from : %s 是无效地址!\n",from)
+		return errInvalidAddress
+	}
+
+	if !wallet.IsValidAddress(to) {
+		fmt.Printf("to : %s 是无效地址!\n",to)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	//可以花费交易池中还没有打包的找零，打包时父交易会被一起带上
+	//锁定时间没有到的交易不能进入交易池
+	opts := chain.TxOptions{Fee: fee, Data: []byte(data), Replaceable: rbf, Unconfirmed: true, LockTime: lockTime}
+
+	tx, err := chain.NewTransaction(from,to,amount,opts,bc)
+	if err != nil {
+		fmt.Println("交易创建失败:",err)
+		return err
+	}
+
+	err = bc.AcceptToPool(tx)
+	if err != nil {
+		fmt.Println("交易进入交易池失败:",err)
+		return err
+	}
+
+	fmt.Printf("交易已进入交易池: %x\n",tx.TXid)
+	return nil
+}
+
+func (cli *CLI) ListPending() error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	pool := bc.PoolEntries()
+
+	//按照打包的顺序显示，不限制大小
+	for _, entry := range chain.SelectPoolTransactions(pool, math.MaxInt) {
+		fmt.Printf("%x\n", entry.Tx.TXid)
+		fmt.Printf("  fee: %f, size: %d, feeRate: %.8f, replaceable: %v\n",
+			entry.Fee, entry.Size(), entry.FeeRate(), entry.Tx.IsReplaceable())
+		fmt.Printf("  time: %s\n", time.Unix(int64(entry.Time), 0).Format("2006-01-02 15:04:05"))
+
+		for parent := range chain.PoolAncestors(pool, string(entry.Tx.TXid)) {
+			fmt.Printf("  depends: %x\n", parent)
+		}
+	}
+
+	fmt.Printf("交易池中共有 %d 个交易\n", len(pool))
+	return nil
+}
+
+func (cli *CLI) BumpFee(txidStr string,feeRate float64) error {
+	txid, err := hex.DecodeString(txidStr)
+	if err != nil {
+		fmt.Printf("%s 是无效的交易id!\n",txidStr)
+		return err
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	tx, err := bc.BumpFee(txid,feeRate)
+	if err != nil {
+		fmt.Println("提高手续费失败:",err)
+		return err
+	}
+
+	fmt.Printf("交易 %x 已被替换为 %x\n",txid,tx.TXid)
+	return nil
+}
+
+//从交易池中选择交易打包，矿工得到挖矿奖励和所有手续费
+func (cli *CLI) Mine(miner string) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	tmpl, err := bc.NewBlockTemplate(miner, chain.MaxBlockSize)
+	if err != nil {
+		fmt.Println("创建区块模板失败:", err)
+		return err
+	}
+
+	block := tmpl.Block
+	pow := pow.NewProofOfWork(block)
+	block.Hash, block.Nonce = pow.Run()
+
+	if err := bc.SubmitBlock(block); err != nil {
+		fmt.Println("区块提交失败:", err)
+		return err
+	}
+
+	fmt.Printf("Mining is successful! 打包了 %d 个交易，手续费 %f\n",len(tmpl.Entries),tmpl.Fees)
+	return nil
+}
+
+func (cli *CLI) GetBlockTemplate(miner string) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	tmpl, err := bc.NewBlockTemplate(miner, chain.MaxBlockSize)
+	if err != nil {
+		fmt.Println("创建区块模板失败:", err)
+		return err
+	}
+
+	block := tmpl.Block
+	fmt.Printf("Version: %d\n", block.Version)
+	fmt.Printf("PrevBlockHash: %x\n", block.PrevBlockHash)
+	fmt.Printf("MerkleRoot: %x\n", block.MerkleRoot)
+	fmt.Printf("TimeStamp: %d\n", block.TimeStamp)
+	fmt.Printf("Difficulity: %d\n", block.Difficulity)
+	fmt.Printf("Target: %064x\n", pow.NewProofOfWork(block).Target())
+	fmt.Printf("Coinbase: %x, value: %f\n", block.Transactions[0].TXid, block.Transactions[0].TXOutputs[0].Value)
+
+	for _, entry := range tmpl.Entries {
+		fmt.Printf("  %x fee: %f, size: %d, feeRate: %.8f\n", entry.Tx.TXid, entry.Fee, entry.Size(), entry.FeeRate())
+	}
+	fmt.Printf("共 %d 个交易，手续费 %f，区块大小约 %d 字节\n", len(tmpl.Entries), tmpl.Fees, tmpl.Size)
+	return nil
+}
+
+func (cli *CLI) StartNode(miner,listenAddr,validateFrom string,enableAdmin bool) error {
+	if !wallet.IsValidAddress(miner) {
+		fmt.Printf("miner : %s 是无效地址!\n",miner)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	//后台验证快照的结果，没有验证时一直为nil
+	var snapshotErrs chan error
+	if bc.UnvalidatedSnapshot() != nil {
+		if validateFrom == "" {
+			cliLog.Warn("链状态来自还没有验证的UTXO快照，可以用--validateFrom指定完整节点进行验证")
+		} else {
+			snapshotErrs = make(chan error, 1)
+			go func() {
+				snapshotErrs <- validateSnapshot(bc, validateFrom)
+			}()
+		}
+	}
+
+	node := node.NewNode(bc,miner)
+	if enableAdmin {
+		cliLog.Warn("管理接口已开启，RPC没有身份验证，节点应该只监听本机地址", "addr", listenAddr)
+		node.EnableAdminRPC()
+	}
+	serveErrs := make(chan error, 1)
+	go func() {
+		serveErrs <- node.ListenAndServe(listenAddr)
+	}()
+
+	for {
+		select {
+		case err := <-serveErrs:
+			fmt.Println("节点退出:",err)
+			return err
+		case err := <-snapshotErrs:
+			//快照无效时链状态不可信，关闭数据库，由main设置退出码
+			if err != nil {
+				fmt.Println("UTXO快照验证失败，节点退出:", err)
+				return err
+			}
+			snapshotErrs = nil
+		}
+	}
+}
+
+//独立的矿工进程，不打开数据库，通过RPC向节点获取任务
+func (cli *CLI) Miner(nodeURL string) error {
+	err := node.RunMiner(node.NewRPCClient(nodeURL))
+	if err != nil {
+		fmt.Println("矿工退出:",err)
+	}
+	return err
+}
+
+//钱包中所有地址的公钥哈希
+func walletPubKeyHashes() ([][]byte, error) {
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	var pubKeyHashes [][]byte
+	for _, w := range ws.WalletsMap {
+		pubKeyHashes = append(pubKeyHashes, wallet.HashPubKey(w.PublicKey))
+	}
+	return pubKeyHashes, nil
+}
+
+//轻客户端同步：下载区块头，扫描钱包相关的交易，显示收到的付款
+func (cli *CLI) SPVSync(nodeURL, genesis string) error {
+	client, err := spv.OpenSPVClient(nodeURL)
+	if err != nil {
+		fmt.Println("打开SPV数据库失败:",err)
+		return err
+	}
+	defer client.Close()
+
+	if genesis != "" {
+		hash, err := hex.DecodeString(genesis)
+		if err != nil {
+			fmt.Printf("%s 是无效的区块哈希!\n",genesis)
+			return err
+		}
+		if err := client.PinGenesis(hash); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	headers, err := client.SyncHeaders()
+	if err != nil {
+		fmt.Println("同步区块头失败:",err)
+		return err
+	}
+	fmt.Printf("同步了 %d 个区块头，当前高度 %d\n",headers,client.Height())
+
+	pubKeyHashes, err := walletPubKeyHashes()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	found, err := client.ScanWallet(pubKeyHashes)
+	if err != nil {
+
+		fmt.Println("扫描交易失败:",err)
+		return err
+	}
+	fmt.Printf("找到了 %d 个与钱包相关的交易\n",found)
+
+	return printSPVPayments(client)
+}
+
+func (cli *CLI) SPVPayments() error {
+	client, err := spv.OpenSPVClient("")
+	if err != nil {
+		fmt.Println("打开SPV数据库失败:",err)
+		return err
+	}
+	defer client.Close()
+
+	return printSPVPayments(client)
+}
+
+func printSPVPayments(client *spv.SPVClient) error {
+	pubKeyHashes, err := walletPubKeyHashes()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var balance float64
+
+	for _, payment := range client.Payments(pubKeyHashes) {
+		status := "未花费"
+		if payment.Spent {
+			status = "已花费"
+		} else {
+			balance += payment.Value
+		}
+
+		fmt.Printf("%x:%d 收到 %f，高度 %d，确认数 %d，%s\n",
+			payment.TXID,payment.Index,payment.Value,payment.Height,payment.Confirmations,status)
+	}
+
+	fmt.Printf("区块头高度 %d，余额 %f\n",client.Height(),balance)
+	return nil
+}
+
+func (cli *CLI) GetBlockFilter(hashStr string) error {
+	hash, err := hex.DecodeString(hashStr)
+	if err != nil {
+		fmt.Printf("%s 是无效的区块哈希!\n",hashStr)
+		return err
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	filterData, header, prevHeader, err := bc.GetBlockFilter(hash)
+	if err != nil {
+		fmt.Println("获取区块过滤器失败:",err)
+		return err
+	}
+
+	gcs, err := filter.ParseGCSFilter(filterData)
+	if err != nil {
+		fmt.Println("过滤器无效:",err)
+		return err
+	}
+
+	fmt.Printf("Filter: %x\n",filterData)
+	fmt.Printf("Elements: %d\n",gcs.N)
+	fmt.Printf("FilterHeader: %x\n",header)
+	fmt.Printf("PrevFilterHeader: %x\n",prevHeader)
+
+	//用钱包中的地址在本地匹配，演示轻钱包的用法
+	pubKeyHashes, err := walletPubKeyHashes()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	matched, _ := filter.MatchBlockFilter(filterData,hash,pubKeyHashes,nil)
+	fmt.Printf("钱包地址匹配: %v\n",matched)
+	return nil
+}
+
+//把区块标记为无效，回滚到它的前一个区块，被断开区块中的交易放回交易池
+func (cli *CLI) InvalidateBlock(hashStr string) error {
+	hash, err := hex.DecodeString(hashStr)
+	if err != nil {
+		fmt.Printf("%s 是无效的区块哈希!\n",hashStr)
+		return err
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	disconnected, err := bc.InvalidateBlock(hash)
+	if err != nil {
+		fmt.Println("回滚失败:",err)
+		return err
+	}
+
+	for _, block := range disconnected {
+		fmt.Printf("断开区块: %x\n",block.Hash)
+	}
+	count := bc.ResurrectTransactions(disconnected)
+
+	fmt.Printf("共断开 %d 个区块，%d 个交易放回交易池\n",len(disconnected),count)
+	fmt.Printf("当前链尾: %x\n",bc.Tip())
+	return nil
+}
+
+var errVerifyFailed = errors.New("区块链验证失败")
+
+//从创世块开始验证整条链，输出第一个失败的检查，失败时返回错误，main据此把退出码设为1
+func (cli *CLI) VerifyChain(level int) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	report := bc.VerifyChain(level)
+	bc.Close()
+
+	fmt.Printf("验证级别: %d\n",report.Level)
+	fmt.Printf("链尾高度: %d\n",report.TipHeight)
+	fmt.Printf("已验证区块: %d\n",report.Checked)
+	if report.PrunedHeight > 0 {
+		fmt.Printf("已裁剪: 高度 0 - %d 只验证了区块头\n",report.PrunedHeight-1)
+	}
+	if report.Skipped != "" {
+		fmt.Printf("跳过: %s\n",report.Skipped)
+	}
+
+	if report.Failure == nil {
+		fmt.Println("结果: 通过")
+		return nil
+	}
+
+	failure := report.Failure
+	fmt.Println("结果: 失败")
+	fmt.Printf("  高度: %d\n",failure.Height)
+	fmt.Printf("  区块: %x\n",failure.Hash)
+	if len(failure.TXID) > 0 {
+		fmt.Printf("  交易: %x\n",failure.TXID)
+	}
+	fmt.Printf("  检查项: %s\n",failure.Check)
+	fmt.Printf("  原因: %s\n",failure.Reason)
+	return fmt.Errorf("%w: 高度 %d", errVerifyFailed, failure.Height)
+}
+
+//显示软分叉部署的状态
+func (cli *CLI) GetDeploymentInfo() error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	info, err := bc.GetDeploymentInfo()
+	if err != nil {
+		fmt.Println("获取部署信息失败:",err)
+		return err
+	}
+
+	fmt.Printf("链尾: %s (高度 %d)\n",info.Hash,info.Height)
+	fmt.Printf("周期: %d 个区块，锁定需要 %d 个区块置位\n",info.Period,info.Threshold)
+
+	for _, d := range info.Deployments {
+		fmt.Printf("%s (bit %d)\n",d.Name,d.Bit)
+		fmt.Printf("  状态: %s\n",d.State)
+		fmt.Printf("  开始时间: %d, 超时时间: %d\n",d.StartTime,d.Timeout)
+		fmt.Printf("  本节点置位: %v\n",d.Signaling)
+		if d.State == chain.DeploymentStarted.String() {
+			fmt.Printf("  当前周期(从高度 %d 开始): %d/%d 个区块置位，还可能锁定: %v\n",d.PeriodStart,d.Count,d.Elapsed,d.Possible)
+		}
+	}
+	return nil
+}
+
+//设置裁剪目标，并立即删除超出目标的区块体
+func (cli *CLI) Prune(target string) error {
+	depth, size, err := chain.ParsePruneTarget(target)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	count, err := bc.SetPrune(depth, size)
+	if err != nil {
+		fmt.Println("裁剪失败:", err)
+		return err
+	}
+
+	switch {
+	case depth > 0:
+		fmt.Printf("裁剪模式: 保留最近的 %d 个区块\n", depth)
+	case size > 0:
+		fmt.Printf("裁剪模式: 区块体不超过 %d 字节\n", size)
+	default:
+		fmt.Println("裁剪模式已关闭")
+	}
+	fmt.Printf("本次裁剪了 %d 个区块\n", count)
+	printPrunedRange(bc)
+	return nil
+}
+
+//按高度导出区块，文件名以.gz结尾时用gzip压缩，to为负数表示导出到链尾
+func (cli *CLI) ExportChain(file string, from uint64, to int64) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	end := bc.Height()
+	if to >= 0 {
+		end = uint64(to)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(file, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+
+	err = bc.ExportBlocks(w, from, end, printProgress("导出区块"))
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		fmt.Println("导出失败:", err)
+		return err
+	}
+
+	fmt.Printf("已导出高度 %d - %d 的区块到 %s\n", from, end, file)
+	return nil
+}
+
+//导入区块，每个区块都完整校验后再连接，已经在链上的区块跳过
+//区块链不存在时，文件必须从创世块开始
+func (cli *CLI) ImportChain(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer f.Close()
+
+	reader, err := chain.NewBlockReader(f)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var bc *chain.BlockChain
+	var done uint64
+	imported, skipped := 0, 0
+
+	if chain.IsFileExist(chain.DBPath()) {
+		bc, err = chain.NewBlockChain()
+	} else if reader.From != 0 {
+		err = fmt.Errorf("区块链不存在，导入文件必须从创世块开始，文件从高度 %d 开始", reader.From)
+	} else {
+		var genesis *block.Block
+		genesis, _, err = reader.Next()
+		if err == nil {
+			bc, err = chain.CreateBlockChainFromGenesis(genesis)
+			done, imported = 1, 1
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	progress := printProgress("导入区块")
+
+	for {
+		b, height, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			var connected bool
+			connected, err = bc.ImportBlock(b, height)
+			if connected {
+				imported++
+			} else {
+				skipped++
+			}
+		}
+		if err != nil {
+			fmt.Println("\n导入失败:", err)
+			fmt.Printf("已导入 %d 个区块，链尾高度 %d\n", imported, bc.Height())
+			return err
+		}
+
+		done++
+		progress(done, reader.Count)
+	}
+
+	fmt.Printf("已导入 %d 个区块，跳过 %d 个已有的区块，链尾高度 %d\n", imported, skipped, bc.Height())
+	return nil
+}
+
+//在标准错误上显示进度，百分比变化时才刷新
+func printProgress(label string) func(done, total uint64) {
+	last := -1
+
+	return func(done, total uint64) {
+		percent := 100
+		if total > 0 {
+			percent = int(done * 100 / total)
+		}
+		if percent == last && done != total {
+			return
+		}
+		last = percent
+
+		fmt.Fprintf(os.Stderr, "\r%s: %d/%d (%d%%)", label, done, total, percent)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+//验证快照，快照无效时返回错误
+//验证中断(比如连接不上节点)不影响已经载入的链状态，只记录日志，下次启动时重新验证
+func validateSnapshot(bc *chain.BlockChain, nodeURL string) error {
+	err := node.ValidateSnapshot(bc, node.NewRPCClient(nodeURL))
+	if err != nil && !errors.Is(err, chain.ErrBadSnapshot) {
+		cliLog.Error("UTXO快照验证中断", "err", err)
+		return nil
+	}
+	return err
+}
+
+//把链尾的UTXO集合写入快照文件
+func (cli *CLI) DumpUTXOSet(file string) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer f.Close()
+
+	info, err := bc.DumpUTXOSet(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		fmt.Println("导出快照失败:", err)
+		return err
+	}
+
+	fmt.Printf("高度: %d\n", info.Height)
+	fmt.Printf("区块: %x\n", info.BlockHash)
+	fmt.Printf("UTXO个数: %d\n", info.UTXOCount)
+	fmt.Printf("内容哈希: %x\n", info.ContentHash)
+	return nil
+}
+
+//从快照创建区块链，hash为空时使用内置的可信哈希
+func (cli *CLI) LoadUTXOSet(file, hash string) error {
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		fmt.Println("无效的哈希:", hash)
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer f.Close()
+
+	bc, info, err := chain.LoadUTXOSnapshot(f, expected)
+	if err != nil {
+		fmt.Println("载入快照失败:", err)
+		return err
+	}
+	defer bc.Close()
+
+	fmt.Printf("已载入高度 %d 的UTXO快照，%d 个UTXO\n", info.Height, info.UTXOCount)
+	fmt.Println("快照还没有验证，启动节点时用--validateFrom指定完整节点在后台验证")
+	return nil
+}
+
+//地址的交易历史，format为text、csv或json
+func (cli *CLI) History(addr, format string) error {
+	if !wallet.IsValidAddress(addr) {
+		fmt.Printf("%s 是无效地址!\n",addr)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	entries, err := bc.AddressHistory(addr)
+	if err != nil {
+		fmt.Println("查询交易历史失败:", err)
+		return err
+	}
+
+	switch format {
+	case "csv":
+		err = writeHistoryCSV(os.Stdout, entries)
+	case "json":
+		err = writeHistoryJSON(os.Stdout, entries)
+	default:
+		printHistory(addr, entries)
+		printPrunedRange(bc)
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	//标准输出留给导出的数据
+	if pruned := bc.PrunedHeight(); pruned > 0 {
+		fmt.Fprintf(os.Stderr, "高度 0 - %d 的区块体已被裁剪，其中的交易不在历史中\n", pruned-1)
+	}
+	return nil
+}
+
+func printHistory(addr string, entries []chain.HistoryEntry) {
+	var total float64
+
+	for _, entry := range entries {
+		fmt.Printf("%x\n", entry.TXID)
+		if entry.Confirmations == 0 {
+			fmt.Printf("  交易池中，进入时间: %s\n", formatTime(entry.TimeStamp))
+		} else {
+			fmt.Printf("  高度: %d, 时间: %s, 确认数: %d\n", entry.Height, formatTime(entry.TimeStamp), entry.Confirmations)
+		}
+		fmt.Printf("  金额: %+f (收到 %f, 支出 %f)\n", entry.Net(), entry.Received, entry.Sent)
+		if entry.Coinbase {
+			fmt.Println("  挖矿奖励")
+		}
+		for _, counterparty := range entry.Counterparties {
+			fmt.Printf("  对方: %s\n", counterparty)
+		}
+		total += entry.Net()
+	}
+
+	fmt.Printf("%s 共有 %d 个交易，合计: %f\n", addr, len(entries), total)
+}
+
+func formatTime(timeStamp uint64) string {
+	return time.Unix(int64(timeStamp), 0).Format("2006-01-02 15:04:05")
+}
+
+//导出给其他系统使用的交易历史记录，金额是对于查询的地址而言的
+type historyRecord struct {
+	TXID           string   `json:"txid"`
+	Height         uint64   `json:"height"`
+	BlockHash      string   `json:"blockHash"`
+	Time           string   `json:"time"`
+	Received       float64  `json:"received"`
+	Sent           float64  `json:"sent"`
+	Net            float64  `json:"net"`
+	Coinbase       bool     `json:"coinbase"`
+	Counterparties []string `json:"counterparties"`
+	Confirmations  uint64   `json:"confirmations"`
+}
+
+func newHistoryRecord(entry *chain.HistoryEntry) historyRecord {
+	counterparties := entry.Counterparties
+	if counterparties == nil {
+		counterparties = []string{}
+	}
+
+	return historyRecord{
+		TXID:           hex.EncodeToString(entry.TXID),
+		Height:         entry.Height,
+		BlockHash:      hex.EncodeToString(entry.BlockHash),
+		Time:           time.Unix(int64(entry.TimeStamp), 0).UTC().Format(time.RFC3339),
+		Received:       entry.Received,
+		Sent:           entry.Sent,
+		Net:            entry.Net(),
+		Coinbase:       entry.Coinbase,
+		Counterparties: counterparties,
+		Confirmations:  entry.Confirmations,
+	}
+}
+
+func writeHistoryJSON(w io.Writer, entries []chain.HistoryEntry) error {
+	records := []historyRecord{}
+	for i := range entries {
+		records = append(records, newHistoryRecord(&entries[i]))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+//多个对方地址用分号分隔，金额保留8位小数
+func writeHistoryCSV(w io.Writer, entries []chain.HistoryEntry) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"txid", "height", "blockHash", "time", "received", "sent", "net", "coinbase", "counterparties", "confirmations"})
+
+	for i := range entries {
+		record := newHistoryRecord(&entries[i])
+		_ = cw.Write([]string{
+			record.TXID,
+			strconv.FormatUint(record.Height, 10),
+			record.BlockHash,
+			record.Time,
+			strconv.FormatFloat(record.Received, 'f', 8, 64),
+			strconv.FormatFloat(record.Sent, 'f', 8, 64),
+			strconv.FormatFloat(record.Net, 'f', 8, 64),
+			strconv.FormatBool(record.Coinbase),
+			strings.Join(record.Counterparties, ";"),
+			strconv.FormatUint(record.Confirmations, 10),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+//开启或重建地址索引，off为true时关闭
+func (cli *CLI) ReindexAddresses(off bool) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	if off {
+		if err := bc.DropAddressIndex(); err != nil {
+			fmt.Println("关闭地址索引失败:", err)
+			return err
+		}
+		fmt.Println("地址索引已关闭")
+		return nil
+	}
+
+	start := time.Now()
+	count, from, err := bc.ReindexAddresses()
+	if err != nil {
+		fmt.Println("重建地址索引失败:", err)
+		return err
+	}
+
+	fmt.Printf("地址索引重建完成，索引了 %d 个区块，耗时 %v\n", count, time.Since(start).Round(time.Millisecond))
+	if from > 0 {
+		fmt.Printf("高度 0 - %d 的区块体已被裁剪，不在索引中，查询余额仍然需要遍历UTXO集合\n", from-1)
+	}
+	return nil
+}
+
+//打开钱包并同步到链尾，状态有变化时保存钱包文件
+func loadSyncedWallet(bc *chain.BlockChain) (*wallet.Wallets, error) {
+	ws, err := wallet.NewWallets()
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := bc.SyncWallet(ws)
+	if err != nil {
+		return nil, fmt.Errorf("同步钱包失败: %w", err)
+	}
+	if changed {
+		if err := ws.SaveToFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ws, nil
+}
+
+//钱包中所有地址的余额
+func (cli *CLI) GetWalletBalance() error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	ws, err := loadSyncedWallet(bc)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	balance, err := bc.GetWalletBalance(ws)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("钱包共有 %d 个地址，%d 个交易，同步到高度 %d\n", len(ws.WalletsMap), len(ws.State.Txs), ws.State.TipHeight)
+	fmt.Printf("已确认: %f\n", balance.Confirmed)
+	fmt.Printf("未确认: %f\n", balance.Unconfirmed)
+	fmt.Printf("未成熟: %f (挖矿奖励需要 %d 个确认)\n", balance.Immature, chain.CoinbaseMaturity)
+	return nil
+}
+
+//钱包中可以花费的output
+func (cli *CLI) ListUnspent(minConf uint64) error {
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	ws, err := loadSyncedWallet(bc)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	utxos, err := bc.ListWalletUnspent(ws, minConf)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var total float64
+	for _, utxo := range utxos {
+		fmt.Printf("%x:%d\n", utxo.TXID, utxo.Index)
+		fmt.Printf("  address: %s, value: %f, confirmations: %d\n", utxo.Address, utxo.Output.Value, utxo.Confirmations)
+		total += utxo.Output.Value
+	}
+
+	fmt.Printf("共有 %d 个output，合计: %f\n", len(utxos), total)
+	return nil
+}
+
+//从钱包中的任意地址凑出金额，创建交易并放入交易池
+func (cli *CLI) SendFromWallet(to string,amount,fee float64,rbf bool,data string,lockTime uint32) error {
+	if !wallet.IsValidAddress(to) {
+		fmt.Printf("to : %s 是无效地址!\n",to)
+		return errInvalidAddress
+	}
+
+	bc, err := chain.NewBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer bc.Close()
+
+	ws, err := loadSyncedWallet(bc)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	opts := chain.TxOptions{Fee: fee, Data: []byte(data), Replaceable: rbf, Unconfirmed: true, LockTime: lockTime}
+
+	tx, err := chain.NewWalletTransaction(ws,to,amount,opts,bc)
+	if err != nil {
+		fmt.Println("交易创建失败:",err)
+		return err
+	}
+
+	err = bc.AcceptToPool(tx)
+	if err != nil {
+		fmt.Println("交易进入交易池失败:",err)
+		return err
+	}
+
+	fmt.Printf("交易已进入交易池: %x\n",tx.TXid)
+	return nil
+}
@@ -1,5 +1,9 @@
 package main
 
+import "os"
+
+
+
 //v1
 //1. 定义结构: 前区块哈希、当前区块哈希、数据(目前使用字节流)
 //2. 创建区块,对Block的每一个字段填充数据即可
@@ -49,8 +53,10 @@ v4
 
 func main() {
 	//bc := NewBlockChain("xiaohong")
-	//defer bc.db.Close()
+	//defer bc.Close()
 	//cli := CLI{bc}
 	cli := CLI{}
-	cli.Run()
+	if err := cli.Run(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,61 @@
+package filter
+
+import (
+	"crypto/sha256"
+
+	"github.com/ELOATS/btc/block"
+)
+
+//区块过滤器：对区块中所有output的公钥哈希和被花费的output构造GCS过滤器
+//轻钱包下载过滤器在本地匹配自己的地址，全节点不知道钱包关心哪些地址
+
+//过滤器中的元素：所有非数据output的公钥哈希，所有input花费的output
+func blockFilterItems(b *block.Block) [][]byte {
+	var items [][]byte
+
+	for _, t := range b.Transactions {
+		for _, output := range t.TXOutputs {
+			if !output.IsDataOutput() && len(output.PubKeyHash) > 0 {
+				items = append(items, output.PubKeyHash)
+			}
+		}
+
+		if t.IsCoinbase() {
+			continue
+		}
+		for _, input := range t.TXInputs {
+			items = append(items, OutpointBytes(input.TXID, input.Index))
+		}
+	}
+
+	return items
+}
+
+//过滤器的key是区块哈希的前16个字节
+func blockFilterKey(blockHash []byte) [16]byte {
+	var key [16]byte
+	copy(key[:], blockHash)
+	return key
+}
+
+func NewBlockFilter(b *block.Block) *GCSFilter {
+	return NewGCSFilter(blockFilterKey(b.Hash), blockFilterItems(b))
+}
+
+//过滤器头：sha256(sha256(filter) || 前一个区块的过滤器头)
+func NextHeader(filter []byte, prevHeader []byte) []byte {
+	filterHash := sha256.Sum256(filter)
+	header := sha256.Sum256(append(filterHash[:], prevHeader...))
+	return header[:]
+}
+
+//客户端匹配：钱包的公钥哈希和钱包拥有的output是否出现在区块中
+func MatchBlockFilter(filterData []byte, blockHash []byte, pubKeyHashes [][]byte, outpoints [][]byte) (bool, error) {
+	filter, err := ParseGCSFilter(filterData)
+	if err != nil {
+		return false, err
+	}
+
+	items := append(append([][]byte{}, pubKeyHashes...), outpoints...)
+	return filter.MatchAny(blockFilterKey(blockHash), items), nil
+}
@@ -0,0 +1,151 @@
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/ELOATS/btc/tx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//布隆过滤器，SPV客户端把自己关心的公钥哈希放进去交给全节点，全节点只返回匹配的交易
+//过滤器有一定的误判率，全节点不能确定哪些地址真正属于客户端
+//参数和哈希函数与BIP37相同
+
+const (
+	maxBloomFilterSize = 36000 //字节
+	maxBloomHashFuncs  = 50
+	bloomSeedScale     = 0xfba4c795
+)
+
+type BloomFilter struct {
+	Filter    []byte `json:"filter"`
+	HashFuncs uint32 `json:"hashFuncs"`
+	Tweak     uint32 `json:"tweak"`
+}
+
+//根据元素个数和误判率计算过滤器大小和哈希函数个数
+func NewBloomFilter(elements int, fpRate float64, tweak uint32) *BloomFilter {
+	if elements < 1 {
+		elements = 1
+	}
+
+	size := int(-1 / (math.Ln2 * math.Ln2) * float64(elements) * math.Log(fpRate) / 8)
+	size = min(max(size, 1), maxBloomFilterSize)
+
+	hashFuncs := int(float64(size*8) / float64(elements) * math.Ln2)
+	hashFuncs = min(max(hashFuncs, 1), maxBloomHashFuncs)
+
+	return &BloomFilter{Filter: make([]byte, size), HashFuncs: uint32(hashFuncs), Tweak: tweak}
+}
+
+//过滤器参数是否合法，全节点收到客户端的过滤器时检查
+func (f *BloomFilter) IsValid() bool {
+	return len(f.Filter) > 0 && len(f.Filter) <= maxBloomFilterSize &&
+		f.HashFuncs > 0 && f.HashFuncs <= maxBloomHashFuncs
+}
+
+func (f *BloomFilter) bitIndex(n uint32, data []byte) uint32 {
+	return murmurHash3(n*bloomSeedScale+f.Tweak, data) % uint32(len(f.Filter)*8)
+}
+
+func (f *BloomFilter) Add(data []byte) {
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		idx := f.bitIndex(i, data)
+		f.Filter[idx>>3] |= 1 << (idx & 7)
+	}
+}
+
+func (f *BloomFilter) Contains(data []byte) bool {
+	for i := uint32(0); i < f.HashFuncs; i++ {
+		idx := f.bitIndex(i, data)
+		if f.Filter[idx>>3]&(1<<(idx&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//output定位的字节形式：交易id + 8字节小端序索引
+func OutpointBytes(txid []byte, index int64) []byte {
+	data := make([]byte, len(txid)+8)
+	copy(data, txid)
+	binary.LittleEndian.PutUint64(data[len(txid):], uint64(index))
+	return data
+}
+
+//检查交易是否与过滤器匹配：
+//1. output的公钥哈希在过滤器中，匹配后把这个output加入过滤器，之后花费它的交易也能匹配
+//2. input花费的output在过滤器中
+//3. input的公钥哈希在过滤器中
+func (f *BloomFilter) MatchTxAndUpdate(t *tx.Transaction) bool {
+	matched := false
+
+	for i, output := range t.TXOutputs {
+		if !output.IsDataOutput() && f.Contains(output.PubKeyHash) {
+			matched = true
+			f.Add(OutpointBytes(t.TXid, int64(i)))
+		}
+	}
+
+	if t.IsCoinbase() {
+		return matched
+	}
+
+	for _, input := range t.TXInputs {
+		if f.Contains(OutpointBytes(input.TXID, input.Index)) || f.Contains(wallet.HashPubKey(input.PubKey)) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+//32位的MurmurHash3
+func murmurHash3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	n := len(data) / 4
+
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[n*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
@@ -0,0 +1,250 @@
+package filter
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/ELOATS/btc/internal/wire"
+)
+
+//Golomb编码集合(GCS)，参考BIP158
+//1. 每个元素用SipHash-2-4(key)哈希后映射到[0, N*M)
+//2. 排序后对相邻元素的差做Golomb-Rice编码：商用一元编码(q个1加一个0)，余数用P位二进制
+//误判率约为1/M，客户端用同一个key对自己的元素做哈希，在解码的同时做比较
+
+const (
+	gcsP = 19
+	gcsM = 784931
+)
+
+//最多的元素个数，防止恶意的过滤器导致大量计算
+const maxGCSElements = 1 << 24
+
+type GCSFilter struct {
+	N    uint32 //元素个数
+	Data []byte //Golomb-Rice编码的比特流
+}
+
+//把元素哈希到[0, f)，用128位乘法代替取模
+func gcsHash(key [16]byte, item []byte, f uint64) uint64 {
+	hi, _ := bits.Mul64(sipHash24(key, item), f)
+	return hi
+}
+
+func gcsHashedSet(key [16]byte, items [][]byte, n uint32) []uint64 {
+	f := uint64(n) * gcsM
+
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		values = append(values, gcsHash(key, item, f))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+//构造过滤器，items中重复的元素只保留一个
+func NewGCSFilter(key [16]byte, items [][]byte) *GCSFilter {
+	unique := make(map[string]bool)
+	var set [][]byte
+	for _, item := range items {
+		if !unique[string(item)] {
+			unique[string(item)] = true
+			set = append(set, item)
+		}
+	}
+
+	filter := &GCSFilter{N: uint32(len(set))}
+	if filter.N == 0 {
+		return filter
+	}
+
+	var w bitWriter
+	var last uint64
+	for _, value := range gcsHashedSet(key, set, filter.N) {
+		delta := value - last
+		last = value
+
+		for q := delta >> gcsP; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, gcsP)
+	}
+
+	filter.Data = w.bytes
+	return filter
+}
+
+//过滤器的编码：元素个数(uvarint) + 比特流
+func (f *GCSFilter) Bytes() []byte {
+	var e wire.Encoder
+	e.PutUvarint(uint64(f.N))
+	e.PutRaw(f.Data)
+	return e.Bytes()
+}
+
+func ParseGCSFilter(data []byte) (*GCSFilter, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > maxGCSElements {
+		return nil, errors.New("过滤器的元素个数无效")
+	}
+	return &GCSFilter{N: uint32(n), Data: data[size:]}, nil
+}
+
+//items中是否有任意一个元素在过滤器中(可能误判，不会漏判)
+func (f *GCSFilter) MatchAny(key [16]byte, items [][]byte) bool {
+	if f.N == 0 || len(items) == 0 {
+		return false
+	}
+
+	queries := gcsHashedSet(key, items, f.N)
+
+	r := bitReader{data: f.Data}
+	var value uint64
+	for i := uint32(0); i < f.N; i++ {
+		delta, err := r.readGolomb()
+		if err != nil {
+			return false
+		}
+		value += delta
+
+		//两个有序序列做归并比较
+		for len(queries) > 0 && queries[0] < value {
+			queries = queries[1:]
+		}
+		if len(queries) == 0 {
+			return false
+		}
+		if queries[0] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *GCSFilter) Match(key [16]byte, item []byte) bool {
+	return f.MatchAny(key, [][]byte{item})
+}
+
+type bitWriter struct {
+	bytes []byte
+	nbits uint8 //最后一个字节已经写入的位数
+}
+
+func (w *bitWriter) writeBit(bit uint8) {
+	if w.nbits == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.nbits = 8
+	}
+	if bit != 0 {
+		w.bytes[len(w.bytes)-1] |= 1 << (w.nbits - 1)
+	}
+	w.nbits--
+}
+
+//从高位到低位写入value的低n位
+func (w *bitWriter) writeBits(value uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(uint8(value >> (i - 1) & 1))
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint64 //已经读取的位数
+}
+
+var errBitStreamEnd = errors.New("比特流已经结束")
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= uint64(len(r.data))*8 {
+		return 0, errBitStreamEnd
+	}
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint64(bit), nil
+}
+
+func (r *bitReader) readGolomb() (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+	}
+
+	var rem uint64
+	for i := 0; i < gcsP; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		rem = rem<<1 | bit
+	}
+
+	return q<<gcsP | rem, nil
+}
+
+//SipHash-2-4，输出64位
+func sipHash24(key [16]byte, data []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(data) / 8
+	for i := 0; i < n; i++ {
+		m := binary.LittleEndian.Uint64(data[i*8:])
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	//最后一块：剩余的字节加上最高字节的长度
+	var last [8]byte
+	copy(last[:], data[n*8:])
+	last[7] = byte(len(data))
+	m := binary.LittleEndian.Uint64(last[:])
+
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
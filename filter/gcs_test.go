@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/tx"
+)
+
+func testItems(prefix string, n int) [][]byte {
+	items := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		items = append(items, []byte(fmt.Sprintf("%s-%d", prefix, i)))
+	}
+	return items
+}
+
+//SipHash-2-4论文附录中的测试向量：key为00..0f，消息为00..0e
+func TestSipHash24(t *testing.T) {
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	tests := []struct {
+		length int
+		want   uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d bytes", tt.length), func(t *testing.T) {
+			data := make([]byte, tt.length)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			if got := sipHash24(key, data); got != tt.want {
+				t.Fatalf("sipHash24 = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCSFilterMatch(t *testing.T) {
+	key := [16]byte{1, 2, 3}
+
+	tests := []struct {
+		name  string
+		items [][]byte
+	}{
+		{"one item", testItems("a", 1)},
+		{"many items", testItems("a", 500)},
+		{"duplicates", append(testItems("a", 10), testItems("a", 10)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built := NewGCSFilter(key, tt.items)
+
+			//经过编码和解码之后匹配结果不变
+			f, err := ParseGCSFilter(built.Bytes())
+			if err != nil {
+				t.Fatalf("ParseGCSFilter: %v", err)
+			}
+			if f.N != built.N {
+				t.Fatalf("N = %d, want %d", f.N, built.N)
+			}
+
+			for _, item := range tt.items {
+				if !f.Match(key, item) {
+					t.Fatalf("item %s not matched", item)
+				}
+			}
+			if !f.MatchAny(key, append(testItems("b", 5), tt.items[0])) {
+				t.Fatal("MatchAny missed an item")
+			}
+
+			//误判率约为1/M，固定的元素不应该误判
+			if f.MatchAny(key, testItems("b", 1000)) {
+				t.Fatal("non-members matched")
+			}
+		})
+	}
+}
+
+func TestGCSFilterEmpty(t *testing.T) {
+	f := NewGCSFilter([16]byte{}, nil)
+	if f.N != 0 || len(f.Data) != 0 {
+		t.Fatalf("empty filter = %+v", f)
+	}
+	if f.Match([16]byte{}, []byte("a")) {
+		t.Fatal("empty filter matched")
+	}
+	if NewGCSFilter([16]byte{}, testItems("a", 3)).MatchAny([16]byte{}, nil) {
+		t.Fatal("empty query matched")
+	}
+}
+
+func TestParseGCSFilterRejects(t *testing.T) {
+	tooMany := binary.AppendUvarint(nil, maxGCSElements+1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad count", []byte{0xff}},
+		{"too many elements", tooMany},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseGCSFilter(tt.data); err == nil {
+				t.Fatal("ParseGCSFilter succeeded, want error")
+			}
+		})
+	}
+}
+
+//比特流被截断时，截断位置之后的元素匹配失败，不会越界
+func TestGCSFilterTruncated(t *testing.T) {
+	key := [16]byte{1}
+	items := testItems("a", 100)
+	data := NewGCSFilter(key, items).Bytes()
+
+	f, err := ParseGCSFilter(data[:len(data)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var matched int
+	for _, item := range items {
+		if f.Match(key, item) {
+			matched++
+		}
+	}
+	if matched == 0 || matched == len(items) {
+		t.Fatalf("%d of %d items matched a truncated filter", matched, len(items))
+	}
+
+	if (&GCSFilter{N: 10}).MatchAny(key, items) {
+		t.Fatal("filter without data matched")
+	}
+}
+
+func TestBlockFilter(t *testing.T) {
+	coinbase := &tx.Transaction{
+		Version:   tx.TxVersion,
+		TXInputs:  []tx.TXInput{{Index: -1, PubKey: []byte("coinbase")}},
+		TXOutputs: []tx.TXOutput{{Value: tx.Reward, PubKeyHash: []byte("miner")}},
+	}
+	coinbase.SetTXID()
+	spend := &tx.Transaction{
+		Version:   tx.TxVersion,
+		TXInputs:  []tx.TXInput{{TXID: []byte("prev"), Index: 1}},
+		TXOutputs: []tx.TXOutput{{Value: 1, PubKeyHash: []byte("payee")}, tx.NewDataOutput([]byte("note"))},
+	}
+	spend.SetTXID()
+
+	b := block.New([]*tx.Transaction{coinbase, spend}, []byte("prev block"), 1, 1000, 0)
+	b.Hash = []byte("0123456789abcdef-block")
+	data := NewBlockFilter(b).Bytes()
+
+	tests := []struct {
+		name         string
+		pubKeyHashes [][]byte
+		outpoints    [][]byte
+		match        bool
+	}{
+		{"coinbase output", [][]byte{[]byte("miner")}, nil, true},
+		{"payment output", [][]byte{[]byte("payee")}, nil, true},
+		{"spent outpoint", nil, [][]byte{OutpointBytes([]byte("prev"), 1)}, true},
+		{"other outpoint", nil, [][]byte{OutpointBytes([]byte("prev"), 0)}, false},
+		{"coinbase input", nil, [][]byte{OutpointBytes(nil, -1)}, false},
+		{"data output", [][]byte{[]byte("note")}, nil, false},
+		{"unrelated", [][]byte{[]byte("other")}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := MatchBlockFilter(data, b.Hash, tt.pubKeyHashes, tt.outpoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.match {
+				t.Fatalf("MatchBlockFilter = %v, want %v", match, tt.match)
+			}
+		})
+	}
+
+	//过滤器头依赖前一个过滤器头，构成一条链
+	first := NextHeader(data, make([]byte, 32))
+	if string(NextHeader(data, first)) == string(first) {
+		t.Fatal("filter header does not depend on the previous header")
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/ELOATS/btc
+
+go 1.22
+
+require (
+	github.com/boltdb/bolt v1.3.1
+	github.com/btcsuite/btcd/btcec/v2 v2.3.6
+	github.com/syndtr/goleveldb v1.0.1-0.20220721030215-126854af5e6d
+	golang.org/x/crypto v0.9.0
+)
+
+require (
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.1 // indirect
+	github.com/decred/dcrd/crypto/blake256 v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+)
@@ -0,0 +1,39 @@
+package legacytx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+)
+
+//迁移之前的交易结构，只用来重新计算版本0交易的gob哈希
+//gob的编码中包含类型名和字段名，这里的类型名、字段名、字段类型和顺序都必须和旧版本完全一致，不能修改
+
+type TXInput struct {
+	TXID      []byte
+	Index     int64
+	Signature []byte
+	PubKey    []byte
+}
+
+type TXOutput struct {
+	Value      float64
+	PubKeyHash []byte
+}
+
+type Transaction struct {
+	TXid      []byte
+	TXInputs  []TXInput
+	TXOutputs []TXOutput
+}
+
+//旧版本SetTXID的计算方法：整个结构(包括TXid字段)gob编码之后的sha256
+func (tx *Transaction) Hash() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(tx); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(buffer.Bytes())
+	return hash[:], nil
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+//分级的结构化日志，每条日志带有component字段，表示来自哪个模块
+//日志写到标准错误，命令的结果写到标准输出，两者互不干扰
+//库代码只记录日志或者返回错误，向用户显示什么由命令行决定
+
+const (
+	Chain  = "chain"
+	PoW    = "pow"
+	Wallet = "wallet"
+	Tx     = "tx"
+	CLI    = "cli"
+	Node   = "node"
+	SPV    = "spv"
+)
+
+//当前使用的handler，各个包在初始化时创建的logger都转发到这里，Setup之后立即生效
+var current atomic.Pointer[slog.Handler]
+
+func init() {
+	_ = Setup(os.Stderr, "text", "info", false)
+}
+
+//设置日志的格式(text|json)和级别(debug|info|warn|error)，quiet只输出错误
+func Setup(w io.Writer, format, level string, quiet bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("无效的日志级别: %s", level)
+	}
+	if quiet {
+		lvl = slog.LevelError
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("无效的日志格式: %s", format)
+	}
+
+	current.Store(&handler)
+	return nil
+}
+
+//模块的logger，每条日志带有component字段
+func Component(name string) *slog.Logger {
+	return slog.New(forwardHandler{}).With("component", name)
+}
+
+//哈希、交易id等字节串以十六进制记录
+func Hex(key string, data []byte) slog.Attr {
+	return slog.String(key, hex.EncodeToString(data))
+}
+
+//把日志转发给当前的handler，With添加的字段在转发时再附加上
+type forwardHandler struct {
+	wrap func(slog.Handler) slog.Handler
+}
+
+func (h forwardHandler) target() slog.Handler {
+	handler := *current.Load()
+	if h.wrap != nil {
+		handler = h.wrap(handler)
+	}
+	return handler
+}
+
+func (h forwardHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (*current.Load()).Enabled(ctx, level)
+}
+
+func (h forwardHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.target().Handle(ctx, record)
+}
+
+func (h forwardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return forwardHandler{wrap: func(handler slog.Handler) slog.Handler {
+		if h.wrap != nil {
+			handler = h.wrap(handler)
+		}
+		return handler.WithAttrs(attrs)
+	}}
+}
+
+func (h forwardHandler) WithGroup(name string) slog.Handler {
+	return forwardHandler{wrap: func(handler slog.Handler) slog.Handler {
+		if h.wrap != nil {
+			handler = h.wrap(handler)
+		}
+		return handler.WithGroup(name)
+	}}
+}
@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+//进程内的监控指标，以Prometheus文本格式(version 0.0.4)从/metrics导出
+//各个包在初始化时创建自己的指标，创建时注册到同一个表中，名字重复时panic
+//需要在采集时才计算的指标(比如UTXO个数)，用OnScrape注册回调，在输出之前更新
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]metric)
+	scrapers []func()
+)
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := registry[m.name()]; ok {
+		panic("重复的指标: " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+//每次采集之前调用fn，fn中更新Gauge的值
+func OnScrape(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	scrapers = append(scrapers, fn)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//单调递增的计数器
+type Counter struct {
+	metricName, help string
+	value            atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) name() string {
+	return c.metricName
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.metricName, c.value.Load())
+}
+
+//带一个标签的一组计数器，比如按RPC方法统计的请求数
+type CounterVec struct {
+	metricName, help, label string
+
+	mu       sync.Mutex
+	counters map[string]*atomic.Uint64
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{metricName: name, help: help, label: label, counters: make(map[string]*atomic.Uint64)}
+	register(v)
+	return v
+}
+
+func (v *CounterVec) Inc(labelValue string) {
+	v.mu.Lock()
+	c, ok := v.counters[labelValue]
+	if !ok {
+		c = new(atomic.Uint64)
+		v.counters[labelValue] = c
+	}
+	v.mu.Unlock()
+
+	c.Add(1)
+}
+
+func (v *CounterVec) name() string {
+	return v.metricName
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, v.metricName, v.help, "counter")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	values := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", v.metricName, v.label, strconv.Quote(value), v.counters[value].Load())
+	}
+}
+
+//可以任意设置的值
+type Gauge struct {
+	metricName, help string
+	bits             atomic.Uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) name() string {
+	return g.metricName
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.Value()))
+}
+
+//直方图，buckets是升序的上界，最后自动加上+Inf
+type Histogram struct {
+	metricName, help string
+	buckets          []float64
+
+	mu     sync.Mutex
+	counts []uint64 //落在每个区间中的个数，不累加
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		buckets:    append(append([]float64{}, buckets...), math.Inf(1)),
+	}
+	h.counts = make([]uint64, len(h.buckets))
+	register(h)
+	return h
+}
+
+//以秒为单位的耗时常用的区间，从1ms到10s
+var LatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, h.count)
+}
+
+//按名字排序输出所有指标
+func WriteTo(w *bufio.Writer) {
+	mu.Lock()
+	hooks := append([]func(){}, scrapers...)
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+//导出指标的HTTP处理函数
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		WriteTo(bw)
+		_ = bw.Flush()
+	})
+}
+
+//在addr上监听，只提供/metrics
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
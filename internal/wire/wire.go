@@ -0,0 +1,214 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+//区块和交易的规范二进制编码，用来代替gob
+//gob的结果依赖于Go的类型名和字段名，不适合作为共识格式，其他语言也无法复现
+//编码规则：
+//1. 无符号整数(计数、版本号)使用uvarint，有符号整数(output索引)使用zigzag varint
+//2. 定长字段使用小端序，uint64、float64占8字节，uint32占4字节
+//3. 切片先写长度(uvarint)，再写内容
+//4. 区块记录以魔数和编码版本号开头，交易以自己的Version开头
+//区块和交易的具体布局分别在block和tx包中
+
+//单个变长字段的上限，防止损坏的数据导致分配超大内存
+const maxVarBytes = 32 << 20
+
+var ErrTrailingBytes = errors.New("编码数据末尾有多余字节")
+
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *Encoder) PutUvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *Encoder) PutVarint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *Encoder) PutUint64(v uint64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *Encoder) PutUint32(v uint32) {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	e.buf.Write(tmp[:])
+}
+
+func (e *Encoder) PutFloat64(v float64) {
+	e.PutUint64(math.Float64bits(v))
+}
+
+func (e *Encoder) PutBytes(b []byte) {
+	e.PutUvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+//不带长度直接写入，用于魔数这样的定长字段
+func (e *Encoder) PutRaw(b []byte) {
+	e.buf.Write(b)
+}
+
+func (e *Encoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+//解码器，遇到第一个错误后后续读取都返回零值，最后统一检查Finish
+type Decoder struct {
+	r    byteReader
+	data *bytes.Reader //NewDecoder的输入，流式解码时为nil
+	err  error
+}
+
+func NewDecoder(data []byte) *Decoder {
+	r := bytes.NewReader(data)
+	return &Decoder{r: r, data: r}
+}
+
+//从流中解码，用于快照这样不适合整个读入内存的文件
+//不知道剩余的长度，GetCount只检查maxVarBytes，Finish也不检查末尾的多余字节
+func NewStreamDecoder(r io.Reader) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+//第一个错误
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+//记录解码错误，只保留第一个
+func (d *Decoder) Fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *Decoder) GetUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = err
+	}
+	return v
+}
+
+func (d *Decoder) GetVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.err = err
+	}
+	return v
+}
+
+func (d *Decoder) GetUint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var tmp [8]byte
+	if _, err := io.ReadFull(d.r, tmp[:]); err != nil {
+		d.err = err
+		return 0
+	}
+	return binary.LittleEndian.Uint64(tmp[:])
+}
+
+func (d *Decoder) GetUint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	var tmp [4]byte
+	if _, err := io.ReadFull(d.r, tmp[:]); err != nil {
+		d.err = err
+		return 0
+	}
+	return binary.LittleEndian.Uint32(tmp[:])
+}
+
+func (d *Decoder) GetFloat64() float64 {
+	return math.Float64frombits(d.GetUint64())
+}
+
+//长度为0时返回nil，与gob解码的结果保持一致
+func (d *Decoder) GetBytes() []byte {
+	n := d.GetCount()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+		return nil
+	}
+	return b
+}
+
+//读取n个字节的定长字段，与PutRaw对应
+func (d *Decoder) GetRaw(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+		return nil
+	}
+	return b
+}
+
+//读取切片长度，长度不能超过剩余的字节数
+func (d *Decoder) GetCount() int {
+	n := d.GetUvarint()
+	if d.err != nil {
+		return 0
+	}
+	if n > maxVarBytes {
+		d.err = fmt.Errorf("长度 %d 超过上限 %d", n, maxVarBytes)
+		return 0
+	}
+	if d.data != nil && n > uint64(d.data.Len()) {
+		d.err = fmt.Errorf("长度 %d 超出剩余数据 %d", n, d.data.Len())
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) Finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.data != nil && d.data.Len() != 0 {
+		return ErrTrailingBytes
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/ELOATS/btc/chain"
+)
+
+//管理接口，可以回滚区块，RPC没有身份验证，所以默认不开启
+
+type InvalidateBlockParams struct {
+	Hash string `json:"hash"`
+}
+
+type InvalidateBlockResult struct {
+	Disconnected []string `json:"disconnected"` //被断开的区块，从原来的链尾开始
+	Tip          string   `json:"tip"`
+	Resurrected  int      `json:"resurrected"` //放回交易池的交易个数
+}
+
+//开启管理接口，必须在ListenAndServe之前调用，开启时节点应该只监听本机地址
+func (n *Node) EnableAdminRPC() {
+	n.Handle("invalidateblock", n.handleInvalidateBlock)
+}
+
+//节点运行时持有数据库，管理员通过RPC回滚
+func (n *Node) handleInvalidateBlock(params json.RawMessage) (interface{}, error) {
+	var p InvalidateBlockParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	hash, err := hex.DecodeString(p.Hash)
+	if err != nil {
+		return nil, &RPCError{rpcInvalidParams, "hash无效: " + err.Error()}
+	}
+
+	disconnected, err := n.bc.InvalidateBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	result := InvalidateBlockResult{Tip: hex.EncodeToString(n.bc.Tip())}
+	for _, block := range disconnected {
+		result.Disconnected = append(result.Disconnected, hex.EncodeToString(block.Hash))
+	}
+	result.Resurrected = n.bc.ResurrectTransactions(disconnected)
+
+	//旧的挖矿任务都建立在被断开的区块之上
+	n.jobs = make(map[string]*chain.BlockTemplate)
+
+	return result, nil
+}
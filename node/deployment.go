@@ -0,0 +1,12 @@
+package node
+
+import "encoding/json"
+
+//版本位部署的状态，见chain包的GetDeploymentInfo
+func (n *Node) registerDeploymentHandlers() {
+	n.Handle("getdeploymentinfo", n.handleGetDeploymentInfo)
+}
+
+func (n *Node) handleGetDeploymentInfo(params json.RawMessage) (interface{}, error) {
+	return n.bc.GetDeploymentInfo()
+}
@@ -0,0 +1,45 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+//区块过滤器(BIP158)，轻钱包下载后在本地匹配自己的地址
+
+type GetBlockFilterParams struct {
+	Hash string `json:"hash"`
+}
+
+type BlockFilterResult struct {
+	Filter     string `json:"filter"`
+	Header     string `json:"header"`
+	PrevHeader string `json:"prevHeader"`
+}
+
+func (n *Node) registerFilterHandlers() {
+	n.Handle("getblockfilter", n.handleGetBlockFilter)
+}
+
+func (n *Node) handleGetBlockFilter(params json.RawMessage) (interface{}, error) {
+	var p GetBlockFilterParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	hash, err := hex.DecodeString(p.Hash)
+	if err != nil {
+		return nil, &RPCError{rpcInvalidParams, "hash无效: " + err.Error()}
+	}
+
+	filter, header, prevHeader, err := n.bc.GetBlockFilter(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return BlockFilterResult{
+		Filter:     hex.EncodeToString(filter),
+		Header:     hex.EncodeToString(header),
+		PrevHeader: hex.EncodeToString(prevHeader),
+	}, nil
+}
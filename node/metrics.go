@@ -0,0 +1,77 @@
+package node
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ELOATS/btc/internal/metrics"
+)
+
+//节点导出的监控指标，链的状态在每次采集时从数据库统计
+//还没有P2P网络，没有对等节点的个数，用最近访问过RPC接口的客户端个数代替
+
+//多长时间内访问过的客户端算作活跃
+const activeClientWindow = time.Minute
+
+var (
+	rpcRequests = metrics.NewCounterVec("btc_rpc_requests_total", "处理的RPC请求个数", "method")
+
+	chainHeight  = metrics.NewGauge("btc_chain_height", "链尾的高度")
+	tipAge       = metrics.NewGauge("btc_chain_tip_age_seconds", "链尾区块的时间戳距离现在的秒数")
+	utxoCount    = metrics.NewGauge("btc_utxo_count", "UTXO集合中的output个数")
+	totalSupply  = metrics.NewGauge("btc_total_supply", "UTXO集合中所有output的金额之和")
+	mempoolTxs   = metrics.NewGauge("btc_mempool_transactions", "交易池中的交易个数")
+	mempoolBytes = metrics.NewGauge("btc_mempool_bytes", "交易池中交易的大小之和(字节)")
+	dbSize       = metrics.NewGauge("btc_db_size_bytes", "区块链数据库占用的空间(字节)")
+	miningJobs   = metrics.NewGauge("btc_mining_jobs", "已经下发给矿工、还没有提交的挖矿任务个数")
+	rpcClients   = metrics.NewGauge("btc_rpc_clients", "最近一分钟内访问过节点的客户端个数(按IP)")
+)
+
+//记录访问节点的客户端
+func (n *Node) seeClient(r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	n.clientsMu.Lock()
+	n.clients[host] = time.Now()
+	n.clientsMu.Unlock()
+}
+
+//统计活跃的客户端个数，顺便删除过期的记录
+func (n *Node) activeClients() int {
+	n.clientsMu.Lock()
+	defer n.clientsMu.Unlock()
+
+	for host, seen := range n.clients {
+		if time.Since(seen) > activeClientWindow {
+			delete(n.clients, host)
+		}
+	}
+	return len(n.clients)
+}
+
+//采集指标之前更新链的状态
+func (n *Node) updateMetrics() {
+	n.mu.Lock()
+	stats, err := n.bc.Stats()
+	jobs := len(n.jobs)
+	n.mu.Unlock()
+
+	if err != nil {
+		nodeLog.Warn("统计链的状态失败", "err", err)
+		return
+	}
+
+	chainHeight.Set(float64(stats.Height))
+	tipAge.Set(float64(time.Now().Unix()) - float64(stats.TipTime))
+	utxoCount.Set(float64(stats.UTXOCount))
+	totalSupply.Set(stats.TotalSupply)
+	mempoolTxs.Set(float64(stats.PoolTxs))
+	mempoolBytes.Set(float64(stats.PoolBytes))
+	dbSize.Set(float64(stats.DBSize))
+	miningJobs.Set(float64(jobs))
+	rpcClients.Set(float64(n.activeClients()))
+}
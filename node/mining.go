@@ -0,0 +1,264 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/pow"
+)
+
+//外部挖矿协议(类似getwork)：
+//1. 矿工调用getwork，节点创建区块模板，返回区块头中PrepareData用到的字段和目标值
+//2. 矿工在本地搜索nonce，找到后调用submitwork提交任务id和nonce
+//3. 节点找到对应的模板，填入nonce，用ProofOfWork.IsValid校验后写入区块链
+//每个任务的挖矿交易带有随机数据，多个矿工拿到的区块头不同，不会重复计算
+
+//节点最多保存的任务数，超过时丢弃最早的任务
+const maxMiningJobs = 256
+
+//矿工每搜索这么多个nonce，检查一次链尾是否已经变化
+const minerBatchSize = 1 << 18
+
+//下发给矿工的区块头，哈希都使用十六进制
+type MiningWork struct {
+	JobID         string `json:"jobId"`
+	Version       uint64 `json:"version"`
+	PrevBlockHash string `json:"prevBlockHash"`
+	MerkleRoot    string `json:"merkleRoot"`
+	TimeStamp     uint64 `json:"timeStamp"`
+	Difficulity   uint64 `json:"difficulity"`
+	Target        string `json:"target"`
+}
+
+//还原出只有区块头的区块，用于计算工作量证明
+func (w *MiningWork) header() (*block.Block, error) {
+	prevBlockHash, err := hex.DecodeString(w.PrevBlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("prevBlockHash无效: %v", err)
+	}
+	merkleRoot, err := hex.DecodeString(w.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("merkleRoot无效: %v", err)
+	}
+
+	header := &block.Block{
+		Version:       w.Version,
+		PrevBlockHash: prevBlockHash,
+		MerkleRoot:    merkleRoot,
+		TimeStamp:     w.TimeStamp,
+		Difficulity:   w.Difficulity,
+	}
+
+	//矿工自己计算目标值，与节点给出的不一致时拒绝这个任务
+	if target := fmt.Sprintf("%064x", pow.NewProofOfWork(header).Target()); target != w.Target {
+		return nil, fmt.Errorf("目标值 %s 与难度值 %d 不符", w.Target, w.Difficulity)
+	}
+
+	return header, nil
+}
+
+type SubmitWorkParams struct {
+	JobID string `json:"jobId"`
+	Nonce uint64 `json:"nonce"`
+}
+
+type SubmitWorkResult struct {
+	Hash string `json:"hash"`
+}
+
+//区块模板的JSON表示
+type BlockTemplateResult struct {
+	MiningWork
+	CoinbaseValue float64            `json:"coinbaseValue"`
+	Fees          float64            `json:"fees"`
+	Size          int                `json:"size"`
+	Transactions  []TemplateTxResult `json:"transactions"`
+}
+
+type TemplateTxResult struct {
+	TXID    string   `json:"txid"`
+	Data    string   `json:"data"` //交易的规范编码
+	Fee     float64  `json:"fee"`
+	Size    int      `json:"size"`
+	Depends []string `json:"depends,omitempty"` //区块中排在它前面的父交易
+}
+
+func (n *Node) registerMiningHandlers() {
+	n.Handle("getwork", n.handleGetWork)
+	n.Handle("submitwork", n.handleSubmitWork)
+	n.Handle("getblocktemplate", n.handleGetBlockTemplate)
+	n.Handle("getbestblockhash", n.handleGetBestBlockHash)
+}
+
+//创建新的区块模板，保存为一个挖矿任务
+func (n *Node) newJob() (string, *chain.BlockTemplate, error) {
+	tmpl, err := n.bc.NewBlockTemplate(n.miner, chain.MaxBlockSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	//链尾已经变化的任务不可能再被接受，先清理掉
+	for id, job := range n.jobs {
+		if !bytes.Equal(job.Block.PrevBlockHash, n.bc.Tip()) {
+			delete(n.jobs, id)
+		}
+	}
+
+	n.jobSeq++
+	if n.jobSeq > maxMiningJobs {
+		delete(n.jobs, strconv.FormatUint(n.jobSeq-maxMiningJobs, 10))
+	}
+
+	id := strconv.FormatUint(n.jobSeq, 10)
+	n.jobs[id] = tmpl
+
+	return id, tmpl, nil
+}
+
+func newMiningWork(id string, block *block.Block) MiningWork {
+	return MiningWork{
+		JobID:         id,
+		Version:       block.Version,
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		TimeStamp:     block.TimeStamp,
+		Difficulity:   block.Difficulity,
+		Target:        fmt.Sprintf("%064x", pow.NewProofOfWork(block).Target()),
+	}
+}
+
+func (n *Node) handleGetWork(params json.RawMessage) (interface{}, error) {
+	id, tmpl, err := n.newJob()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMiningWork(id, tmpl.Block), nil
+}
+
+func (n *Node) handleSubmitWork(params json.RawMessage) (interface{}, error) {
+	var p SubmitWorkParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	tmpl, ok := n.jobs[p.JobID]
+	if !ok {
+		return nil, fmt.Errorf("任务 %s 不存在或者已经过期", p.JobID)
+	}
+
+	//在拷贝上填写nonce，无效的提交不影响任务本身
+	block := *tmpl.Block
+	block.Nonce = p.Nonce
+
+	work := pow.NewProofOfWork(&block)
+	if !work.IsValid() {
+		return nil, fmt.Errorf("nonce %d 不满足难度要求", p.Nonce)
+	}
+	hash := sha256.Sum256(work.PrepareData(block.Nonce))
+	block.Hash = hash[:]
+
+	if err := n.bc.SubmitBlock(&block); err != nil {
+		return nil, err
+	}
+
+	delete(n.jobs, p.JobID)
+	nodeLog.Info("挖矿任务成功", "job", p.JobID, logging.Hex("hash", block.Hash), "txs", len(tmpl.Entries))
+
+	return SubmitWorkResult{Hash: hex.EncodeToString(block.Hash)}, nil
+}
+
+func (n *Node) handleGetBlockTemplate(params json.RawMessage) (interface{}, error) {
+	id, tmpl, err := n.newJob()
+	if err != nil {
+		return nil, err
+	}
+
+	block := tmpl.Block
+	result := BlockTemplateResult{
+		MiningWork:    newMiningWork(id, block),
+		CoinbaseValue: block.Transactions[0].TXOutputs[0].Value,
+		Fees:          tmpl.Fees,
+		Size:          tmpl.Size,
+		Transactions:  []TemplateTxResult{},
+	}
+
+	included := make(map[string]bool)
+	for _, entry := range tmpl.Entries {
+		tx := entry.Tx
+		txResult := TemplateTxResult{
+			TXID: hex.EncodeToString(tx.TXid),
+			Data: hex.EncodeToString(tx.Serialize()),
+			Fee:  entry.Fee,
+			Size: entry.Size(),
+		}
+		for _, input := range tx.TXInputs {
+			if included[string(input.TXID)] {
+				txResult.Depends = append(txResult.Depends, hex.EncodeToString(input.TXID))
+			}
+		}
+		included[string(tx.TXid)] = true
+
+		result.Transactions = append(result.Transactions, txResult)
+	}
+
+	return result, nil
+}
+
+func (n *Node) handleGetBestBlockHash(params json.RawMessage) (interface{}, error) {
+	return hex.EncodeToString(n.bc.Tip()), nil
+}
+
+//独立的矿工：从节点获取任务，在本地计算工作量证明，找到后提交
+//每搜索minerBatchSize个nonce检查一次链尾，链尾变化说明任务已经过期
+func RunMiner(client *RPCClient) error {
+	for {
+		var work MiningWork
+		if err := client.Call("getwork", nil, &work); err != nil {
+			return err
+		}
+
+		block, err := work.header()
+		if err != nil {
+			return err
+		}
+
+		powLog.Info("收到挖矿任务", "job", work.JobID, "prev", work.PrevBlockHash)
+		start := time.Now()
+
+		target := pow.NewProofOfWork(block)
+		for nonce := uint64(0); ; nonce += minerBatchSize {
+			hash, found, ok := target.Search(nonce, minerBatchSize)
+			if ok {
+				powLog.Info("找到nonce", "nonce", found, logging.Hex("hash", hash), "elapsed", time.Since(start))
+
+				var result SubmitWorkResult
+				err := client.Call("submitwork", SubmitWorkParams{work.JobID, found}, &result)
+				if err != nil {
+					powLog.Warn("提交失败", "job", work.JobID, "err", err)
+				} else {
+					powLog.Info("区块已被节点接受", "hash", result.Hash)
+				}
+				break
+			}
+
+			var best string
+			if err := client.Call("getbestblockhash", nil, &best); err != nil {
+				return err
+			}
+			if best != work.PrevBlockHash {
+				powLog.Info("链尾已经变化，放弃当前任务", "job", work.JobID)
+
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/metrics"
+)
+
+//节点的JSON-RPC接口：客户端用POST发送 {"id":1,"method":"...","params":{...}}
+//节点持有区块链数据库，外部矿工等客户端通过这个接口与节点交互
+
+var (
+	nodeLog = logging.Component(logging.Node)
+	powLog  = logging.Component(logging.PoW)
+)
+
+const DefaultRPCAddr = "127.0.0.1:8332"
+
+//请求体的大小上限，提交的区块不会超过MaxBlockSize
+const maxRPCRequestSize = 4 * chain.MaxBlockSize
+
+//JSON-RPC 2.0的错误码
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcMiscError      = -1
+)
+
+type rpcRequest struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc错误 %d: %s", e.Code, e.Message)
+}
+
+//处理一个方法的函数，返回的结果会被编码成JSON
+type rpcHandler func(params json.RawMessage) (interface{}, error)
+
+//节点：持有区块链实例，处理RPC请求
+//所有访问区块链的处理函数都在mu的保护下串行执行
+type Node struct {
+	bc    *chain.BlockChain
+	miner string //节点创建区块模板时使用的挖矿地址
+
+	mu       sync.Mutex
+	handlers map[string]rpcHandler
+	jobs     map[string]*chain.BlockTemplate //已经下发给矿工的挖矿任务
+	jobSeq   uint64
+
+	clientsMu sync.Mutex
+	clients   map[string]time.Time //客户端IP -> 最近一次访问的时间
+}
+
+func NewNode(bc *chain.BlockChain, miner string) *Node {
+	node := &Node{
+		bc:       bc,
+		miner:    miner,
+		handlers: make(map[string]rpcHandler),
+		jobs:     make(map[string]*chain.BlockTemplate),
+		clients:  make(map[string]time.Time),
+	}
+
+	node.registerMiningHandlers()
+	node.registerSPVHandlers()
+	node.registerFilterHandlers()
+	node.registerDeploymentHandlers()
+	node.registerSnapshotHandlers()
+
+	metrics.OnScrape(node.updateMetrics)
+
+	return node
+}
+
+//注册一个RPC方法
+func (n *Node) Handle(method string, handler rpcHandler) {
+	n.handlers[method] = handler
+}
+
+func (n *Node) ListenAndServe(addr string) error {
+	nodeLog.Info("节点开始监听", "addr", addr)
+	return http.ListenAndServe(addr, n)
+}
+
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	n.seeClient(r)
+
+	var req rpcRequest
+	var resp rpcResponse
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRPCRequestSize))
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+
+	if err != nil {
+		resp.Error = &RPCError{rpcParseError, err.Error()}
+	} else {
+		resp.ID = req.ID
+		resp.Result, resp.Error = n.dispatch(&req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&resp)
+}
+
+func (n *Node) dispatch(req *rpcRequest) (json.RawMessage, *RPCError) {
+	handler, ok := n.handlers[req.Method]
+	if !ok {
+		rpcRequests.Inc("unknown")
+		return nil, &RPCError{rpcMethodNotFound, "未知的方法: " + req.Method}
+	}
+	rpcRequests.Inc(req.Method)
+
+	n.mu.Lock()
+	result, err := handler(req.Params)
+	n.mu.Unlock()
+
+	if err != nil {
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) {
+			return nil, rpcErr
+		}
+		return nil, &RPCError{rpcMiscError, err.Error()}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, &RPCError{rpcMiscError, err.Error()}
+	}
+	return data, nil
+}
+
+//解析请求参数，参数格式不对时返回rpcInvalidParams
+func parseParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return &RPCError{rpcInvalidParams, "缺少参数"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &RPCError{rpcInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+//RPC客户端
+type RPCClient struct {
+	url    string
+	client *http.Client
+	seq    int
+}
+
+func NewRPCClient(url string) *RPCClient {
+	return &RPCClient{url: url, client: &http.Client{}}
+}
+
+//调用节点的方法，结果解码到result中，result为nil时忽略结果
+func (c *RPCClient) Call(method string, params interface{}, result interface{}) error {
+	c.seq++
+	req := rpcRequest{ID: c.seq, Method: method}
+
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("节点返回 %s", httpResp.Status)
+	}
+
+	var resp rpcResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
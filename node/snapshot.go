@@ -0,0 +1,92 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/chain"
+)
+
+//从UTXO快照启动的节点没有快照之前的区块体，
+//它从另一个完整节点按高度下载区块，在后台重放验证快照
+
+const MaxBlocksPerRequest = 100
+
+type GetBlocksParams struct {
+	Height uint64 `json:"height"` //第一个区块的高度
+	Max    int    `json:"max"`
+}
+
+type GetBlocksResult struct {
+	Blocks []string `json:"blocks"` //区块的规范编码，按高度排列
+}
+
+func (n *Node) registerSnapshotHandlers() {
+	n.Handle("getblocks", n.handleGetBlocks)
+}
+
+func (n *Node) handleGetBlocks(params json.RawMessage) (interface{}, error) {
+	var p GetBlocksParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Max <= 0 || p.Max > MaxBlocksPerRequest {
+		p.Max = MaxBlocksPerRequest
+	}
+
+	blocks, err := n.bc.BlocksByHeight(p.Height, p.Max)
+	if err != nil {
+		return nil, err
+	}
+
+	result := GetBlocksResult{Blocks: []string{}}
+	for _, b := range blocks {
+		result.Blocks = append(result.Blocks, hex.EncodeToString(b.Serialize()))
+	}
+	return result, nil
+}
+
+//从client下载快照之前的区块并重放，直到验证完成或者失败
+//只读取区块索引，不需要持有节点的锁
+func ValidateSnapshot(bc *chain.BlockChain, client *RPCClient) error {
+	validator, err := bc.NewSnapshotValidator()
+	if err != nil {
+		return err
+	}
+
+	nodeLog.Info("开始后台验证UTXO快照", "height", validator.Info.Height)
+
+	for !validator.Done() {
+		var result GetBlocksResult
+		err := client.Call("getblocks", GetBlocksParams{validator.Next(), MaxBlocksPerRequest}, &result)
+		if err != nil {
+			return err
+		}
+		if len(result.Blocks) == 0 {
+			return fmt.Errorf("对方节点没有高度 %d 的区块", validator.Next())
+		}
+
+		for _, data := range result.Blocks {
+			raw, err := hex.DecodeString(data)
+			if err != nil {
+				return err
+			}
+			b, err := block.Deserialize(raw)
+			if err != nil {
+				return err
+			}
+			if err := validator.Connect(b); err != nil {
+				return err
+			}
+			if validator.Done() {
+				break
+			}
+		}
+
+		nodeLog.Debug("快照验证进度", "height", validator.Next()-1, "target", validator.Info.Height)
+	}
+
+	return nil
+}
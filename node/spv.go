@@ -0,0 +1,156 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/filter"
+)
+
+//轻客户端使用的接口：下载区块头，用布隆过滤器请求匹配的交易和梅克尔证明
+
+const (
+	MaxHeadersPerRequest      = 2000
+	MaxMerkleBlocksPerRequest = 500
+
+	//区块定位器的哈希个数上限，步长加倍，足够覆盖很长的链
+	maxLocatorSize = 101
+)
+
+type GetHeadersParams struct {
+	After string `json:"after"` //客户端最后一个区块头的哈希，为空时从创世块开始
+	Max   int    `json:"max"`
+
+	//区块定位器：客户端主链上的区块哈希，从新到旧，不为空时代替After
+	//节点从其中第一个在自己主链上的区块之后返回，客户端据此找到分叉点
+	Locator []string `json:"locator,omitempty"`
+}
+
+type GetHeadersResult struct {
+	Headers []string `json:"headers"` //区块头的规范编码
+}
+
+type GetMerkleBlocksParams struct {
+	Filter filter.BloomFilter `json:"filter"`
+	After  string      `json:"after"`
+	Max    int         `json:"max"`
+}
+
+type MerkleBlockResult struct {
+	Header  string            `json:"header"`
+	Matches []MatchedTxResult `json:"matches,omitempty"`
+}
+
+type MatchedTxResult struct {
+	TXID  string      `json:"txid"`
+	Data  string      `json:"data"` //交易的规范编码
+	Proof block.MerkleProof `json:"proof"`
+}
+
+func (n *Node) registerSPVHandlers() {
+	n.Handle("getheaders", n.handleGetHeaders)
+	n.Handle("getmerkleblocks", n.handleGetMerkleBlocks)
+}
+
+func parseLocator(after string, max, limit int) ([]byte, int, error) {
+	hash, err := hex.DecodeString(after)
+	if err != nil {
+		return nil, 0, &RPCError{rpcInvalidParams, "after无效: " + err.Error()}
+	}
+	if max <= 0 || max > limit {
+		max = limit
+	}
+	return hash, max, nil
+}
+
+func (n *Node) handleGetHeaders(params json.RawMessage) (interface{}, error) {
+	var p GetHeadersParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	after, max, err := parseLocator(p.After, p.Max, MaxHeadersPerRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Locator) > maxLocatorSize {
+		return nil, &RPCError{rpcInvalidParams, fmt.Sprintf("locator最多 %d 个哈希", maxLocatorSize)}
+	}
+	if len(p.Locator) > 0 {
+		var locator [][]byte
+		for _, hashHex := range p.Locator {
+			hash, err := hex.DecodeString(hashHex)
+			if err != nil {
+				return nil, &RPCError{rpcInvalidParams, "locator无效: " + err.Error()}
+			}
+			locator = append(locator, hash)
+		}
+		after = n.bc.FindFork(locator)
+	}
+
+	blocks, err := n.bc.BlocksAfter(after, max)
+	if err != nil {
+		return nil, err
+	}
+
+	result := GetHeadersResult{Headers: []string{}}
+	for _, block := range blocks {
+		result.Headers = append(result.Headers, hex.EncodeToString(block.Header().Serialize()))
+	}
+	return result, nil
+}
+
+//对after之后的每个区块，返回区块头和与过滤器匹配的交易及其梅克尔证明
+//匹配的output会被加入过滤器(BIP37的BLOOM_UPDATE_ALL)，后面花费它的交易也会匹配
+func (n *Node) handleGetMerkleBlocks(params json.RawMessage) (interface{}, error) {
+	var p GetMerkleBlocksParams
+	if err := parseParams(params, &p); err != nil {
+		return nil, err
+	}
+	if !p.Filter.IsValid() {
+		return nil, &RPCError{rpcInvalidParams, "布隆过滤器参数无效"}
+	}
+
+	after, max, err := parseLocator(p.After, p.Max, MaxMerkleBlocksPerRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := n.bc.BlocksAfter(after, max)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []MerkleBlockResult{}
+	for _, block := range blocks {
+		//裁剪过的区块没有交易，无法提供梅克尔证明
+		if block.Pruned {
+			return nil, fmt.Errorf("%w: %x", chain.ErrPrunedBlock, block.Hash)
+		}
+
+		merkleBlock := MerkleBlockResult{Header: hex.EncodeToString(block.Header().Serialize())}
+
+		for i, tx := range block.Transactions {
+			if !p.Filter.MatchTxAndUpdate(tx) {
+				continue
+			}
+
+			proof, err := block.MerkleProof(i)
+			if err != nil {
+				return nil, err
+			}
+			merkleBlock.Matches = append(merkleBlock.Matches, MatchedTxResult{
+				TXID:  hex.EncodeToString(tx.TXid),
+				Data:  hex.EncodeToString(tx.Serialize()),
+				Proof: *proof,
+			})
+		}
+
+		result = append(result, merkleBlock)
+	}
+
+	return result, nil
+}
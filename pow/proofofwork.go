@@ -0,0 +1,194 @@
+package pow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/metrics"
+)
+
+var powLog = logging.Component(logging.PoW)
+
+var (
+	hashesTotal = metrics.NewCounter("btc_pow_hashes_total", "计算过的区块头哈希个数")
+	hashRate    = metrics.NewGauge("btc_pow_hashrate", "最近一次挖矿的算力，每秒计算的哈希个数")
+)
+
+//记录一次搜索计算的哈希个数和算力
+func recordHashes(count uint64, start time.Time) {
+	hashesTotal.Add(count)
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+		hashRate.Set(float64(count) / elapsed)
+	}
+}
+
+type ProofOfWork struct {
+	block *block.Block
+
+	target *big.Int
+}
+
+const Bits  = 16
+
+func NewProofOfWork(b *block.Block) *ProofOfWork {
+	pow := ProofOfWork{
+		block: b,
+	}
+
+	// 这里是固定的难度值
+	/*targetStr := "0001000000000000000000000000000000000000000000000000000000000000"
+	var bigIntTmp big.Int
+	bigIntTmp.SetString(targetStr, 16)
+	pow.target = &bigIntTmp*/
+
+	// 这里是推导的难度值,推导前导为3个难度值
+	// 	0001000000000000000000000000000000000000000000000000000000000000
+	// 初始化
+	//   0000000000000000000000000000000000000000000000000000000000000001
+	// 向左移动，256位
+	// 1 0000000000000000000000000000000000000000000000000000000000000000
+	// 向右移动，四次，一个16进制位代表4个2进制
+	// 向右移动16位
+
+	//难度值从区块头中读取，外部矿工只拿到区块头，也能算出相同的目标值
+	pow.target = targetFromBits(b.Difficulity)
+
+	return &pow
+}
+
+//难度值是目标值前导0的位数
+func targetFromBits(bits uint64) *big.Int {
+	bigIntTmp := big.NewInt(1)
+	//bigIntTmp.Lsh(bigIntTmp,256)
+	//bigIntTmp.Rsh(bigIntTmp,16)
+	if bits < 256 {
+		bigIntTmp.Lsh(bigIntTmp,uint(256-bits))
+	}
+
+	return bigIntTmp
+}
+
+//目标值，区块哈希必须小于它
+func (pow *ProofOfWork) Target() *big.Int {
+	return pow.target
+}
+
+//这是pow的运算方法，为了获取挖矿的随机数，同时返回区块的哈 希值
+func (pow *ProofOfWork) Run() ([]byte,uint64) {
+	//1. 获取block数据
+	//2. 拼接nonce
+	//3. sha256
+	//4. 与难度值比较
+		//哈希值大于难度值，nonce++
+		//哈希 值小于难度值，挖矿成功，退出
+	var nonce uint64
+	var hash [32]byte
+	start := time.Now()
+
+	for {
+		hash = sha256.Sum256(pow.PrepareData(nonce))
+
+		// 将hash(数组类型)转换成big.Int类型
+		var bigIntTmp big.Int
+		bigIntTmp.SetBytes(hash[:])
+
+		//	-1 if x < y
+		//	0 if x == y
+		//	1 if x > y
+		//	func (x *Int) Cmp(y *Int) (r int)
+		if bigIntTmp.Cmp(pow.target) == -1 {
+			powLog.Debug("挖矿成功", "nonce", nonce, logging.Hex("hash", hash[:]))
+			recordHashes(nonce+1, start)
+
+			break
+		} else {
+			nonce++
+		}
+	}
+
+	return hash[:],nonce
+}
+
+//在[start, start+count)范围内查找满足难度的nonce，找到时返回true
+//外部矿工分段调用，每段之间可以检查任务是否已经过期
+func (pow *ProofOfWork) Search(start, count uint64) ([]byte, uint64, bool) {
+	var bigIntTmp big.Int
+	begin := time.Now()
+
+	for nonce := start; nonce-start < count; nonce++ {
+		hash := sha256.Sum256(pow.PrepareData(nonce))
+
+		bigIntTmp.SetBytes(hash[:])
+		if bigIntTmp.Cmp(pow.target) == -1 {
+			recordHashes(nonce-start+1, begin)
+			return hash[:], nonce, true
+		}
+	}
+
+	recordHashes(count, begin)
+	return nil, 0, false
+}
+
+func (pow *ProofOfWork) PrepareData(nonce uint64) []byte {
+	b := pow.block
+
+	tmp := [][]byte{
+		uintToByte(b.Version),
+		b.PrevBlockHash,
+		b.MerkleRoot,
+		uintToByte(b.TimeStamp),
+		uintToByte(b.Difficulity),
+		uintToByte(nonce),
+	}
+
+	//更正： 比特币做哈希值，并不是对整个区块做哈希值，而是对区块头做哈希值
+
+	data := bytes.Join(tmp,[]byte{})
+	return data
+}
+
+func (pow *ProofOfWork) IsValid() bool {
+	//在校验的时候，block的数据是完整的，我们要做的是校验一下Hash,block数据，和Nonce是否满足难度值要求
+	//1. 获取block数据
+	//2. 拼接nonce
+	//3. sha256
+	//4. 与难度值比较
+
+	data := pow.PrepareData(pow.block.Nonce)
+	hash := sha256.Sum256(data)
+
+	var tmp big.Int
+	tmp.SetBytes(hash[:])
+
+	return tmp.Cmp(pow.target) == -1
+}
+
+//校验区块头：难度值正确、区块哈希是区块头的哈希、满足工作量证明
+//只用到区块头的字段，SPV客户端也用它校验下载的区块头
+func Check(b *block.Block) error {
+	if b.Difficulity != Bits {
+		return fmt.Errorf("难度值不正确: %d，应该是 %d", b.Difficulity, Bits)
+	}
+
+	pow := NewProofOfWork(b)
+	hash := sha256.Sum256(pow.PrepareData(b.Nonce))
+	if !pow.IsValid() || !bytes.Equal(b.Hash, hash[:]) {
+		return fmt.Errorf("工作量证明无效: nonce %d", b.Nonce)
+	}
+
+	return nil
+}
+
+//大端编码，定长8字节
+func uintToByte(num uint64) []byte {
+	var buffer [8]byte
+	binary.BigEndian.PutUint64(buffer[:], num)
+
+	return buffer[:]
+}
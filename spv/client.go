@@ -0,0 +1,621 @@
+package spv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ELOATS/btc/block"
+	"github.com/ELOATS/btc/chain"
+	"github.com/ELOATS/btc/filter"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/node"
+	"github.com/ELOATS/btc/pow"
+	"github.com/ELOATS/btc/tx"
+	"github.com/boltdb/bolt"
+)
+
+//SPV轻客户端：只下载区块头，校验工作量证明
+//用布隆过滤器向全节点请求与钱包地址相关的交易，用梅克尔证明确认交易确实在区块中
+//轻客户端的数据保存在单独的spv.db中，不需要blockChain.db
+
+var spvLog = logging.Component(logging.SPV)
+
+const spvDBName = "spv.db"
+
+//全节点和轻客户端不能同时打开同一个数据库文件，等待一段时间后放弃
+const dbOpenTimeout = 3 * time.Second
+
+const (
+	spvHeaderBucketName = "spvHeaderBucket" //区块哈希 -> 高度和区块头
+	spvHeightBucketName = "spvHeightBucket" //高度 -> 区块哈希
+	spvTxBucketName     = "spvTxBucket"     //交易id -> 所在区块和交易
+	spvTipKey           = "lastHeaderKey"
+	spvScannedKey       = "lastScannedKey" //已经用过滤器扫描到的区块
+	spvGenesisKey       = "genesisKey"     //固定的创世块哈希
+)
+
+const spvFalsePositiveRate = 0.001
+
+//轻客户端
+type SPVClient struct {
+	db     *bolt.DB
+	rpc    *node.RPCClient
+	tip     []byte //最后一个区块头的哈希
+	height  uint64 //最后一个区块头的高度
+	genesis []byte //固定的创世块哈希，还没有同步过并且没有指定时为空
+}
+
+//SPV客户端保存的交易
+type SPVTx struct {
+	Tx        *tx.Transaction
+	BlockHash []byte
+}
+
+//收到的付款
+type SPVPayment struct {
+	TXID          []byte
+	Index         int64
+	PubKeyHash    []byte
+	Value         float64
+	Height        uint64
+	Confirmations uint64
+	Spent         bool
+}
+
+func OpenSPVClient(nodeURL string) (*SPVClient, error) {
+	db, err := bolt.Open(spvDBName, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &SPVClient{db: db, rpc: node.NewRPCClient(nodeURL)}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{spvHeaderBucketName, spvHeightBucketName, spvTxBucketName} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		//bolt返回的数据只在事务内有效，需要拷贝
+		if tip := tx.Bucket([]byte(spvHeaderBucketName)).Get([]byte(spvTipKey)); tip != nil {
+			client.tip = append([]byte{}, tip...)
+			height, _, err := client.headerByHash(tx, client.tip)
+			if err != nil {
+				return err
+			}
+			client.height = height
+		}
+		if genesis := tx.Bucket([]byte(spvHeaderBucketName)).Get([]byte(spvGenesisKey)); genesis != nil {
+			client.genesis = append([]byte{}, genesis...)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (c *SPVClient) Close() {
+	c.db.Close()
+}
+
+//最后一个区块头的高度
+func (c *SPVClient) Height() uint64 {
+	return c.height
+}
+
+func encodeSPVHeader(height uint64, header *block.Block) []byte {
+	var e wire.Encoder
+	e.PutUvarint(height)
+	e.PutBytes(header.Serialize())
+	return e.Bytes()
+}
+
+func (c *SPVClient) headerByHash(tx *bolt.Tx, hash []byte) (uint64, *block.Block, error) {
+	data := tx.Bucket([]byte(spvHeaderBucketName)).Get(hash)
+	if data == nil {
+		return 0, nil, fmt.Errorf("%w: %x", chain.ErrUnknownBlock, hash)
+	}
+
+	d := wire.NewDecoder(data)
+	height := d.GetUvarint()
+	headerData := d.GetBytes()
+	if err := d.Finish(); err != nil {
+		return 0, nil, err
+	}
+
+	header, err := block.DecodeHeader(headerData)
+	return height, header, err
+}
+
+//高度使用大端编码，与全节点的区块索引一致
+func heightKey(height uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], height)
+	return key[:]
+}
+
+//固定创世块的哈希，之后只接受从这个创世块开始的链，必须在第一次同步之前调用
+//没有指定时第一次同步的创世块会被固定下来
+func (c *SPVClient) PinGenesis(hash []byte) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		headerBucket := tx.Bucket([]byte(spvHeaderBucketName))
+		if pinned := headerBucket.Get([]byte(spvGenesisKey)); pinned != nil && !bytes.Equal(pinned, hash) {
+			return fmt.Errorf("已经固定的创世块是 %x", pinned)
+		}
+		if err := headerBucket.Put([]byte(spvGenesisKey), hash); err != nil {
+			return err
+		}
+		c.genesis = append([]byte{}, hash...)
+		return nil
+	})
+}
+
+//区块定位器：从最后一个区块头开始，前10个逐个向前，之后步长加倍，最后是创世块
+func (c *SPVClient) locator(tx *bolt.Tx) []string {
+	if c.tip == nil {
+		return nil
+	}
+	heightBucket := tx.Bucket([]byte(spvHeightBucketName))
+
+	var locator []string
+	step := uint64(1)
+	for height := c.height; ; height -= step {
+		if hash := heightBucket.Get(heightKey(height)); hash != nil {
+			locator = append(locator, hex.EncodeToString(hash))
+		}
+		if height == 0 {
+			break
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		if height < step {
+			step = height
+		}
+	}
+	return locator
+}
+
+//下载并校验区块头，返回新增的区块头个数
+//1. 每个区块头都必须连接在上一个之后，满足工作量证明和时间戳规则(MTP和超前时间)
+//2. 创世块必须是固定的那个
+//3. 节点的链和本地分叉时，回退到分叉点，删除之后的区块头和其中匹配到的交易
+func (c *SPVClient) SyncHeaders() (int, error) {
+	count := 0
+
+	for {
+		var locator []string
+		_ = c.db.View(func(tx *bolt.Tx) error {
+			locator = c.locator(tx)
+			return nil
+		})
+
+		//旧版本的节点不认识Locator，仍然按照After返回
+		var result node.GetHeadersResult
+		params := node.GetHeadersParams{After: hex.EncodeToString(c.tip), Max: node.MaxHeadersPerRequest, Locator: locator}
+		if err := c.rpc.Call("getheaders", params, &result); err != nil {
+			return count, err
+		}
+		if len(result.Headers) == 0 {
+			return count, nil
+		}
+
+		var headers []*block.Block
+		for _, headerHex := range result.Headers {
+			data, err := hex.DecodeString(headerHex)
+			if err != nil {
+				return count, err
+			}
+			header, err := block.DecodeHeader(data)
+			if err != nil {
+				return count, err
+			}
+			headers = append(headers, header)
+		}
+
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			headerBucket := tx.Bucket([]byte(spvHeaderBucketName))
+			heightBucket := tx.Bucket([]byte(spvHeightBucketName))
+
+			tip, height := c.tip, c.height
+
+			//第一个区块头没有连接在链尾之后，说明节点的链从它的前一个区块分叉
+			if fork := headers[0].PrevBlockHash; !bytes.Equal(fork, tip) {
+				if len(fork) == 0 && c.genesis != nil && !bytes.Equal(headers[0].Hash, c.genesis) {
+					return fmt.Errorf("节点的创世块 %x 与固定的创世块 %x 不同", headers[0].Hash, c.genesis)
+				}
+				forkHeight, err := c.rollback(tx, fork)
+				if err != nil {
+					return err
+				}
+				tip, height = fork, forkHeight
+			}
+
+			headerOf := func(hash []byte) (*block.Block, error) {
+				_, header, err := c.headerByHash(tx, hash)
+				return header, err
+			}
+
+			for _, header := range headers {
+				if !bytes.Equal(header.PrevBlockHash, tip) {
+					return fmt.Errorf("区块头 %x 没有连接在 %x 之后", header.Hash, tip)
+				}
+				if err := pow.Check(header); err != nil {
+					return fmt.Errorf("区块头 %x: %v", header.Hash, err)
+				}
+				mtp, err := chain.MedianTimePast(headerOf, header.PrevBlockHash)
+				if err != nil {
+					return err
+				}
+				if err := chain.CheckHeaderTime(header, mtp); err != nil {
+					return fmt.Errorf("区块头 %x: %v", header.Hash, err)
+				}
+
+				if len(tip) == 0 {
+					//创世块，没有固定时固定下来
+					if c.genesis != nil && !bytes.Equal(header.Hash, c.genesis) {
+						return fmt.Errorf("节点的创世块 %x 与固定的创世块 %x 不同", header.Hash, c.genesis)
+					}
+					if err := headerBucket.Put([]byte(spvGenesisKey), header.Hash); err != nil {
+						return err
+					}
+				} else {
+					height++
+				}
+				tip = header.Hash
+
+				if err := headerBucket.Put(header.Hash, encodeSPVHeader(height, header)); err != nil {
+					return err
+				}
+				if err := heightBucket.Put(heightKey(height), header.Hash); err != nil {
+					return err
+				}
+			}
+
+			if err := headerBucket.Put([]byte(spvTipKey), tip); err != nil {
+				return err
+			}
+
+			c.tip, c.height = tip, height
+			if c.genesis == nil {
+				c.genesis = append([]byte{}, headerBucket.Get([]byte(spvGenesisKey))...)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+
+		count += len(headers)
+	}
+}
+
+//回退到分叉点fork，删除之后的区块头、其中匹配到的交易，扫描位置也退回到fork，返回fork的高度
+//fork必须在本地的主链上，为空时全部删除
+func (c *SPVClient) rollback(tx *bolt.Tx, fork []byte) (uint64, error) {
+	headerBucket := tx.Bucket([]byte(spvHeaderBucketName))
+	heightBucket := tx.Bucket([]byte(spvHeightBucketName))
+	txBucket := tx.Bucket([]byte(spvTxBucketName))
+
+	var forkHeight, from uint64
+	if len(fork) > 0 {
+		height, _, err := c.headerByHash(tx, fork)
+		if err != nil || !bytes.Equal(heightBucket.Get(heightKey(height)), fork) {
+			return 0, fmt.Errorf("节点的链从 %x 分叉，这个区块不在本地的链上", fork)
+		}
+		forkHeight, from = height, height+1
+	}
+
+	removed := make(map[string]bool)
+	for height := from; len(c.tip) > 0 && height <= c.height; height++ {
+		hash := append([]byte{}, heightBucket.Get(heightKey(height))...)
+		removed[string(hash)] = true
+		if err := heightBucket.Delete(heightKey(height)); err != nil {
+			return 0, err
+		}
+		if err := headerBucket.Delete(hash); err != nil {
+			return 0, err
+		}
+	}
+
+	//ForEach的过程中不能修改bucket，先找出需要删除的交易
+	var dropped [][]byte
+	err := txBucket.ForEach(func(k, v []byte) error {
+		if string(k) == spvScannedKey {
+			return nil
+		}
+		d := wire.NewDecoder(v)
+		if removed[string(d.GetBytes())] {
+			dropped = append(dropped, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, txid := range dropped {
+		if err := txBucket.Delete(txid); err != nil {
+			return 0, err
+		}
+	}
+
+	if scanned := txBucket.Get([]byte(spvScannedKey)); scanned != nil && removed[string(scanned)] {
+		if len(fork) > 0 {
+			err = txBucket.Put([]byte(spvScannedKey), fork)
+		} else {
+			err = txBucket.Delete([]byte(spvScannedKey))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	spvLog.Warn("节点的链与本地分叉，回退区块头", logging.Hex("fork", fork), "height", forkHeight, "removed", len(removed), "droppedTxs", len(dropped))
+	return forkHeight, nil
+}
+
+//用布隆过滤器扫描已经同步了区块头的区块，保存与钱包相关的交易，返回新找到的交易个数
+//全节点返回的每个交易都要校验梅克尔证明，并且再次确认确实与钱包相关(过滤器有误判)
+func (c *SPVClient) ScanWallet(pubKeyHashes [][]byte) (int, error) {
+	var tweak [4]byte
+	_, _ = rand.Read(tweak[:])
+
+	bloom := filter.NewBloomFilter(len(pubKeyHashes), spvFalsePositiveRate, binary.LittleEndian.Uint32(tweak[:]))
+	for _, pubKeyHash := range pubKeyHashes {
+		bloom.Add(pubKeyHash)
+	}
+
+	var scanned []byte
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(spvTxBucketName)).Get([]byte(spvScannedKey)); v != nil {
+			scanned = append([]byte{}, v...)
+		}
+		return nil
+	})
+
+	//已经保存的交易中属于我的output，用来识别花费它们的交易
+	mine := make(map[string]bool)
+	for _, stx := range c.Transactions() {
+		for i, output := range stx.Tx.TXOutputs {
+			if containsBytes(pubKeyHashes, output.PubKeyHash) {
+				mine[outpointKey(stx.Tx.TXid, int64(i))] = true
+				bloom.Add(filter.OutpointBytes(stx.Tx.TXid, int64(i)))
+			}
+		}
+	}
+
+	found := 0
+	for !bytes.Equal(scanned, c.tip) {
+		var result []node.MerkleBlockResult
+		params := node.GetMerkleBlocksParams{Filter: *bloom, After: hex.EncodeToString(scanned), Max: node.MaxMerkleBlocksPerRequest}
+		if err := c.rpc.Call("getmerkleblocks", params, &result); err != nil {
+			return found, err
+		}
+		if len(result) == 0 {
+			break
+		}
+
+		err := c.db.Update(func(btx *bolt.Tx) error {
+			txBucket := btx.Bucket([]byte(spvTxBucketName))
+
+			for _, merkleBlock := range result {
+				data, err := hex.DecodeString(merkleBlock.Header)
+				if err != nil {
+					return err
+				}
+				received, err := block.DecodeHeader(data)
+				if err != nil {
+					return err
+				}
+
+				//只接受已经同步并校验过的区块头，区块头必须完全一致
+				_, header, err := c.headerByHash(btx, received.Hash)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(header.Serialize(), received.Serialize()) {
+					return fmt.Errorf("区块头 %x 与本地保存的不一致", received.Hash)
+				}
+
+				for _, match := range merkleBlock.Matches {
+					tx, err := c.checkMatch(header, match)
+					if err != nil {
+						return err
+					}
+
+					if !isWalletTx(tx, pubKeyHashes, mine) {
+						continue
+					}
+					for i, output := range tx.TXOutputs {
+						if containsBytes(pubKeyHashes, output.PubKeyHash) {
+							mine[outpointKey(tx.TXid, int64(i))] = true
+							bloom.Add(filter.OutpointBytes(tx.TXid, int64(i)))
+						}
+					}
+
+					var e wire.Encoder
+					e.PutBytes(header.Hash)
+					e.PutBytes(tx.Serialize())
+					if err := txBucket.Put(tx.TXid, e.Bytes()); err != nil {
+						return err
+					}
+					found++
+				}
+
+				scanned = header.Hash
+
+				//全节点可能已经有了更新的区块，超出本地区块头的部分下次同步之后再扫描
+				if bytes.Equal(scanned, c.tip) {
+					break
+				}
+			}
+
+			return txBucket.Put([]byte(spvScannedKey), scanned)
+		})
+		if err != nil {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+//校验全节点返回的交易：交易id与内容一致，梅克尔证明能推出区块头中的梅克尔根
+func (c *SPVClient) checkMatch(header *block.Block, match node.MatchedTxResult) (*tx.Transaction, error) {
+	txid, err := hex.DecodeString(match.TXID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(match.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := tx.DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+
+	//版本0的交易是迁移过来的，id是旧的gob哈希，解码时不会计算，按旧的方法检查
+	if tx.Version == 0 {
+		tx.TXid = txid
+		if err := tx.CheckTXID(); err != nil {
+			return nil, err
+		}
+	} else if !bytes.Equal(tx.TXid, txid) {
+		return nil, fmt.Errorf("交易 %x 的内容与id不符", txid)
+	}
+
+	if !match.Proof.Verify(header, txid) {
+		return nil, fmt.Errorf("交易 %x 的梅克尔证明无效", txid)
+	}
+
+	return tx, nil
+}
+
+//交易是否与钱包相关：付款给我，或者花费了我的output
+func isWalletTx(tx *tx.Transaction, pubKeyHashes [][]byte, mine map[string]bool) bool {
+	for _, output := range tx.TXOutputs {
+		if containsBytes(pubKeyHashes, output.PubKeyHash) {
+			return true
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return false
+	}
+
+	for _, input := range tx.TXInputs {
+		if mine[outpointKey(input.TXID, input.Index)] {
+			return true
+		}
+	}
+	return false
+}
+
+//output定位的字符串形式，用作map的key
+func outpointKey(txid []byte, index int64) string {
+	return fmt.Sprintf("%s:%d", txid, index)
+}
+
+func containsBytes(list [][]byte, item []byte) bool {
+	for _, b := range list {
+		if bytes.Equal(b, item) {
+			return true
+		}
+	}
+	return false
+}
+
+//读取保存的所有交易
+func (c *SPVClient) Transactions() []SPVTx {
+	var txs []SPVTx
+
+	_ = c.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket([]byte(spvTxBucketName)).ForEach(func(k, v []byte) error {
+			if string(k) == spvScannedKey {
+				return nil
+			}
+
+			d := wire.NewDecoder(v)
+			blockHash := d.GetBytes()
+			data := d.GetBytes()
+			if err := d.Finish(); err != nil {
+				spvLog.Warn("交易解码失败，忽略", logging.Hex("txid", k), "err", err)
+				return nil
+			}
+
+			tx, err := tx.DeserializeTransaction(data)
+			if err != nil {
+				spvLog.Warn("交易解码失败，忽略", logging.Hex("txid", k), "err", err)
+				return nil
+			}
+			tx.TXid = append([]byte{}, k...)
+
+			txs = append(txs, SPVTx{Tx: tx, BlockHash: blockHash})
+			return nil
+		})
+	})
+
+	return txs
+}
+
+//付给钱包地址的所有output，以及确认数和是否已经被花费
+func (c *SPVClient) Payments(pubKeyHashes [][]byte) []SPVPayment {
+	txs := c.Transactions()
+
+	spent := make(map[string]bool)
+	for _, stx := range txs {
+		if stx.Tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range stx.Tx.TXInputs {
+			spent[outpointKey(input.TXID, input.Index)] = true
+		}
+	}
+
+	var payments []SPVPayment
+
+	_ = c.db.View(func(btx *bolt.Tx) error {
+		for _, stx := range txs {
+			height, _, err := c.headerByHash(btx, stx.BlockHash)
+			if err != nil {
+				continue
+			}
+
+			for i, output := range stx.Tx.TXOutputs {
+				if output.IsDataOutput() || !containsBytes(pubKeyHashes, output.PubKeyHash) {
+					continue
+				}
+
+				payments = append(payments, SPVPayment{
+					TXID:          stx.Tx.TXid,
+					Index:         int64(i),
+					PubKeyHash:    output.PubKeyHash,
+					Value:         output.Value,
+					Height:        height,
+					Confirmations: c.height - height + 1,
+					Spent:         spent[outpointKey(stx.Tx.TXid, int64(i))],
+				})
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].Height < payments[j].Height
+	})
+
+	return payments
+}
@@ -2,5 +2,5 @@
 rm -f *.db
 rm -f blockchain
 
-go build -o blockchain *.go
+go build -o blockchain ./cmd/blockchain
 ./blockchain
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+//基于bolt的实现，bucket直接对应bolt的bucket
+type boltStore struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string, timeout time.Duration) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(Tx) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return fn(boltTx{btx})
+	})
+}
+
+func (s *boltStore) Update(fn func(Tx) error) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return fn(boltTx{btx})
+	})
+}
+
+func (s *boltStore) Size() (int64, error) {
+	var size int64
+	err := s.db.View(func(btx *bolt.Tx) error {
+		size = btx.Size()
+		return nil
+	})
+	return size, err
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+//bucket不存在时要返回nil接口，不能返回包着nil指针的接口
+func (t boltTx) Bucket(name []byte) Bucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return b
+}
+
+func (t boltTx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err == bolt.ErrBucketExists {
+		return nil, ErrBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	err := t.tx.DeleteBucket(name)
+	if err == bolt.ErrBucketNotFound {
+		return ErrBucketNotFound
+	}
+	return err
+}
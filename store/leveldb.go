@@ -0,0 +1,241 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//基于LevelDB的实现，LevelDB没有bucket，用key的前缀区分：
+//  0x00 + name              bucket存在的标记
+//  0x01 + name + 0x00 + key bucket中的数据
+//bucket的名字中不能有0x00
+const (
+	levelBucketPrefix = 0x00
+	levelDataPrefix   = 0x01
+)
+
+//LevelDB打开被占用的数据库时直接失败，每隔一段时间重试一次
+const levelLockRetry = 100 * time.Millisecond
+
+type levelStore struct {
+	db   *leveldb.DB
+	path string
+}
+
+func OpenLevelDB(path string, timeout time.Duration) (Store, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		db, err := leveldb.OpenFile(path, nil)
+		if err == nil {
+			return &levelStore{db, path}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, err
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+		time.Sleep(levelLockRetry)
+	}
+}
+
+//View在快照上执行，看不到之后提交的写入
+func (s *levelStore) View(fn func(Tx) error) error {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	t := &levelTx{reader: snap}
+	if err := fn(t); err != nil {
+		return err
+	}
+	return t.err
+}
+
+//Update在LevelDB的事务中执行，事务中的读可以看到自己的写入
+func (s *levelStore) Update(fn func(Tx) error) error {
+	tr, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+
+	t := &levelTx{reader: tr, writer: tr}
+	if err := fn(t); err != nil {
+		tr.Discard()
+		return err
+	}
+	//读出错时事务看到的数据不完整，不能提交
+	if t.err != nil {
+		tr.Discard()
+		return t.err
+	}
+
+	return tr.Commit()
+}
+
+//数据库目录中所有文件的大小之和
+func (s *levelStore) Size() (int64, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+func (s *levelStore) Close() error {
+	return s.db.Close()
+}
+
+//快照和事务共有的读接口
+type levelReader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+type levelTx struct {
+	reader levelReader
+	writer *leveldb.Transaction //只读事务为nil
+	err    error                //第一个读错误，View和Update结束时返回
+}
+
+//读取一个key，ok表示key是否存在
+//只有ErrNotFound表示不存在，其他错误不能当成不存在：Bucket.Get没有返回错误的位置，错误记录在事务中，由View和Update返回
+func (t *levelTx) get(key []byte) (value []byte, ok bool) {
+	value, err := t.reader.Get(key, nil)
+	if err == nil {
+		return value, true
+	}
+	if !errors.Is(err, leveldb.ErrNotFound) && t.err == nil {
+		t.err = fmt.Errorf("读取数据库失败: %w", err)
+	}
+	return nil, false
+}
+
+func bucketKey(name []byte) []byte {
+	return append([]byte{levelBucketPrefix}, name...)
+}
+
+func dataPrefix(name []byte) []byte {
+	prefix := append([]byte{levelDataPrefix}, name...)
+	return append(prefix, 0x00)
+}
+
+func (t *levelTx) hasBucket(name []byte) bool {
+	_, ok := t.get(bucketKey(name))
+	return ok
+}
+
+func (t *levelTx) Bucket(name []byte) Bucket {
+	if !t.hasBucket(name) {
+		return nil
+	}
+	return &levelBucket{t, dataPrefix(name)}
+}
+
+func (t *levelTx) CreateBucket(name []byte) (Bucket, error) {
+	if t.hasBucket(name) {
+		return nil, ErrBucketExists
+	}
+	return t.CreateBucketIfNotExists(name)
+}
+
+func (t *levelTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if t.writer == nil {
+		return nil, errReadOnly
+	}
+	if !t.hasBucket(name) {
+		if err := t.writer.Put(bucketKey(name), nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &levelBucket{t, dataPrefix(name)}, nil
+}
+
+func (t *levelTx) DeleteBucket(name []byte) error {
+	if t.writer == nil {
+		return errReadOnly
+	}
+	if !t.hasBucket(name) {
+		return ErrBucketNotFound
+	}
+
+	//先把key收集起来，遍历的过程中不修改数据
+	var keys [][]byte
+	it := t.reader.NewIterator(util.BytesPrefix(dataPrefix(name)), nil)
+	for it.Next() {
+		keys = append(keys, append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := t.writer.Delete(key, nil); err != nil {
+			return err
+		}
+	}
+	return t.writer.Delete(bucketKey(name), nil)
+}
+
+type levelBucket struct {
+	tx     *levelTx
+	prefix []byte
+}
+
+func (b *levelBucket) key(key []byte) []byte {
+	return append(append([]byte{}, b.prefix...), key...)
+}
+
+func (b *levelBucket) Get(key []byte) []byte {
+	value, _ := b.tx.get(b.key(key))
+	return value
+}
+
+func (b *levelBucket) Put(key, value []byte) error {
+	if b.tx.writer == nil {
+		return errReadOnly
+	}
+	return b.tx.writer.Put(b.key(key), value, nil)
+}
+
+func (b *levelBucket) Delete(key []byte) error {
+	if b.tx.writer == nil {
+		return errReadOnly
+	}
+	return b.tx.writer.Delete(b.key(key), nil)
+}
+
+func (b *levelBucket) ForEach(fn func(k, v []byte) error) error {
+	it := b.tx.reader.NewIterator(util.BytesPrefix(b.prefix), nil)
+	defer it.Release()
+
+	for it.Next() {
+		if err := fn(it.Key()[len(b.prefix):], it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
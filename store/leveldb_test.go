@@ -0,0 +1,55 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//读数据时返回指定错误的快照
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	return nil, r.err
+}
+
+func (r failingReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return iterator.NewEmptyIterator(r.err)
+}
+
+//只有ErrNotFound表示key不存在，其他读错误必须由事务返回
+func TestLevelReadErrors(t *testing.T) {
+	errDisk := errors.New("disk error")
+
+	tests := []struct {
+		name    string
+		readErr error
+		wantErr error
+	}{
+		{"not found", leveldb.ErrNotFound, nil},
+		{"disk error", errDisk, errDisk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &levelTx{reader: failingReader{tt.readErr}}
+			bucket := &levelBucket{tx, dataPrefix([]byte("b"))}
+
+			if v := bucket.Get([]byte("k")); v != nil {
+				t.Fatalf("Get = %q, want nil", v)
+			}
+			if tx.Bucket([]byte("b")) != nil {
+				t.Fatal("Bucket is not nil")
+			}
+			if !errors.Is(tx.err, tt.wantErr) || (tt.wantErr == nil) != (tx.err == nil) {
+				t.Fatalf("transaction error = %v, want %v", tx.err, tt.wantErr)
+			}
+		})
+	}
+}
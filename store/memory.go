@@ -0,0 +1,177 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+//内存中的实现，不落盘，用于测试
+//Update在数据的副本上执行，fn成功后才替换原来的数据，所以失败的事务不会留下部分写入
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemory() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+//和Open的其他后端对应的名字，测试中用来创建一个空的内存数据库
+func OpenMemory() Store {
+	return NewMemory()
+}
+
+func (s *memoryStore) View(fn func(Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{buckets: s.buckets})
+}
+
+func (s *memoryStore) Update(fn func(Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//bucket在第一次被写入时才复制
+	t := &memoryTx{
+		buckets:  make(map[string]map[string][]byte, len(s.buckets)),
+		writable: true,
+		copied:   make(map[string]bool),
+	}
+	for name, b := range s.buckets {
+		t.buckets[name] = b
+	}
+
+	if err := fn(t); err != nil {
+		return err
+	}
+
+	s.buckets = t.buckets
+	return nil
+}
+
+func (s *memoryStore) Size() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var size int64
+	for name, b := range s.buckets {
+		size += int64(len(name))
+		for k, v := range b {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+type memoryTx struct {
+	buckets  map[string]map[string][]byte
+	writable bool
+	copied   map[string]bool
+}
+
+func (t *memoryTx) Bucket(name []byte) Bucket {
+	if _, ok := t.buckets[string(name)]; !ok {
+		return nil
+	}
+	return &memoryBucket{t, string(name)}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (Bucket, error) {
+	if _, ok := t.buckets[string(name)]; ok {
+		return nil, ErrBucketExists
+	}
+	return t.CreateBucketIfNotExists(name)
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, errReadOnly
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		t.buckets[string(name)] = make(map[string][]byte)
+		t.copied[string(name)] = true
+	}
+	return &memoryBucket{t, string(name)}, nil
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		return ErrBucketNotFound
+	}
+	delete(t.buckets, string(name))
+	delete(t.copied, string(name))
+	return nil
+}
+
+//返回可以修改的bucket，已经提交的数据不能原地修改
+func (t *memoryTx) writableBucket(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, errReadOnly
+	}
+	b, ok := t.buckets[name]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !t.copied[name] {
+		clone := make(map[string][]byte, len(b))
+		for k, v := range b {
+			clone[k] = v
+		}
+		t.buckets[name] = clone
+		t.copied[name] = true
+		b = clone
+	}
+	return b, nil
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	return b.tx.buckets[b.name][string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	data, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	data, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	delete(data, string(key))
+	return nil
+}
+
+func (b *memoryBucket) ForEach(fn func(k, v []byte) error) error {
+	data := b.tx.buckets[b.name]
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn([]byte(k), data[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//Package store 是区块链的存储层，把区块、链尾、索引和UTXO集合保存在按bucket划分的键值数据库中
+//链的代码只依赖这里的接口，底层可以是bolt、LevelDB，测试时也可以使用内存数据库
+//
+//这里只提供通用的bucket键值接口，区块、链尾和索引的类型化读写在chain包中(chain/blockstore.go、chain/chainstate.go)，
+//因为它们依赖区块和交易的编码。批量写入就是一个Update：fn中的所有写入一起提交或者一起丢弃
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//支持的存储后端
+const (
+	Bolt    = "bolt"
+	LevelDB = "leveldb"
+	Memory  = "memory"
+)
+
+var (
+	ErrLocked         = errors.New("数据库被其他进程占用")
+	ErrBucketExists   = errors.New("bucket已经存在")
+	ErrBucketNotFound = errors.New("bucket不存在")
+	ErrUnknownBackend = errors.New("未知的存储后端")
+
+	errReadOnly = errors.New("只读事务不能修改数据")
+)
+
+//Store 是一个支持事务的键值数据库
+//View中的读操作看到的是一致的快照，Update中的写操作在fn返回nil时原子地提交，返回错误时全部丢弃
+type Store interface {
+	View(fn func(Tx) error) error
+	Update(fn func(Tx) error) error
+	//数据库占用的空间，单位是字节
+	Size() (int64, error)
+	Close() error
+}
+
+//Tx 是View或者Update中的事务，只在fn执行期间有效
+type Tx interface {
+	//bucket不存在时返回nil
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+//Bucket 是事务中的一个键值空间
+//Get和ForEach返回的切片只在事务内有效，需要在事务外使用时要复制一份
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	//按key的字节序遍历，遍历过程中不能修改bucket
+	ForEach(fn func(k, v []byte) error) error
+}
+
+//打开path处的数据库，不存在时创建
+//数据库被其他进程占用时最多等待timeout，超时返回ErrLocked，timeout为0表示一直等待
+func Open(backend, path string, timeout time.Duration) (Store, error) {
+	switch backend {
+	case Bolt:
+		return OpenBolt(path, timeout)
+	case LevelDB:
+		return OpenLevelDB(path, timeout)
+	case Memory:
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backend)
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//每个后端都要满足相同的语义
+func openTestStores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	dir := t.TempDir()
+	stores := map[string]Store{Memory: OpenMemory()}
+	for _, backend := range []string{Bolt, LevelDB} {
+		s, err := Open(backend, filepath.Join(dir, backend), 0)
+		if err != nil {
+			t.Fatalf("Open %s: %v", backend, err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[backend] = s
+	}
+	return stores
+}
+
+func TestBucketRoundTrip(t *testing.T) {
+	bucket := []byte("bucket")
+
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"simple", "a", "1"},
+		{"binary key", "\x00\x01\xff", "2"},
+		{"long value", "b", strings.Repeat("x", 1<<16)},
+		{"overwrite", "a", "3"},
+	}
+
+	for backend, s := range openTestStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			err := s.Update(func(tx Tx) error {
+				_, err := tx.CreateBucket(bucket)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := make(map[string]string)
+			for _, tt := range tests {
+				err := s.Update(func(tx Tx) error {
+					return tx.Bucket(bucket).Put([]byte(tt.key), []byte(tt.value))
+				})
+				if err != nil {
+					t.Fatalf("%s: Put: %v", tt.name, err)
+				}
+				want[tt.key] = tt.value
+
+				err = s.View(func(tx Tx) error {
+					if got := string(tx.Bucket(bucket).Get([]byte(tt.key))); got != tt.value {
+						t.Fatalf("%s: Get = %q, want %q", tt.name, got, tt.value)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			//按key的字节序遍历
+			var keys []string
+			err = s.View(func(tx Tx) error {
+				return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+					if want[string(k)] != string(v) {
+						t.Fatalf("ForEach %q = %q, want %q", k, v, want[string(k)])
+					}
+					keys = append(keys, string(k))
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(keys, ",") != "\x00\x01\xff,a,b" {
+				t.Fatalf("ForEach keys = %q", keys)
+			}
+
+			err = s.Update(func(tx Tx) error {
+				return tx.Bucket(bucket).Delete([]byte("a"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = s.View(func(tx Tx) error {
+				if v := tx.Bucket(bucket).Get([]byte("a")); v != nil {
+					t.Fatalf("Get after Delete = %q", v)
+				}
+				if v := tx.Bucket(bucket).Get([]byte("missing")); v != nil {
+					t.Fatalf("Get missing = %q", v)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+//fn返回错误时Update中的写入全部丢弃
+func TestUpdateAtomic(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	for backend, s := range openTestStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			err := s.Update(func(tx Tx) error {
+				b, err := tx.CreateBucket([]byte("kept"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("k"), []byte("old"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Update(func(tx Tx) error {
+				if err := tx.Bucket([]byte("kept")).Put([]byte("k"), []byte("new")); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket([]byte("dropped")); err != nil {
+					return err
+				}
+				//事务内可以看到自己的写入
+				if v := tx.Bucket([]byte("kept")).Get([]byte("k")); string(v) != "new" {
+					t.Fatalf("Get inside Update = %q", v)
+				}
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("Update = %v, want errAbort", err)
+			}
+
+			_ = s.View(func(tx Tx) error {
+				if v := tx.Bucket([]byte("kept")).Get([]byte("k")); string(v) != "old" {
+					t.Fatalf("Get after rollback = %q, want old", v)
+				}
+				if tx.Bucket([]byte("dropped")) != nil {
+					t.Fatal("bucket created in a failed Update exists")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestBucketErrors(t *testing.T) {
+	for backend, s := range openTestStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			err := s.Update(func(tx Tx) error {
+				if tx.Bucket([]byte("b")) != nil {
+					t.Fatal("missing bucket is not nil")
+				}
+				if err := tx.DeleteBucket([]byte("b")); !errors.Is(err, ErrBucketNotFound) {
+					t.Fatalf("DeleteBucket = %v, want ErrBucketNotFound", err)
+				}
+				if _, err := tx.CreateBucket([]byte("b")); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket([]byte("b")); !errors.Is(err, ErrBucketExists) {
+					t.Fatalf("CreateBucket = %v, want ErrBucketExists", err)
+				}
+				if _, err := tx.CreateBucketIfNotExists([]byte("b")); err != nil {
+					t.Fatalf("CreateBucketIfNotExists: %v", err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//只读事务不能修改数据
+			_ = s.View(func(tx Tx) error {
+				if err := tx.Bucket([]byte("b")).Put([]byte("k"), []byte("v")); err == nil {
+					t.Fatal("Put in View succeeded")
+				}
+				if _, err := tx.CreateBucket([]byte("c")); err == nil {
+					t.Fatal("CreateBucket in View succeeded")
+				}
+				return nil
+			})
+
+			err = s.Update(func(tx Tx) error {
+				return tx.DeleteBucket([]byte("b"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = s.View(func(tx Tx) error {
+				if tx.Bucket([]byte("b")) != nil {
+					t.Fatal("deleted bucket exists")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := Open("pebble", t.TempDir(), 0); !errors.Is(err, ErrUnknownBackend) {
+		t.Fatalf("Open = %v, want ErrUnknownBackend", err)
+	}
+}
@@ -0,0 +1,9 @@
+package tx
+
+import "errors"
+
+//交易相关的错误，返回时用fmt.Errorf("%w: ...")附带上下文
+var (
+	ErrInvalidTransaction = errors.New("无效的交易")
+	ErrMissingPrevTx      = errors.New("引用的交易不存在")
+)
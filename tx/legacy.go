@@ -0,0 +1,100 @@
+package tx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ELOATS/btc/internal/legacytx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//版本0的交易是从gob数据库迁移过来的，交易id和签名都按旧版本的方法计算：
+//1. 交易id是还没有签名时整个交易的gob哈希，不包含签名
+//2. 签名的数据是裁剪副本的gob哈希：所有input的签名和公钥清空，正在签名的input的公钥换成引用的output的公钥哈希，
+//   每算完一个input都会用结果覆盖副本的TXid，所以后面的input签名的数据包含前面的结果
+//3. 签名和公钥都是两个大整数的字节直接拼接，见wallet.VerifyLegacySignature
+
+//转换为旧的交易结构，withSignatures为false时清空签名
+func (tx *Transaction) legacyCopy(withSignatures bool) legacytx.Transaction {
+	legacy := legacytx.Transaction{TXid: tx.TXid}
+	for _, input := range tx.TXInputs {
+		in := legacytx.TXInput{TXID: input.TXID, Index: input.Index, PubKey: input.PubKey}
+		if withSignatures {
+			in.Signature = input.Signature
+		}
+		legacy.TXInputs = append(legacy.TXInputs, in)
+	}
+	for _, output := range tx.TXOutputs {
+		legacy.TXOutputs = append(legacy.TXOutputs, legacytx.TXOutput{Value: output.Value, PubKeyHash: output.PubKeyHash})
+	}
+	return legacy
+}
+
+//旧版本的交易id
+func (tx *Transaction) LegacyTXID() ([]byte, error) {
+	legacy := tx.legacyCopy(false)
+	legacy.TXid = nil
+	return legacy.Hash()
+}
+
+//交易id是否正确，版本0的交易使用旧的gob哈希
+func (tx *Transaction) CheckTXID() error {
+	var expected []byte
+	if tx.Version == 0 {
+		hash, err := tx.LegacyTXID()
+		if err != nil {
+			return tx.invalid(-1, err.Error())
+		}
+		expected = hash
+	} else {
+		hash := sha256.Sum256(tx.Serialize())
+		expected = hash[:]
+	}
+
+	if !bytes.Equal(tx.TXid, expected) {
+		return fmt.Errorf("%w: 交易id %x 应该是 %x", ErrInvalidTransaction, tx.TXid, expected)
+	}
+	return nil
+}
+
+//按旧版本的方法校验版本0交易的签名，旧交易不能有数据output，已经由CheckDataOutputs检查
+func (tx *Transaction) verifyLegacy(prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	txCopy := tx.legacyCopy(false)
+	for i := range txCopy.TXInputs {
+		txCopy.TXInputs[i].PubKey = nil
+	}
+
+	for i, input := range tx.TXInputs {
+		prevTX, ok := prevTXs[string(input.TXID)]
+		if !ok || input.Index < 0 || input.Index >= int64(len(prevTX.TXOutputs)) {
+			return tx.invalid(i, "引用的output不存在")
+		}
+		output := prevTX.TXOutputs[input.Index]
+
+		if output.IsDataOutput() {
+			return tx.invalid(i, "引用的是数据output，不可花费")
+		}
+		if !bytes.Equal(wallet.HashPubKey(input.PubKey), output.PubKeyHash) {
+			return tx.invalid(i, "公钥与output的锁定脚本不匹配")
+		}
+
+		txCopy.TXInputs[i].PubKey = output.PubKeyHash
+		verifyData, err := txCopy.Hash()
+		if err != nil {
+			return tx.invalid(i, err.Error())
+		}
+		txCopy.TXid = verifyData
+		txCopy.TXInputs[i].PubKey = nil
+
+		if err := wallet.VerifyLegacySignature(input.PubKey, verifyData, input.Signature); err != nil {
+			return tx.invalid(i, "签名无效: "+err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package tx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/ELOATS/btc/internal/legacytx"
+	"github.com/ELOATS/btc/wallet"
+)
+
+//按迁移之前的代码创建并签名一个交易：先算交易id，再对裁剪副本逐个input签名
+func newLegacyTx(t *testing.T, key *ecdsa.PrivateKey, prev *Transaction, inputs int) *Transaction {
+	t.Helper()
+
+	pubKey := append(key.PublicKey.X.Bytes(), key.PublicKey.Y.Bytes()...)
+	legacy := legacytx.Transaction{}
+	for i := 0; i < inputs; i++ {
+		legacy.TXInputs = append(legacy.TXInputs, legacytx.TXInput{TXID: prev.TXid, Index: int64(i), PubKey: pubKey})
+	}
+	legacy.TXOutputs = []legacytx.TXOutput{{Value: 1, PubKeyHash: wallet.HashPubKey(pubKey)}}
+
+	txid, err := legacy.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.TXid = txid
+
+	txCopy := legacytx.Transaction{TXid: legacy.TXid, TXOutputs: legacy.TXOutputs}
+	for _, input := range legacy.TXInputs {
+		txCopy.TXInputs = append(txCopy.TXInputs, legacytx.TXInput{TXID: input.TXID, Index: input.Index})
+	}
+	for i, input := range txCopy.TXInputs {
+		txCopy.TXInputs[i].PubKey = prev.TXOutputs[input.Index].PubKeyHash
+		signData, err := txCopy.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txCopy.TXid = signData
+		txCopy.TXInputs[i].PubKey = nil
+
+		//旧的编码从中间切开，r和s的字节数不同时旧版本自己也校验不过，这样的交易不会被打包
+		for {
+			r, s, err := ecdsa.Sign(rand.Reader, key, signData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Bytes()) == len(s.Bytes()) {
+				legacy.TXInputs[i].Signature = append(r.Bytes(), s.Bytes()...)
+				break
+			}
+		}
+	}
+
+	//迁移时gob解码为现在的结构，版本为0
+	migrated := &Transaction{TXid: legacy.TXid}
+	for _, input := range legacy.TXInputs {
+		migrated.TXInputs = append(migrated.TXInputs, TXInput{TXID: input.TXID, Index: input.Index, Signature: input.Signature, PubKey: input.PubKey})
+	}
+	for _, output := range legacy.TXOutputs {
+		migrated.TXOutputs = append(migrated.TXOutputs, TXOutput{Value: output.Value, PubKeyHash: output.PubKeyHash})
+	}
+	return migrated
+}
+
+//X和Y都是32字节的秘钥，和签名一样，旧的公钥编码从中间切开
+func newLegacyKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	for {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(key.PublicKey.X.Bytes()) == 32 && len(key.PublicKey.Y.Bytes()) == 32 {
+			return key
+		}
+	}
+}
+
+func TestLegacyVerify(t *testing.T) {
+	key := newLegacyKey(t)
+	pubKeyHash := wallet.HashPubKey(append(key.PublicKey.X.Bytes(), key.PublicKey.Y.Bytes()...))
+	prev := &Transaction{TXid: []byte("prev"), TXOutputs: []TXOutput{{Value: 2, PubKeyHash: pubKeyHash}, {Value: 3, PubKeyHash: pubKeyHash}}}
+
+	tests := []struct {
+		name   string
+		inputs int
+		tamper func(tx *Transaction)
+		ok     bool
+	}{
+		{"one input", 1, nil, true},
+		{"two inputs", 2, nil, true},
+		{"changed output", 1, func(tx *Transaction) { tx.TXOutputs[0].Value = 5 }, false},
+		{"changed signature", 2, func(tx *Transaction) { tx.TXInputs[1].Signature[0] ^= 1 }, false},
+		{"truncated signature", 1, func(tx *Transaction) { tx.TXInputs[0].Signature = tx.TXInputs[0].Signature[1:] }, false},
+		{"data output", 1, func(tx *Transaction) { tx.TXOutputs = append(tx.TXOutputs, TXOutput{Data: []byte("x")}) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated := newLegacyTx(t, key, prev, tt.inputs)
+			if tt.tamper != nil {
+				tt.tamper(migrated)
+			}
+
+			err := migrated.Verify(map[string]Transaction{string(prev.TXid): *prev})
+			if tt.ok && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidTransaction) {
+				t.Fatalf("Verify = %v, want ErrInvalidTransaction", err)
+			}
+		})
+	}
+}
+
+func TestLegacyTXID(t *testing.T) {
+	key := newLegacyKey(t)
+	pubKeyHash := wallet.HashPubKey(append(key.PublicKey.X.Bytes(), key.PublicKey.Y.Bytes()...))
+	prev := &Transaction{TXid: []byte("prev"), TXOutputs: []TXOutput{{Value: 2, PubKeyHash: pubKeyHash}}}
+
+	migrated := newLegacyTx(t, key, prev, 1)
+	if err := migrated.CheckTXID(); err != nil {
+		t.Fatalf("CheckTXID: %v", err)
+	}
+
+	//旧的交易id不包含签名，改签名不改变id，但改output会
+	migrated.TXInputs[0].Signature = nil
+	if err := migrated.CheckTXID(); err != nil {
+		t.Fatalf("CheckTXID without signature: %v", err)
+	}
+	migrated.TXOutputs[0].Value = 3
+	if err := migrated.CheckTXID(); !errors.Is(err, ErrInvalidTransaction) {
+		t.Fatalf("CheckTXID = %v, want ErrInvalidTransaction", err)
+	}
+
+	//新版本的交易id是规范编码的哈希
+	migrated.Version = TxVersion
+	migrated.SetTXID()
+	if err := migrated.CheckTXID(); err != nil {
+		t.Fatalf("CheckTXID version %d: %v", TxVersion, err)
+	}
+}
+
+//规范编码中没有交易id，版本0的交易解码后TXid为空，不能用新的方法计算
+func TestDeserializeLegacy(t *testing.T) {
+	key := newLegacyKey(t)
+	pubKeyHash := wallet.HashPubKey(append(key.PublicKey.X.Bytes(), key.PublicKey.Y.Bytes()...))
+	prev := &Transaction{TXid: []byte("prev"), TXOutputs: []TXOutput{{Value: 2, PubKeyHash: pubKeyHash}}}
+	migrated := newLegacyTx(t, key, prev, 1)
+
+	decoded, err := DeserializeTransaction(migrated.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TXid != nil {
+		t.Fatalf("version 0 transaction decoded with id %x", decoded.TXid)
+	}
+	decoded.TXid = migrated.TXid
+	if err := decoded.CheckTXID(); err != nil {
+		t.Fatalf("CheckTXID with restored id: %v", err)
+	}
+
+	migrated.Version = TxVersion
+	migrated.SetTXID()
+	if decoded, err = DeserializeTransaction(migrated.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.TXid, migrated.TXid) {
+		t.Fatalf("version %d transaction decoded with id %x, want %x", TxVersion, decoded.TXid, migrated.TXid)
+	}
+}
@@ -0,0 +1,117 @@
+package tx
+
+import (
+	"fmt"
+
+	"github.com/ELOATS/btc/internal/wire"
+)
+
+//交易的规范编码，编码规则见wire包
+
+//交易版本，交易id和签名都基于交易自己版本的编码计算，所以旧交易的编码永远不变
+//版本0是从gob数据库迁移过来的旧交易，编码布局与版本1相同，但交易id仍是旧的gob哈希
+const (
+	txVersionData     = 2 //从版本2开始，每个output多一个Data字段(OP_RETURN数据)
+	txVersionSequence = 3 //从版本3开始，每个input多一个Sequence字段
+	txVersionLockTime = 4 //从版本4开始，交易末尾多一个LockTime字段
+
+	//新创建交易使用的版本
+	TxVersion = txVersionLockTime
+)
+
+//交易的编码不包含TXid，交易id就是这段编码的哈希
+func (tx *Transaction) Encode(e *wire.Encoder) {
+	e.PutUvarint(tx.Version)
+
+	e.PutUvarint(uint64(len(tx.TXInputs)))
+	for _, input := range tx.TXInputs {
+		e.PutBytes(input.TXID)
+		e.PutVarint(input.Index)
+		e.PutBytes(input.Signature)
+		e.PutBytes(input.PubKey)
+		if tx.Version >= txVersionSequence {
+			e.PutUint32(input.Sequence)
+		}
+	}
+
+	e.PutUvarint(uint64(len(tx.TXOutputs)))
+	for _, output := range tx.TXOutputs {
+		e.PutFloat64(output.Value)
+		e.PutBytes(output.PubKeyHash)
+		if tx.Version >= txVersionData {
+			e.PutBytes(output.Data)
+		}
+	}
+
+	if tx.Version >= txVersionLockTime {
+		e.PutUint32(tx.LockTime)
+	}
+}
+
+func (tx *Transaction) Decode(d *wire.Decoder) {
+	tx.Version = d.GetUvarint()
+	if d.Err() == nil && tx.Version > TxVersion {
+		d.Fail(fmt.Errorf("不支持的交易版本: %d", tx.Version))
+		return
+	}
+
+	//每个input至少占4个字节，output至少占9个字节，以此限制预分配
+	if n := d.GetCount(); n > 0 {
+		tx.TXInputs = make([]TXInput, 0, n/4)
+		for i := 0; i < n && d.Err() == nil; i++ {
+			var input TXInput
+			input.TXID = d.GetBytes()
+			input.Index = d.GetVarint()
+			input.Signature = d.GetBytes()
+			input.PubKey = d.GetBytes()
+			//旧版本的交易没有Sequence，视为不可替换
+			input.Sequence = SequenceFinal
+			if tx.Version >= txVersionSequence {
+				input.Sequence = d.GetUint32()
+			}
+			tx.TXInputs = append(tx.TXInputs, input)
+		}
+	}
+
+	if n := d.GetCount(); n > 0 {
+		tx.TXOutputs = make([]TXOutput, 0, n/9)
+		for i := 0; i < n && d.Err() == nil; i++ {
+			var output TXOutput
+			output.Value = d.GetFloat64()
+			output.PubKeyHash = d.GetBytes()
+			if tx.Version >= txVersionData {
+				output.Data = d.GetBytes()
+			}
+			tx.TXOutputs = append(tx.TXOutputs, output)
+		}
+	}
+
+	if tx.Version >= txVersionLockTime {
+		tx.LockTime = d.GetUint32()
+	}
+}
+
+//交易的规范编码，用于计算交易id和签名数据
+func (tx *Transaction) Serialize() []byte {
+	var e wire.Encoder
+	tx.Encode(&e)
+	return e.Bytes()
+}
+
+//解码交易并计算交易id
+//版本0的交易是迁移过来的，id是旧的gob哈希，无法从规范编码算出，TXid为空，由调用者从保存的位置恢复
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+
+	d := wire.NewDecoder(data)
+	tx.Decode(d)
+	if err := d.Finish(); err != nil {
+		return nil, fmt.Errorf("交易解码失败: %v", err)
+	}
+
+	if tx.Version != 0 {
+		tx.SetTXID()
+	}
+	return &tx, nil
+}
+
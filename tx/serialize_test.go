@@ -0,0 +1,112 @@
+package tx
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ELOATS/btc/internal/wire"
+	"github.com/ELOATS/btc/wallet"
+)
+
+func testAddress(t *testing.T) string {
+	t.Helper()
+	key, err := wallet.NewWalletKeyPair(wallet.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.GetAddress()
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	inputs := []TXInput{
+		{TXID: []byte("prev-1"), Index: 0, Signature: []byte("sig"), PubKey: []byte("key"), Sequence: SequenceRBF},
+		{TXID: []byte("prev-2"), Index: 3, Signature: nil, PubKey: []byte("key"), Sequence: SequenceFinal},
+	}
+	outputs := []TXOutput{{Value: 1.5, PubKeyHash: []byte("hash")}, {Value: 0, PubKeyHash: []byte("hash2")}}
+
+	tests := []struct {
+		name string
+		tx   Transaction
+	}{
+		{"version 1", Transaction{Version: 1, TXInputs: finalInputs(inputs), TXOutputs: outputs}},
+		{"version 2 data output", Transaction{Version: txVersionData, TXInputs: finalInputs(inputs), TXOutputs: append(outputs, NewDataOutput([]byte("data")))}},
+		{"version 3 sequence", Transaction{Version: txVersionSequence, TXInputs: inputs, TXOutputs: outputs}},
+		{"version 4 lock time", Transaction{Version: txVersionLockTime, TXInputs: inputs, TXOutputs: outputs, LockTime: 1234}},
+		{"coinbase", *NewCoinbaseTx(testAddress(t), "data")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tx.SetTXID()
+			data := tt.tx.Serialize()
+
+			decoded, err := DeserializeTransaction(data)
+			if err != nil {
+				t.Fatalf("DeserializeTransaction: %v", err)
+			}
+			if !reflect.DeepEqual(*decoded, tt.tx) {
+				t.Fatalf("decoded = %+v, want %+v", *decoded, tt.tx)
+			}
+			//编码是规范的：再次编码得到相同的字节，交易id不变
+			if !bytes.Equal(decoded.Serialize(), data) {
+				t.Fatal("re-encoding changed the bytes")
+			}
+			if err := decoded.CheckTXID(); err != nil && tt.tx.Version > 0 {
+				t.Fatalf("CheckTXID: %v", err)
+			}
+		})
+	}
+}
+
+//旧版本的交易没有Sequence，解码为SequenceFinal
+func finalInputs(inputs []TXInput) []TXInput {
+	result := append([]TXInput{}, inputs...)
+	for i := range result {
+		result[i].Sequence = SequenceFinal
+	}
+	return result
+}
+
+func TestDeserializeRejects(t *testing.T) {
+	valid := Transaction{Version: TxVersion, TXInputs: []TXInput{{TXID: []byte("prev"), Index: 0}}, TXOutputs: []TXOutput{{Value: 1}}}
+	data := valid.Serialize()
+
+	var future wire.Encoder
+	future.PutUvarint(TxVersion + 1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated", data[:len(data)-1]},
+		{"trailing bytes", append(append([]byte{}, data...), 0)},
+		{"unsupported version", future.Bytes()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeserializeTransaction(tt.data); err == nil {
+				t.Fatal("DeserializeTransaction succeeded, want error")
+			}
+		})
+	}
+}
+
+//金额的编码是定长的，NaN和负数也能编码，由CheckOutputValues拒绝
+func TestSerializeOutputValues(t *testing.T) {
+	for _, value := range []float64{-1, nan(), inf()} {
+		tx := Transaction{Version: TxVersion, TXOutputs: []TXOutput{{Value: value}}}
+		tx.SetTXID()
+
+		decoded, err := DeserializeTransaction(tx.Serialize())
+		if err != nil {
+			t.Fatalf("value %v: %v", value, err)
+		}
+		if err := decoded.CheckOutputValues(); !errors.Is(err, ErrInvalidTransaction) {
+			t.Fatalf("value %v: CheckOutputValues = %v, want ErrInvalidTransaction", value, err)
+		}
+	}
+}
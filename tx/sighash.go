@@ -0,0 +1,104 @@
+package tx
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+//签名类型，附加在每个签名的最后一个字节，决定签名覆盖交易的哪些部分
+//ALL: 签名覆盖所有input和所有output(默认)
+//NONE: 签名覆盖所有input，不覆盖output，output可以被任意修改
+//SINGLE: 签名覆盖所有input，以及与当前input下标相同的那个output
+//ANYONECANPAY: 可以与以上三种组合，签名只覆盖当前input，其他人可以继续往交易中添加input(众筹)
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+//去掉ANYONECANPAY标志后的基础类型
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+func (t SigHashType) IsValid() bool {
+	base := t.base()
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", byte(t))
+	}
+
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+//计算第i个input需要签名的摘要
+//prevPubKeyHash是这个input所引用的output的公钥哈希
+//1. 拷贝一份裁剪过的交易，当前input的PubKey替换为引用output的公钥哈希
+//2. 根据签名类型裁剪output和其他input
+//3. 对规范编码后拼接4字节的签名类型做哈希
+func (tx *Transaction) SignatureHash(i int, prevPubKeyHash []byte, hashType SigHashType) ([]byte, error) {
+	if i < 0 || i >= len(tx.TXInputs) {
+		return nil, fmt.Errorf("input下标越界: %d", i)
+	}
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("无效的签名类型: 0x%02x", byte(hashType))
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.TXInputs[i].PubKey = prevPubKeyHash
+
+	//NONE和SINGLE不关心其他input的Sequence，把它们置0，其他参与方可以修改自己的Sequence
+	if base := hashType.base(); base == SigHashNone || base == SigHashSingle {
+		for j := range txCopy.TXInputs {
+			if j != i {
+				txCopy.TXInputs[j].Sequence = 0
+			}
+		}
+	}
+
+	switch hashType.base() {
+	case SigHashNone:
+		txCopy.TXOutputs = nil
+	case SigHashSingle:
+		//没有对应的output时不允许签名，避免签出一个对任何交易都有效的签名
+		if i >= len(txCopy.TXOutputs) {
+			return nil, fmt.Errorf("SINGLE签名的input %d 没有对应的output", i)
+		}
+
+		//前面的output置为空，只保留下标相同的output
+		outputs := make([]TXOutput, i+1)
+		for j := 0; j < i; j++ {
+			outputs[j] = TXOutput{Value: -1}
+		}
+		outputs[i] = txCopy.TXOutputs[i]
+		txCopy.TXOutputs = outputs
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.TXInputs = []TXInput{txCopy.TXInputs[i]}
+	}
+
+	var suffix [4]byte
+	binary.LittleEndian.PutUint32(suffix[:], uint32(hashType))
+
+	hash := sha256.Sum256(append(txCopy.Serialize(), suffix[:]...))
+	return hash[:], nil
+}
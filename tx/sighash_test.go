@@ -0,0 +1,195 @@
+package tx
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ELOATS/btc/wallet"
+)
+
+//两个input引用同一个前交易的两个output，都属于key
+func newSignedTx(t *testing.T, key *wallet.WalletKeyPair, hashType SigHashType) (*Transaction, map[string]Transaction) {
+	t.Helper()
+
+	pubKeyHash := wallet.HashPubKey(key.PubKey())
+	prev := Transaction{Version: TxVersion, TXOutputs: []TXOutput{{Value: 2, PubKeyHash: pubKeyHash}, {Value: 3, PubKeyHash: pubKeyHash}}}
+	prev.SetTXID()
+	prevTXs := map[string]Transaction{string(prev.TXid): prev}
+
+	tx := &Transaction{
+		Version: TxVersion,
+		TXInputs: []TXInput{
+			{TXID: prev.TXid, Index: 0, PubKey: key.PubKey(), Sequence: SequenceFinal},
+			{TXID: prev.TXid, Index: 1, PubKey: key.PubKey(), Sequence: SequenceFinal},
+		},
+		TXOutputs: []TXOutput{{Value: 1, PubKeyHash: []byte("a")}, {Value: 3, PubKeyHash: []byte("b")}},
+	}
+	if err := tx.SignWithHashType(key, prevTXs, hashType); err != nil {
+		t.Fatalf("SignWithHashType: %v", err)
+	}
+	tx.SetTXID()
+	return tx, prevTXs
+}
+
+func TestSignatureHashTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		tamper   func(tx *Transaction)
+		ok       bool
+	}{
+		{"all", SigHashAll, nil, true},
+		{"all changed output", SigHashAll, func(tx *Transaction) { tx.TXOutputs[0].Value = 2 }, false},
+		{"all changed sequence", SigHashAll, func(tx *Transaction) { tx.TXInputs[1].Sequence = SequenceRBF }, false},
+		{"all changed lock time", SigHashAll, func(tx *Transaction) { tx.LockTime = 1 }, false},
+		{"none changed output", SigHashNone, func(tx *Transaction) { tx.TXOutputs[1].Value = 1 }, true},
+		{"none added output", SigHashNone, func(tx *Transaction) { tx.TXOutputs = append(tx.TXOutputs, TXOutput{Value: 1}) }, true},
+		{"none changed input", SigHashNone, func(tx *Transaction) { tx.TXInputs[1].Index = 2 }, false},
+		//SINGLE只覆盖下标相同的output，第二个input覆盖output 1
+		{"single changed other output", SigHashSingle, func(tx *Transaction) { tx.TXOutputs = append(tx.TXOutputs, TXOutput{Value: 1}) }, true},
+		{"single changed own output", SigHashSingle, func(tx *Transaction) { tx.TXOutputs[1].Value = 2 }, false},
+		{"anyonecanpay changed output", SigHashAll | SigHashAnyoneCanPay, func(tx *Transaction) { tx.TXOutputs[0].Value = 2 }, false},
+		{"changed hash type byte", SigHashAll, func(tx *Transaction) {
+			sig := tx.TXInputs[0].Signature
+			sig[len(sig)-1] = byte(SigHashNone)
+		}, false},
+	}
+
+	key, err := wallet.NewWalletKeyPair(wallet.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, prevTXs := newSignedTx(t, key, tt.hashType)
+			if tt.tamper != nil {
+				tt.tamper(tx)
+			}
+
+			err := tx.Verify(prevTXs)
+			if tt.ok && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidTransaction) {
+				t.Fatalf("Verify = %v, want ErrInvalidTransaction", err)
+			}
+		})
+	}
+}
+
+//签名类型决定签名覆盖交易的哪些部分：修改不被覆盖的部分，签名数据不变
+func TestSignatureHashCoverage(t *testing.T) {
+	addInput := func(tx *Transaction) { tx.TXInputs = append(tx.TXInputs, TXInput{TXID: []byte("c")}) }
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		tamper   func(tx *Transaction)
+		same     bool
+	}{
+		{"all added input", SigHashAll, addInput, false},
+		{"all other sequence", SigHashAll, func(tx *Transaction) { tx.TXInputs[1].Sequence = 0 }, false},
+		{"all output", SigHashAll, func(tx *Transaction) { tx.TXOutputs[1].Value = 9 }, false},
+		{"none output", SigHashNone, func(tx *Transaction) { tx.TXOutputs[0].Value = 9 }, true},
+		{"none other sequence", SigHashNone, func(tx *Transaction) { tx.TXInputs[1].Sequence = 0 }, true},
+		{"none own sequence", SigHashNone, func(tx *Transaction) { tx.TXInputs[0].Sequence = 0 }, false},
+		{"single other output", SigHashSingle, func(tx *Transaction) { tx.TXOutputs[1].Value = 9 }, true},
+		{"single own output", SigHashSingle, func(tx *Transaction) { tx.TXOutputs[0].Value = 9 }, false},
+		{"anyonecanpay added input", SigHashAll | SigHashAnyoneCanPay, addInput, true},
+		{"anyonecanpay other input", SigHashAll | SigHashAnyoneCanPay, func(tx *Transaction) { tx.TXInputs[1].Index = 5 }, true},
+		{"anyonecanpay output", SigHashAll | SigHashAnyoneCanPay, func(tx *Transaction) { tx.TXOutputs[1].Value = 9 }, false},
+		{"single anyonecanpay", SigHashSingle | SigHashAnyoneCanPay, addInput, true},
+		{"lock time", SigHashNone | SigHashAnyoneCanPay, func(tx *Transaction) { tx.LockTime = 1 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{
+				Version:   TxVersion,
+				TXInputs:  []TXInput{{TXID: []byte("a"), Sequence: SequenceFinal}, {TXID: []byte("b"), Sequence: SequenceFinal}},
+				TXOutputs: []TXOutput{{Value: 1, PubKeyHash: []byte("x")}, {Value: 2, PubKeyHash: []byte("y")}},
+			}
+			before, err := tx.SignatureHash(0, []byte("hash"), tt.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(tx)
+			after, err := tx.SignatureHash(0, []byte("hash"), tt.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := bytes.Equal(before, after); same != tt.same {
+				t.Fatalf("signature hash unchanged = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestSignatureHashRejects(t *testing.T) {
+	tx := Transaction{Version: TxVersion, TXInputs: []TXInput{{TXID: []byte("a")}, {TXID: []byte("b")}}, TXOutputs: []TXOutput{{Value: 1}}}
+
+	tests := []struct {
+		name     string
+		input    int
+		hashType SigHashType
+	}{
+		{"negative input", -1, SigHashAll},
+		{"input out of range", 2, SigHashAll},
+		{"zero hash type", 0, 0},
+		{"unknown hash type", 0, 0x04},
+		{"anyonecanpay alone", 0, SigHashAnyoneCanPay},
+		//SINGLE的input没有对应的output
+		{"single without output", 1, SigHashSingle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tx.SignatureHash(tt.input, []byte("hash"), tt.hashType); err == nil {
+				t.Fatal("SignatureHash succeeded, want error")
+			}
+		})
+	}
+}
+
+//每种密钥类型的签名都是64字节定长，加上1字节的签名类型
+func TestSignatureEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType wallet.KeyType
+	}{
+		{"p256", wallet.KeyTypeP256},
+		{"secp256k1", wallet.KeyTypeSecp256k1},
+		{"schnorr", wallet.KeyTypeSchnorr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := wallet.NewWalletKeyPair(tt.keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, prevTXs := newSignedTx(t, key, SigHashAll)
+			for i, input := range tx.TXInputs {
+				if len(input.Signature) != wallet.SigLen+1 {
+					t.Fatalf("input %d: signature length %d, want %d", i, len(input.Signature), wallet.SigLen+1)
+				}
+			}
+			if err := tx.Verify(prevTXs); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			//多一个字节或者少一个字节都拒绝
+			for _, sig := range [][]byte{tx.TXInputs[0].Signature[1:], append([]byte{0}, tx.TXInputs[0].Signature...)} {
+				tampered := *tx
+				tampered.TXInputs = append([]TXInput{}, tx.TXInputs...)
+				tampered.TXInputs[0].Signature = sig
+				if err := tampered.Verify(prevTXs); !errors.Is(err, ErrInvalidTransaction) {
+					t.Fatalf("Verify with %d-byte signature = %v, want ErrInvalidTransaction", len(sig), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package tx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ELOATS/btc/address"
+	"github.com/ELOATS/btc/internal/logging"
+	"github.com/ELOATS/btc/wallet"
+)
+
+var txLog = logging.Component(logging.Tx)
+
+type TXInput struct {
+	TXID  []byte //交易ID
+	Index int64  //output的索引
+	//Address string //解锁脚本，先使用地址模拟
+
+	Signature []byte //交易签名
+	PubKey    []byte //公钥本身
+
+	//序列号，小于sequenceRBFThreshold表示交易允许被替换(BIP125)
+	Sequence uint32
+}
+
+const (
+	SequenceFinal    uint32 = 0xffffffff
+	SequenceLockTime uint32 = 0xfffffffe //启用锁定时间但不允许替换
+	SequenceRBF      uint32 = 0xfffffffd
+
+	//任何一个input的Sequence小于这个值，交易就允许被替换
+	sequenceRBFThreshold uint32 = 0xfffffffe
+)
+
+type TXOutput struct {
+	Value float64 //转账金额
+	//Address string //锁定脚本
+
+	PubKeyHash []byte //公钥的哈希
+
+	//附加数据，相当于比特币的OP_RETURN，带数据的output没有锁定脚本，任何人都无法花费
+	Data []byte
+}
+
+//数据output的最大长度
+const MaxDataSize = 80
+
+//创建一个数据output，不携带金额
+func NewDataOutput(data []byte) TXOutput {
+	return TXOutput{Value: 0, Data: data}
+}
+
+//是否是数据output，数据output不属于任何人，不计入utxo
+func (output *TXOutput) IsDataOutput() bool {
+	return len(output.Data) != 0
+}
+
+//给定转账地址，得到这个地址的公钥哈希，完成对output的锁定
+func (output *TXOutput) Lock(addr string) {
+	//地址已经校验过，无效的地址得到空的公钥哈希
+	_, pubKeyHash, _ := address.Decode(addr)
+
+	output.PubKeyHash = pubKeyHash
+}
+
+func NewTXOutput(value float64,address string) TXOutput {
+	output := TXOutput{Value:value}
+	output.Lock(address)
+
+	return output
+}
+
+type Transaction struct {
+	Version   uint64     //交易版本号，决定交易的编码格式
+	TXid      []byte     //交易id
+	TXInputs  []TXInput  //所有的inputs
+	TXOutputs []TXOutput //所有的outputs
+
+	//锁定时间，交易在此之前不能被打包，0表示不锁定
+	//小于lockTimeThreshold时表示区块高度，否则表示unix时间戳
+	LockTime uint32
+}
+
+const lockTimeThreshold = 500000000
+
+//交易id是交易规范编码(不含TXid)的哈希值
+func (tx *Transaction) SetTXID() {
+	hash := sha256.Sum256(tx.Serialize())
+	tx.TXid = hash[:]
+}
+
+const Reward = 12.5
+
+// 实现挖矿交易，特点：只有输出，没有有效的输入(不需要引用id，不需要索引，不需要签名)
+// 把挖矿的人传递进来，因为有奖励
+func NewCoinbaseTx(miner string, data string) *Transaction {
+	return NewCoinbaseTxWithFees(miner, data, 0)
+}
+
+//挖矿交易的金额是挖矿奖励加上区块中所有交易的手续费
+func NewCoinbaseTxWithFees(miner string, data string, fees float64) *Transaction {
+
+	//挖矿交易的input只用来保证交易id唯一，没有指定时填入随机数
+	if data == "" {
+		nonce := make([]byte, 8)
+		_, _ = rand.Read(nonce)
+		data = hex.EncodeToString(nonce)
+	}
+
+	inputs := []TXInput{{TXID: nil, Index: -1, PubKey: []byte(data), Sequence: SequenceFinal}}
+	//outputs := []TXOutput{{12.5, miner}}
+
+	output := NewTXOutput(Reward+fees,miner)
+	outputs := []TXOutput{output}
+
+	tx := Transaction{Version: TxVersion, TXInputs: inputs, TXOutputs: outputs}
+	tx.SetTXID()
+
+	return &tx
+}
+
+func (tx *Transaction) IsCoinbase() bool {
+	//特点：只有一个input、引用的id是nil、引用的索引是-1
+	inputs := tx.TXInputs
+
+	if len(inputs) == 1 && inputs[0].TXID == nil && inputs[0].Index == -1 {
+		return true
+	}
+	return false
+}
+
+//交易是否允许被替换，只要有一个input的Sequence小于阈值即可
+func (tx *Transaction) IsReplaceable() bool {
+	for _, input := range tx.TXInputs {
+		if input.Sequence < sequenceRBFThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+//交易能否被打包进高度为height的区块，medianTime是前一个区块的MTP
+//所有input的Sequence都是SequenceFinal时忽略锁定时间
+func (tx *Transaction) IsFinal(height uint64, medianTime uint64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := height
+	if tx.LockTime >= lockTimeThreshold {
+		limit = medianTime
+	}
+	if uint64(tx.LockTime) < limit {
+		return true
+	}
+
+	for _, input := range tx.TXInputs {
+		if input.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+//交易的大小，按规范编码的字节数计算，用来计算手续费率
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+//第一个参数是签名者(钱包中的秘钥对)
+//第二个参数是这个交易的input所引用的所有的交易
+//默认使用SIGHASH_ALL签名
+func (tx *Transaction) Sign(signer wallet.Signer,prevTXs map[string]Transaction) error {
+	return tx.SignWithHashType(signer, prevTXs, SigHashAll)
+}
+
+//使用指定的签名类型对交易签名
+//只签名PubKey属于这个签名者的input，其他input留给别的参与方签名(比如众筹时每个人各自签名自己的input)
+func (tx *Transaction) SignWithHashType(signer wallet.Signer,prevTXs map[string]Transaction,hashType SigHashType) error {
+	//校验的时候，如果是挖矿交易，直接返回true
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	myPubKey := signer.PubKey()
+
+	for i,input := range tx.TXInputs {
+		if !bytes.Equal(input.PubKey, myPubKey) {
+			continue
+		}
+
+		//1. 找到引用的交易，把这个input所引用的output的公钥哈希拿过来
+		preTX, ok := prevTXs[string(input.TXID)]
+		if !ok || input.Index < 0 || input.Index >= int64(len(preTX.TXOutputs)) {
+			return fmt.Errorf("input %d 无法签名: %w: %x:%d", i, ErrMissingPrevTx, input.TXID, input.Index)
+		}
+		output := preTX.TXOutputs[input.Index]
+
+		//2. 根据签名类型生成要签名的数据（哈希）
+		signData, err := tx.SignatureHash(i, output.PubKeyHash, hashType)
+		if err != nil {
+			return fmt.Errorf("input %d 生成签名数据失败: %w", i, err)
+		}
+
+		txLog.Debug("对input签名", "input", i, logging.Hex("signData", signData))
+
+		//3. 使用签名者对应的算法签名，得到64字节定长的签名
+		signature,err := signer.SignHash(signData)
+
+		if err != nil {
+			return fmt.Errorf("input %d 签名失败: %w", i, err)
+		}
+
+
+		//4. 末尾附加签名类型，赋值给原始的交易的Signature字段
+		signature = append(signature, byte(hashType))
+
+		tx.TXInputs[i].Signature = signature
+	}
+
+	return nil
+}
+
+//做相应裁剪：把每一个input的Sign和pubKey设置为nil
+//output拷贝一份，避免修改副本时影响原始交易
+func (tx *Transaction) TrimmedCopy() Transaction {
+	var inputs [] TXInput
+	var outputs []TXOutput
+
+	for _,input := range tx.TXInputs {
+		input2 := TXInput{input.TXID,input.Index,nil,nil,input.Sequence}
+		inputs = append(inputs,input2)
+	}
+
+	outputs = append(outputs, tx.TXOutputs...)
+
+	tx2 := Transaction{tx.Version,tx.TXid,inputs,outputs,tx.LockTime}
+
+	return tx2
+}
+
+//output的金额不能是负数、NaN或者无穷大
+//负数可以抵消其他output的金额，NaN让所有金额的比较都不成立，都会绕过金额和手续费的检查
+func (tx *Transaction) CheckOutputValues() error {
+	for i, output := range tx.TXOutputs {
+		if output.Value < 0 || math.IsNaN(output.Value) || math.IsInf(output.Value, 0) {
+			return fmt.Errorf("%w: 交易 %x output %d 的金额 %f 无效", ErrInvalidTransaction, tx.TXid, i, output.Value)
+		}
+	}
+	return nil
+}
+
+//检查数据output：长度有上限，不能携带金额，旧版本的交易不能有数据
+func (tx *Transaction) CheckDataOutputs() error {
+	for i,output := range tx.TXOutputs {
+		if !output.IsDataOutput() {
+			continue
+		}
+
+		if tx.Version < txVersionData {
+			return fmt.Errorf("版本 %d 的交易不支持数据output", tx.Version)
+		}
+		if len(output.Data) > MaxDataSize {
+			return fmt.Errorf("output %d 的数据超过 %d 字节", i, MaxDataSize)
+		}
+		if output.Value != 0 || len(output.PubKeyHash) != 0 {
+			return fmt.Errorf("output %d 是数据output，不能携带金额和锁定脚本", i)
+		}
+	}
+
+	return nil
+}
+
+//校验交易的签名，失败时返回的错误包装了ErrInvalidTransaction
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
+	txLog.Debug("对交易进行校验", logging.Hex("txid", tx.TXid))
+
+	if err := tx.CheckDataOutputs(); err != nil {
+		return tx.invalid(-1, err.Error())
+	}
+
+	//迁移过来的版本0交易按旧的方法校验，见legacy.go
+	if tx.Version == 0 {
+		return tx.verifyLegacy(prevTXs)
+	}
+
+	//1. 遍历原始交易的input
+	for i,input := range tx.TXInputs {
+		//2. 找到input所引用的前交易prevTX中的output
+		prevTX, ok := prevTXs[string(input.TXID)]
+		if !ok || input.Index < 0 || input.Index >= int64(len(prevTX.TXOutputs)) {
+			return tx.invalid(i, "引用的output不存在")
+		}
+		output := prevTX.TXOutputs[input.Index]
+
+		//数据output是不可花费的
+		if output.IsDataOutput() {
+			return tx.invalid(i, "引用的是数据output，不可花费")
+		}
+
+		//3. 公钥必须与output锁定的公钥哈希一致，否则任何人都可以用自己的私钥花费别人的钱
+		if !bytes.Equal(wallet.HashPubKey(input.PubKey), output.PubKeyHash) {
+			return tx.invalid(i, "公钥与output的锁定脚本不匹配")
+		}
+
+		//4. 取出签名末尾的签名类型，还原签名的数据
+		signature := input.Signature
+		if len(signature) != wallet.SigLen+1 {
+			return tx.invalid(i, "签名长度不正确")
+		}
+		hashType := SigHashType(signature[len(signature)-1])
+		signature = signature[:len(signature)-1]
+
+		verifyData, err := tx.SignatureHash(i, output.PubKeyHash, hashType)
+		if err != nil {
+			return tx.invalid(i, err.Error())
+		}
+		txLog.Debug("校验input", "input", i, logging.Hex("verifyData", verifyData))
+
+		//5. 根据公钥的类型标记选择算法进行校验，不规范的编码直接拒绝
+		err = wallet.VerifySignature(input.PubKey, verifyData, signature)
+		if err != nil {
+			return tx.invalid(i, "签名无效: "+err.Error())
+		}
+	}
+
+	return nil
+}
+
+//input为-1时表示与具体的input无关
+func (tx *Transaction) invalid(input int, reason string) error {
+	if input < 0 {
+		return fmt.Errorf("%w: 交易 %x: %s", ErrInvalidTransaction, tx.TXid, reason)
+	}
+	return fmt.Errorf("%w: 交易 %x input %d: %s", ErrInvalidTransaction, tx.TXid, input, reason)
+}
+
+func (tx *Transaction) String() string {
+	var lines []string
+
+	lines = append(lines,fmt.Sprintf("--- Transaction %x\n",tx.TXid))
+
+	for i,input := range tx.TXInputs {
+		lines = append(lines,fmt.Sprintf("	 Input: %d",i))
+		lines = append(lines,fmt.Sprintf("		TXID:		%x",input.TXID))
+		lines = append(lines,fmt.Sprintf("		Out:		%d",input.Index))
+		lines = append(lines,fmt.Sprintf("		Signature:	%x",input.Signature))
+		if len(input.Signature) > 0 {
+			lines = append(lines,fmt.Sprintf("		SigHash:	%s",SigHashType(input.Signature[len(input.Signature)-1])))
+		}
+		lines = append(lines,fmt.Sprintf("		PubKey:		%x",input.PubKey))
+		lines = append(lines,fmt.Sprintf("		Sequence:	%x",input.Sequence))
+	}
+
+	for i,output := range tx.TXOutputs {
+		lines = append(lines,fmt.Sprintf("	 Output: %d",i))
+		lines = append(lines,fmt.Sprintf("		Value: 		%f",output.Value))
+		if output.IsDataOutput() {
+			lines = append(lines,fmt.Sprintf("		Data:		%q",output.Data))
+			continue
+		}
+		lines = append(lines,fmt.Sprintf("		Script:		%x",output.PubKeyHash))
+	}
+
+	if tx.LockTime != 0 {
+		lines = append(lines,fmt.Sprintf("	 LockTime: %d",tx.LockTime))
+	}
+
+	return strings.Join(lines,"\n")
+}
\ No newline at end of file
@@ -0,0 +1,125 @@
+package tx
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func nan() float64 { return math.NaN() }
+
+func inf() float64 { return math.Inf(1) }
+
+func TestCheckOutputValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		ok    bool
+	}{
+		{"zero", 0, true},
+		{"positive", 12.5, true},
+		{"negative", -1, false},
+		{"negative zero", math.Copysign(0, -1), true},
+		{"NaN", nan(), false},
+		{"positive infinity", inf(), false},
+		{"negative infinity", math.Inf(-1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{Version: TxVersion, TXOutputs: []TXOutput{{Value: 1}, {Value: tt.value}}}
+			err := tx.CheckOutputValues()
+			if tt.ok && err != nil {
+				t.Fatalf("CheckOutputValues: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidTransaction) {
+				t.Fatalf("CheckOutputValues = %v, want ErrInvalidTransaction", err)
+			}
+		})
+	}
+}
+
+
+func TestCheckDataOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint64
+		output  TXOutput
+		ok      bool
+	}{
+		{"data output", TxVersion, NewDataOutput([]byte("hello")), true},
+		{"max size", TxVersion, NewDataOutput(make([]byte, MaxDataSize)), true},
+		{"too large", TxVersion, TXOutput{Data: make([]byte, MaxDataSize+1)}, false},
+		{"with value", TxVersion, TXOutput{Value: 1, Data: []byte("x")}, false},
+		{"with pubkey hash", TxVersion, TXOutput{PubKeyHash: []byte("h"), Data: []byte("x")}, false},
+		{"old version", txVersionData - 1, NewDataOutput([]byte("x")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{Version: tt.version, TXOutputs: []TXOutput{tt.output}}
+			if err := tx.CheckDataOutputs(); (err == nil) != tt.ok {
+				t.Fatalf("CheckDataOutputs = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	const (
+		height = 100
+		mtp    = lockTimeThreshold + 1000
+	)
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		final    bool
+	}{
+		{"no lock time", 0, SequenceLockTime, true},
+		{"height passed", height - 1, SequenceLockTime, true},
+		{"height reached", height, SequenceLockTime, false},
+		{"height in future", height + 1, SequenceLockTime, false},
+		{"time passed", mtp - 1, SequenceLockTime, true},
+		{"time reached", mtp, SequenceLockTime, false},
+		{"time in future", mtp + 1, SequenceLockTime, false},
+		//所有input都是SequenceFinal时忽略锁定时间
+		{"final sequence", height + 1, SequenceFinal, true},
+		{"final sequence time", mtp + 1, SequenceFinal, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{Version: TxVersion, TXInputs: []TXInput{{TXID: []byte("prev"), Sequence: tt.sequence}}, LockTime: tt.lockTime}
+			if got := tx.IsFinal(height, mtp); got != tt.final {
+				t.Fatalf("IsFinal = %v, want %v", got, tt.final)
+			}
+		})
+	}
+}
+
+func TestIsReplaceable(t *testing.T) {
+	tests := []struct {
+		name      string
+		sequences []uint32
+		want      bool
+	}{
+		{"final", []uint32{SequenceFinal}, false},
+		{"lock time only", []uint32{SequenceLockTime}, false},
+		{"rbf", []uint32{SequenceRBF}, true},
+		{"one of many", []uint32{SequenceFinal, 0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx Transaction
+			for _, sequence := range tt.sequences {
+				tx.TXInputs = append(tx.TXInputs, TXInput{Sequence: sequence})
+			}
+			if got := tx.IsReplaceable(); got != tt.want {
+				t.Fatalf("IsReplaceable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+package wallet
+
+import "errors"
+
+//钱包相关的错误，返回时用fmt.Errorf("%w: ...")附带上下文
+var (
+	ErrKeyNotFound = errors.New("钱包中没有这个地址的私钥")
+	ErrWalletFile  = errors.New("钱包文件读写失败")
+)
@@ -0,0 +1,323 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"math/big"
+)
+
+//密钥类型，同时也是地址的版本号(地址解码后的第一个字节)
+//P-256的值为0x00，与原来的地址版本号相同，已有的地址和output不受影响
+//其他类型的公钥在input中会带上1字节的类型标记，output锁定的是带标记公钥的哈希，所以类型也被锁定在了output中
+//标记的取值避开了SEC1的前缀0x02/0x03/0x04，P-256的公钥不带标记也不会混淆
+type KeyType byte
+
+const (
+	KeyTypeP256      KeyType = 0x00 //P-256 ECDSA
+	KeyTypeSecp256k1 KeyType = 0x10 //secp256k1 ECDSA
+	KeyTypeSchnorr   KeyType = 0x20 //secp256k1 Schnorr(BIP340)
+)
+
+var errUnknownKeyType = errors.New("未知的密钥类型")
+
+//一种签名算法的实现
+//私钥是32字节的标量，公钥是带类型标记的字节流，签名都是64字节定长
+type KeyScheme interface {
+	Type() KeyType
+	Name() string
+	GenerateKey() (privKey []byte, pubKey []byte, err error)
+	PubKeyFromPrivate(privKey []byte) ([]byte, error)
+	Sign(privKey []byte, hash []byte) ([]byte, error)
+	//pubKey是去掉类型标记后的公钥
+	Verify(pubKey []byte, hash []byte, sig []byte) error
+}
+
+var keySchemes = map[KeyType]KeyScheme{
+	KeyTypeP256:      p256Scheme{},
+	KeyTypeSecp256k1: secp256k1Scheme{},
+	KeyTypeSchnorr:   schnorrScheme{},
+}
+
+func GetKeyScheme(keyType KeyType) (KeyScheme, error) {
+	scheme, ok := keySchemes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("%w: 0x%02x", errUnknownKeyType, byte(keyType))
+	}
+	return scheme, nil
+}
+
+//根据名字查找密钥类型，用于命令行参数
+func ParseKeyType(name string) (KeyType, error) {
+	for keyType, scheme := range keySchemes {
+		if scheme.Name() == name {
+			return keyType, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", errUnknownKeyType, name)
+}
+
+func (t KeyType) String() string {
+	scheme, err := GetKeyScheme(t)
+	if err != nil {
+		return fmt.Sprintf("unknown(0x%02x)", byte(t))
+	}
+	return scheme.Name()
+}
+
+//从input的公钥中拆出类型标记和公钥本身
+func splitPubKey(pubKey []byte) (KeyType, []byte, error) {
+	if len(pubKey) == 0 {
+		return 0, nil, errPubKeyBytes
+	}
+
+	//同时检查长度，避免把v5遗留的64字节P-256公钥误认为带标记的公钥
+	switch {
+	case KeyType(pubKey[0]) == KeyTypeSecp256k1 && len(pubKey) == 1+pubKeyCompressedLen:
+		return KeyTypeSecp256k1, pubKey[1:], nil
+	case KeyType(pubKey[0]) == KeyTypeSchnorr && len(pubKey) == 1+scalarLen:
+		return KeyTypeSchnorr, pubKey[1:], nil
+	}
+
+	//不带标记的都是P-256公钥
+	return KeyTypeP256, pubKey, nil
+}
+
+func tagPubKey(keyType KeyType, pubKey []byte) []byte {
+	if keyType == KeyTypeP256 {
+		return pubKey
+	}
+	return append([]byte{byte(keyType)}, pubKey...)
+}
+
+//根据公钥的类型标记选择算法，校验签名，sig不包含签名类型
+func VerifySignature(pubKey []byte, hash []byte, sig []byte) error {
+	keyType, rawKey, err := splitPubKey(pubKey)
+	if err != nil {
+		return err
+	}
+
+	scheme, err := GetKeyScheme(keyType)
+	if err != nil {
+		return err
+	}
+
+	return scheme.Verify(rawKey, hash, sig)
+}
+
+//签名者，钱包里的秘钥对实现了这个接口
+type Signer interface {
+	//带类型标记的公钥，与input中的PubKey相同
+	PubKey() []byte
+	SignHash(hash []byte) ([]byte, error)
+}
+
+//P-256，签名和公钥的编码见signature.go
+type p256Scheme struct{}
+
+func (p256Scheme) Type() KeyType { return KeyTypeP256 }
+
+func (p256Scheme) Name() string { return "p256" }
+
+func (p256Scheme) GenerateKey() ([]byte, []byte, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privKey := make([]byte, scalarLen)
+	privateKey.D.FillBytes(privKey)
+
+	return privKey, serializePubKey(&privateKey.PublicKey, true), nil
+}
+
+func (s p256Scheme) PubKeyFromPrivate(privKey []byte) ([]byte, error) {
+	privateKey, err := s.privateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return serializePubKey(&privateKey.PublicKey, true), nil
+}
+
+func (p256Scheme) privateKey(privKey []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+
+	d := new(big.Int).SetBytes(privKey)
+	if len(privKey) != scalarLen || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("无效的P-256私钥")
+	}
+
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.Curve = curve
+	privateKey.X, privateKey.Y = curve.ScalarBaseMult(privKey)
+
+	return privateKey, nil
+}
+
+func (s p256Scheme) Sign(privKey []byte, hash []byte) ([]byte, error) {
+	privateKey, err := s.privateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r, sig, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return serializeSignature(privateKey.Curve, r, sig), nil
+}
+
+func (p256Scheme) Verify(pubKey []byte, hash []byte, sig []byte) error {
+	curve := elliptic.P256()
+
+	r, s, err := parseSignature(curve, sig)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := parsePubKey(curve, pubKey)
+	if err != nil {
+		return err
+	}
+
+	if !ecdsa.Verify(publicKey, hash, r, s) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+//secp256k1 ECDSA，公钥使用33字节压缩格式，签名是r和s各32字节，s必须是low-S
+type secp256k1Scheme struct{}
+
+func (secp256k1Scheme) Type() KeyType { return KeyTypeSecp256k1 }
+
+func (secp256k1Scheme) Name() string { return "secp256k1" }
+
+func (s secp256k1Scheme) GenerateKey() ([]byte, []byte, error) {
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privKey := privateKey.Serialize()
+	pubKey, err := s.PubKeyFromPrivate(privKey)
+	return privKey, pubKey, err
+}
+
+func (secp256k1Scheme) PubKeyFromPrivate(privKey []byte) ([]byte, error) {
+	privateKey, err := secp256k1PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return tagPubKey(KeyTypeSecp256k1, privateKey.PubKey().SerializeCompressed()), nil
+}
+
+func (secp256k1Scheme) Sign(privKey []byte, hash []byte) ([]byte, error) {
+	privateKey, err := secp256k1PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	//RFC6979确定性签名，产生的s已经是low-S
+	//紧凑格式是1字节恢复标志加上定长的r和s，去掉第一个字节就是我们的格式
+	compact := btcecdsa.SignCompact(privateKey, hash, true)
+
+	return compact[1:], nil
+}
+
+func (secp256k1Scheme) Verify(pubKey []byte, hash []byte, sig []byte) error {
+	if len(sig) != SigLen {
+		return errSigLength
+	}
+
+	var r, s btcec.ModNScalar
+	if r.SetByteSlice(sig[:scalarLen]) || s.SetByteSlice(sig[scalarLen:]) || r.IsZero() || s.IsZero() {
+		return errSigRange
+	}
+	if s.IsOverHalfOrder() {
+		return errSigHighS
+	}
+
+	if len(pubKey) != pubKeyCompressedLen {
+		return fmt.Errorf("%w: secp256k1公钥必须是压缩格式", errPubKeyBytes)
+	}
+	publicKey, err := btcec.ParsePubKey(pubKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPubKeyBytes, err)
+	}
+
+	if !btcecdsa.NewSignature(&r, &s).Verify(hash, publicKey) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+//BIP340 Schnorr签名，公钥只保留32字节的X坐标，签名64字节
+type schnorrScheme struct{}
+
+func (schnorrScheme) Type() KeyType { return KeyTypeSchnorr }
+
+func (schnorrScheme) Name() string { return "schnorr" }
+
+func (s schnorrScheme) GenerateKey() ([]byte, []byte, error) {
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privKey := privateKey.Serialize()
+	pubKey, err := s.PubKeyFromPrivate(privKey)
+	return privKey, pubKey, err
+}
+
+func (schnorrScheme) PubKeyFromPrivate(privKey []byte) ([]byte, error) {
+	privateKey, err := secp256k1PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return tagPubKey(KeyTypeSchnorr, schnorr.SerializePubKey(privateKey.PubKey())), nil
+}
+
+func (schnorrScheme) Sign(privKey []byte, hash []byte) ([]byte, error) {
+	privateKey, err := secp256k1PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := schnorr.Sign(privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	return signature.Serialize(), nil
+}
+
+func (schnorrScheme) Verify(pubKey []byte, hash []byte, sig []byte) error {
+	signature, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return fmt.Errorf("签名无效: %v", err)
+	}
+
+	publicKey, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPubKeyBytes, err)
+	}
+
+	if !signature.Verify(hash, publicKey) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+func secp256k1PrivateKey(privKey []byte) (*btcec.PrivateKey, error) {
+	var d btcec.ModNScalar
+	if len(privKey) != scalarLen || d.SetByteSlice(privKey) || d.IsZero() {
+		return nil, errors.New("无效的secp256k1私钥")
+	}
+	return btcec.PrivKeyFromScalar(&d), nil
+}
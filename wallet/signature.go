@@ -0,0 +1,136 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+//签名和公钥的编码
+//之前直接拼接r.Bytes()和s.Bytes()(公钥是X.Bytes()和Y.Bytes())，校验时从中间切开，
+//如果某个值有前导0字节，长度就会变短，切开的位置就错了，校验会随机失败
+//现在统一使用定长编码：
+//1. 签名: r和s各32字节，大端序，左侧补0，共64字节，并且s必须是low-S(s <= n/2)
+//2. 公钥: SEC1格式，压缩(0x02/0x03 + X，33字节)或者非压缩(0x04 + X + Y，65字节)
+
+//P-256曲线上r、s和坐标的字节长度
+const scalarLen = 32
+
+const (
+	SigLen                = scalarLen * 2
+	pubKeyCompressedLen   = 1 + scalarLen
+	pubKeyUncompressedLen = 1 + scalarLen*2
+	//v5的钱包直接拼接X和Y，长度恰好为64字节的公钥仍然可以唯一解析，为了让这些地址里的钱还能花出去而保留
+	pubKeyLegacyLen = scalarLen * 2
+)
+
+var (
+	errSigLength   = errors.New("签名长度不正确")
+	errSigRange    = errors.New("签名的r或s超出范围")
+	errSigHighS    = errors.New("签名的s不是low-S")
+	errPubKeyBytes = errors.New("无法解析的公钥")
+)
+
+//将签名编码为定长的64字节，编码前把s规范为low-S，防止签名被篡改成另一个有效的签名(交易延展性)
+func serializeSignature(curve elliptic.Curve, r, s *big.Int) []byte {
+	n := curve.Params().N
+	halfN := new(big.Int).Rsh(n, 1)
+
+	if s.Cmp(halfN) > 0 {
+		s = new(big.Int).Sub(n, s)
+	}
+
+	sig := make([]byte, SigLen)
+	r.FillBytes(sig[:scalarLen])
+	s.FillBytes(sig[scalarLen:])
+
+	return sig
+}
+
+//解析定长签名，拒绝长度错误、r/s超出[1, n-1]以及high-S的签名
+func parseSignature(curve elliptic.Curve, sig []byte) (*big.Int, *big.Int, error) {
+	if len(sig) != SigLen {
+		return nil, nil, errSigLength
+	}
+
+	r := new(big.Int).SetBytes(sig[:scalarLen])
+	s := new(big.Int).SetBytes(sig[scalarLen:])
+
+	n := curve.Params().N
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, nil, errSigRange
+	}
+
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return nil, nil, errSigHighS
+	}
+
+	return r, s, nil
+}
+
+//将公钥编码为SEC1格式
+func serializePubKey(pub *ecdsa.PublicKey, compressed bool) []byte {
+	if compressed {
+		return elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)
+	}
+
+	buf := make([]byte, pubKeyUncompressedLen)
+	buf[0] = 0x04
+	pub.X.FillBytes(buf[1 : 1+scalarLen])
+	pub.Y.FillBytes(buf[1+scalarLen:])
+
+	return buf
+}
+
+//解析公钥，只接受压缩、非压缩和v5遗留的64字节格式，并且点必须在曲线上
+func parsePubKey(curve elliptic.Curve, data []byte) (*ecdsa.PublicKey, error) {
+	var x, y *big.Int
+
+	switch {
+	case len(data) == pubKeyCompressedLen && (data[0] == 0x02 || data[0] == 0x03):
+		x, y = elliptic.UnmarshalCompressed(curve, data)
+	case len(data) == pubKeyUncompressedLen && data[0] == 0x04:
+		x = new(big.Int).SetBytes(data[1 : 1+scalarLen])
+		y = new(big.Int).SetBytes(data[1+scalarLen:])
+	case len(data) == pubKeyLegacyLen:
+		x = new(big.Int).SetBytes(data[:scalarLen])
+		y = new(big.Int).SetBytes(data[scalarLen:])
+	default:
+		return nil, fmt.Errorf("%w: 长度 %d", errPubKeyBytes, len(data))
+	}
+
+	p := curve.Params().P
+	if x == nil || x.Cmp(p) >= 0 || y.Cmp(p) >= 0 || !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("%w: 点不在曲线上", errPubKeyBytes)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+//校验迁移过来的版本0交易的签名
+//旧版本的签名直接拼接r.Bytes()和s.Bytes()，公钥直接拼接X.Bytes()和Y.Bytes()，都从中间切开，
+//这里按原样还原，不要求定长编码和low-S，否则旧区块中已经被接受的交易会校验失败
+func VerifyLegacySignature(pubKey []byte, hash []byte, sig []byte) error {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return errSigLength
+	}
+	if len(pubKey) == 0 || len(pubKey)%2 != 0 {
+		return fmt.Errorf("%w: 长度 %d", errPubKeyBytes, len(pubKey))
+	}
+
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if !curve.IsOnCurve(x, y) {
+		return fmt.Errorf("%w: 点不在曲线上", errPubKeyBytes)
+	}
+
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, hash, r, s) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
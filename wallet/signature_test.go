@@ -0,0 +1,175 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType KeyType
+		pubLen  int
+	}{
+		{"p256", KeyTypeP256, pubKeyCompressedLen},
+		{"secp256k1", KeyTypeSecp256k1, 1 + pubKeyCompressedLen},
+		{"schnorr", KeyTypeSchnorr, 1 + scalarLen},
+	}
+
+	hash := sha256.Sum256([]byte("message"))
+	other := sha256.Sum256([]byte("other"))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewWalletKeyPair(tt.keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(key.PubKey()) != tt.pubLen {
+				t.Fatalf("public key length %d, want %d", len(key.PubKey()), tt.pubLen)
+			}
+
+			sig, err := key.SignHash(hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sig) != SigLen {
+				t.Fatalf("signature length %d, want %d", len(sig), SigLen)
+			}
+			if err := VerifySignature(key.PubKey(), hash[:], sig); err != nil {
+				t.Fatalf("VerifySignature: %v", err)
+			}
+
+			//地址的版本号就是密钥类型
+			if AddressFromPubKey(key.PubKey()) != key.GetAddress() {
+				t.Fatal("AddressFromPubKey does not match GetAddress")
+			}
+
+			if err := VerifySignature(key.PubKey(), other[:], sig); err == nil {
+				t.Fatal("signature verified for a different hash")
+			}
+			tampered := append([]byte{}, sig...)
+			tampered[SigLen-1] ^= 1
+			if err := VerifySignature(key.PubKey(), hash[:], tampered); err == nil {
+				t.Fatal("tampered signature verified")
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	halfN := new(big.Int).Rsh(n, 1)
+
+	encode := func(r, s *big.Int) []byte {
+		sig := make([]byte, SigLen)
+		r.FillBytes(sig[:scalarLen])
+		s.FillBytes(sig[scalarLen:])
+		return sig
+	}
+	one := big.NewInt(1)
+
+	tests := []struct {
+		name string
+		sig  []byte
+		err  error
+	}{
+		{"valid", encode(one, one), nil},
+		{"half order", encode(one, halfN), nil},
+		{"short", make([]byte, SigLen-1), errSigLength},
+		{"long", make([]byte, SigLen+1), errSigLength},
+		{"zero r", encode(new(big.Int), one), errSigRange},
+		{"zero s", encode(one, new(big.Int)), errSigRange},
+		{"r equals n", encode(n, one), errSigRange},
+		{"high s", encode(one, new(big.Int).Add(halfN, one)), errSigHighS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseSignature(curve, tt.sig)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseSignature = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+//编码时s被规范为low-S，r和s都左侧补0到32字节
+func TestSerializeSignature(t *testing.T) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	tests := []struct {
+		name string
+		r, s *big.Int
+		want *big.Int
+	}{
+		{"small values", big.NewInt(1), big.NewInt(2), big.NewInt(2)},
+		{"high s", big.NewInt(1), new(big.Int).Sub(n, big.NewInt(2)), big.NewInt(2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := serializeSignature(curve, tt.r, tt.s)
+			r, s, err := parseSignature(curve, sig)
+			if err != nil {
+				t.Fatalf("parseSignature: %v", err)
+			}
+			if r.Cmp(tt.r) != 0 || s.Cmp(tt.want) != 0 {
+				t.Fatalf("parsed (%v, %v), want (%v, %v)", r, s, tt.r, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePubKey(t *testing.T) {
+	curve := elliptic.P256()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uncompressed := serializePubKey(&key.PublicKey, false)
+	offCurve := append([]byte{}, uncompressed...)
+	offCurve[len(offCurve)-1] ^= 1
+	badPrefix := serializePubKey(&key.PublicKey, true)
+	badPrefix[0] = 0x05
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"compressed", serializePubKey(&key.PublicKey, true), true},
+		{"uncompressed", uncompressed, true},
+		{"legacy", uncompressed[1:], true},
+		{"empty", nil, false},
+		{"bad prefix", badPrefix, false},
+		{"off curve", offCurve, false},
+		{"truncated", uncompressed[:40], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, err := parsePubKey(curve, tt.data)
+			if !tt.ok {
+				if !errors.Is(err, errPubKeyBytes) {
+					t.Fatalf("parsePubKey = %v, want errPubKeyBytes", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePubKey: %v", err)
+			}
+			if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+				t.Fatal("parsed a different point")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package wallet
+
+import "fmt"
+
+//钱包跟踪的链上状态：钱包中所有地址的UTXO和相关的交易
+//和秘钥一起保存在钱包文件中，由chain.SyncWallet在使用钱包时追上链尾
+
+//钱包跟踪的一个output
+type TrackedOutput struct {
+	TXID     []byte
+	Index    int64
+	Address  string
+	Value    float64
+	Height   uint64 //所在区块的高度
+	Coinbase bool
+}
+
+//output的定位，也是WalletState.Outputs的key
+func OutputKey(txid []byte, index int64) string {
+	return fmt.Sprintf("%x:%d", txid, index)
+}
+
+func (o *TrackedOutput) Key() string {
+	return OutputKey(o.TXID, o.Index)
+}
+
+//钱包跟踪的一个交易，Net是对整个钱包而言的净收入，钱包内部的转账只有手续费
+type TrackedTx struct {
+	TXID   []byte
+	Height uint64
+	Net    float64
+}
+
+//钱包同步到TipHash为止的状态
+type WalletState struct {
+	TipHash   []byte
+	TipHeight uint64
+
+	//同步时钱包中的地址，有了新地址需要重新扫描
+	Addresses map[string]bool
+
+	Outputs map[string]*TrackedOutput
+	Txs     []TrackedTx
+}
+
+func NewWalletState() *WalletState {
+	return &WalletState{Addresses: make(map[string]bool), Outputs: make(map[string]*TrackedOutput)}
+}
+
+//钱包中的地址是否都已经被跟踪
+func (ws *Wallets) StateCovers() bool {
+	if ws.State == nil {
+		return false
+	}
+	for address := range ws.WalletsMap {
+		if !ws.State.Addresses[address] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ELOATS/btc/address"
+	"golang.org/x/crypto/ripemd160"
+)
+
+//1. 创建一个结构WalletKeyPair秘钥对，保存公钥和私钥
+//2. 给这个结构提供一个方法GetAddress： 私钥->公钥->地址
+
+type WalletKeyPair struct {
+	//密钥类型，旧的钱包文件中没有这个字段，解码出来是0，也就是P-256
+	KeyType KeyType
+
+	//私钥的32字节标量，不再直接保存*ecdsa.PrivateKey，避免gob编码曲线接口
+	PrivateKey []byte
+
+	//带类型标记的公钥字节流，P-256使用SEC1压缩格式，见keytype.go
+	PublicKey []byte
+}
+
+func NewWalletKeyPair(keyType KeyType) (*WalletKeyPair, error) {
+	scheme, err := GetKeyScheme(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey,publicKey,err := scheme.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WalletKeyPair{KeyType:keyType,PrivateKey:privateKey,PublicKey:publicKey}, nil
+}
+
+func (w *WalletKeyPair) PubKey() []byte {
+	return w.PublicKey
+}
+
+//使用钱包对应的算法对哈希签名
+func (w *WalletKeyPair) SignHash(hash []byte) ([]byte, error) {
+	scheme, err := GetKeyScheme(w.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	return scheme.Sign(w.PrivateKey, hash)
+}
+
+//地址的版本号就是密钥类型
+func (w *WalletKeyPair) GetAddress() string {
+	return address.Encode(byte(w.KeyType), HashPubKey(w.PublicKey))
+}
+
+//地址的长度和校验码正确，并且版本号是已知的密钥类型
+func IsValidAddress(addr string) bool {
+	version, _, err := address.Decode(addr)
+	if err != nil {
+		return false
+	}
+
+	_, err = GetKeyScheme(KeyType(version))
+	return err == nil
+}
+
+//地址中的公钥哈希，output用它锁定
+func PubKeyHashFromAddress(addr string) ([]byte, error) {
+	if !IsValidAddress(addr) {
+		return nil, fmt.Errorf("%w: %s", address.ErrInvalidAddress, addr)
+	}
+
+	_, pubKeyHash, err := address.Decode(addr)
+	return pubKeyHash, err
+}
+
+//input中的公钥对应的地址，版本号来自公钥的类型标记
+func AddressFromPubKey(pubKey []byte) string {
+	keyType, _, err := splitPubKey(pubKey)
+	if err != nil {
+		keyType = KeyTypeP256
+	}
+	return address.Encode(byte(keyType), HashPubKey(pubKey))
+}
+
+func HashPubKey(pubKey []byte) []byte {
+	hash := sha256.Sum256(pubKey)
+
+	//创建一个hash160对象
+	//向hash160中写数据
+	//做哈希运算
+
+	//hash.Hash的Write不会返回错误
+	rip160Haher := ripemd160.New()
+	rip160Haher.Write(hash[:])
+
+	//Sum函数会把我们的结果与Sum参数append到一起，返回，我们传入nil，防止数据污染
+	publicHash := rip160Haher.Sum(nil)
+
+	return publicHash
+}
@@ -0,0 +1,182 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/ELOATS/btc/internal/logging"
+)
+
+var walletLog = logging.Component(logging.Wallet)
+
+//Wallets结构
+//把地址和秘钥对对应起来
+//map[address1] -> walletKeyPair1
+//map[address2] -> walletKeyPair2
+
+type Wallets struct {
+	WalletsMap map[string]*WalletKeyPair
+
+	//钱包跟踪的链上状态，旧的钱包文件中没有，见state.go
+	State *WalletState
+}
+
+func NewWallets() (*Wallets, error) {
+	var ws Wallets
+
+	ws.WalletsMap = make(map[string]*WalletKeyPair)
+
+	//把所有的钱包从本地加载出来
+	if err := ws.LoadFromFile(); err != nil {
+		return nil, err
+	}
+
+	//把实例返回
+	return &ws, nil
+}
+
+const WalletName = "wallet.dat"
+
+//这个Wallets是对外的，WalletKeyPair是对内的
+//Wallets调用WalletKeyPai
+func (ws *Wallets) CreateWallet(keyType KeyType) (string, error) {
+	//调用NewWalletkeyPair
+	wallet, err := NewWalletKeyPair(keyType)
+	if err != nil {
+		return "", err
+	}
+
+	//将返回的walletKeyPair添加到WalletMap中
+	address := wallet.GetAddress()
+
+	ws.WalletsMap[address] = wallet
+
+	//保存到本地文件
+	if err := ws.SaveToFile(); err != nil {
+		delete(ws.WalletsMap, address)
+		return "", err
+	}
+
+	walletLog.Info("创建钱包", "address", address, "keyType", keyType)
+	return address, nil
+}
+
+func (ws *Wallets) SaveToFile() error {
+	var buffer bytes.Buffer
+
+	//私钥已经是字节流，不再需要注册曲线类型
+	encoder := gob.NewEncoder(&buffer)
+
+	err := encoder.Encode(ws)
+	if err != nil {
+		return fmt.Errorf("%w: 序列化失败: %v", ErrWalletFile, err)
+	}
+
+	content := buffer.Bytes()
+
+	err = ioutil.WriteFile(WalletName,content,0600)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWalletFile, err)
+	}
+
+	return nil
+}
+
+//钱包文件不存在时不加载任何钱包，不是错误
+func (ws *Wallets) LoadFromFile() error {
+	//判断文件是否存在
+	if !isFileExist(WalletName) {
+		walletLog.Debug("钱包文件不存在", "file", WalletName)
+		return nil
+	}
+
+	//read file
+	content,err := ioutil.ReadFile(WalletName)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWalletFile, err)
+	}
+
+	decoder := gob.NewDecoder(bytes.NewReader(content))
+
+	var wallets Wallets
+	err = decoder.Decode(&wallets)
+
+	if err != nil {
+		//可能是旧格式的钱包文件，私钥保存的是*ecdsa.PrivateKey
+		legacy, legacyErr := loadLegacyWallets(content)
+		if legacyErr != nil {
+			return fmt.Errorf("%w: %s 解码失败: %v", ErrWalletFile, WalletName, err)
+		}
+		wallets = *legacy
+	}
+
+	ws.WalletsMap = wallets.WalletsMap
+	ws.State = wallets.State
+
+	return nil
+}
+
+
+//旧版本的钱包直接gob编码*ecdsa.PrivateKey，解码时只取出D，忽略带曲线接口的PublicKey字段
+type legacyPrivateKey struct {
+	D *big.Int
+}
+
+type legacyWalletKeyPair struct {
+	PrivateKey *legacyPrivateKey
+	PublicKey  []byte
+}
+
+type legacyWallets struct {
+	WalletsMap map[string]*legacyWalletKeyPair
+}
+
+//旧钱包都是P-256，公钥保持原来的字节流，这样地址不变，原来地址上的钱也能继续花费
+func loadLegacyWallets(content []byte) (*Wallets, error) {
+	var legacy legacyWallets
+
+	decoder := gob.NewDecoder(bytes.NewReader(content))
+	err := decoder.Decode(&legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := Wallets{WalletsMap: make(map[string]*WalletKeyPair)}
+
+	for address, pair := range legacy.WalletsMap {
+		if pair.PrivateKey == nil || pair.PrivateKey.D == nil {
+			return nil, fmt.Errorf("钱包 %s 缺少私钥", address)
+		}
+
+		privateKey := make([]byte, scalarLen)
+		pair.PrivateKey.D.FillBytes(privateKey)
+
+		ws.WalletsMap[address] = &WalletKeyPair{
+			KeyType:    KeyTypeP256,
+			PrivateKey: privateKey,
+			PublicKey:  pair.PublicKey,
+		}
+	}
+
+	return &ws, nil
+}
+
+func (ws *Wallets) ListAddress() []string {
+	//遍历ws.WalletsMap结构返回key即可
+	var addresses []string
+
+	for address,_ := range ws.WalletsMap {
+		addresses = append(addresses,address)
+	}
+
+	return addresses
+}
+
+func isFileExist(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return !os.IsNotExist(err)
+}